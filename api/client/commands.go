@@ -26,6 +26,7 @@ import (
 	"github.com/dotcloud/docker/dockerversion"
 	"github.com/dotcloud/docker/engine"
 	"github.com/dotcloud/docker/nat"
+	"github.com/dotcloud/docker/opts"
 	"github.com/dotcloud/docker/pkg/signal"
 	"github.com/dotcloud/docker/pkg/term"
 	"github.com/dotcloud/docker/pkg/units"
@@ -50,9 +51,10 @@ func (cli *DockerCli) CmdHelp(args ...string) error {
 		{"build", "Build a container from a Dockerfile"},
 		{"commit", "Create a new image from a container's changes"},
 		{"cp", "Copy files/folders from the containers filesystem to the host path"},
+		{"df", "Show the space used by the images, containers and volumes"},
 		{"diff", "Inspect changes on a container's filesystem"},
 		{"events", "Get real time events from the server"},
-		{"export", "Stream the contents of a container as a tar archive"},
+		{"export", "Stream the contents of a container and its configuration as a tar archive"},
 		{"history", "Show the history of an image"},
 		{"images", "List images"},
 		{"import", "Create a new filesystem image from the contents of a tarball"},
@@ -63,6 +65,7 @@ func (cli *DockerCli) CmdHelp(args ...string) error {
 		{"login", "Register or Login to the docker registry server"},
 		{"logs", "Fetch the logs of a container"},
 		{"port", "Lookup the public-facing port which is NAT-ed to PRIVATE_PORT"},
+		{"prune", "Remove the unused containers, images, volumes and layers"},
 		{"ps", "List containers"},
 		{"pull", "Pull an image or a repository from the docker registry server"},
 		{"push", "Push an image or a repository to the docker registry server"},
@@ -72,9 +75,11 @@ func (cli *DockerCli) CmdHelp(args ...string) error {
 		{"run", "Run a command in a new container"},
 		{"save", "Save an image to a tar archive"},
 		{"search", "Search for an image in the docker index"},
+		{"share", "Add the layers of an image to a layer store shared by daemons"},
 		{"start", "Start a stopped container"},
 		{"stop", "Stop a running container"},
 		{"tag", "Tag an image into a repository"},
+		{"tags", "List the tags of a repository in its registry"},
 		{"top", "Lookup the running processes of a container"},
 		{"version", "Show the docker version information"},
 		{"wait", "Block until a container stops, then print its exit code"},
@@ -200,6 +205,9 @@ func (cli *DockerCli) CmdBuild(args ...string) error {
 	}
 
 	cli.LoadConfigFile()
+	if err := cli.configFile.LoadCredentials(); err != nil {
+		return err
+	}
 
 	headers := http.Header(make(map[string][]string))
 	buf, err := json.Marshal(cli.configFile)
@@ -259,9 +267,9 @@ func (cli *DockerCli) CmdLogin(args ...string) error {
 	}
 
 	cli.LoadConfigFile()
-	authconfig, ok := cli.configFile.Configs[serverAddress]
-	if !ok {
-		authconfig = registry.AuthConfig{}
+	authconfig := registry.AuthConfig{}
+	if _, ok := cli.configFile.Configs[serverAddress]; ok {
+		authconfig = cli.configFile.ResolveAuthConfig(serverAddress)
 	}
 
 	if username == "" {
@@ -305,8 +313,12 @@ func (cli *DockerCli) CmdLogin(args ...string) error {
 
 	stream, statusCode, err := cli.call("POST", "/auth", cli.configFile.Configs[serverAddress], false)
 	if statusCode == 401 {
-		delete(cli.configFile.Configs, serverAddress)
-		registry.SaveConfig(cli.configFile)
+		if err := cli.configFile.Erase(serverAddress); err != nil {
+			return err
+		}
+		if err := registry.SaveConfig(cli.configFile); err != nil {
+			return err
+		}
 		return err
 	}
 	if err != nil {
@@ -318,7 +330,9 @@ func (cli *DockerCli) CmdLogin(args ...string) error {
 		cli.configFile, _ = registry.LoadConfig(os.Getenv("HOME"))
 		return err
 	}
-	registry.SaveConfig(cli.configFile)
+	if err := registry.SaveConfig(cli.configFile); err != nil {
+		return err
+	}
 	if out2.Get("Status") != "" {
 		fmt.Fprintf(cli.out, "%s\n", out2.Get("Status"))
 	}
@@ -451,7 +465,7 @@ func (cli *DockerCli) CmdInfo(args ...string) error {
 
 	if len(remoteInfo.GetList("IndexServerAddress")) != 0 {
 		cli.LoadConfigFile()
-		u := cli.configFile.Configs[remoteInfo.Get("IndexServerAddress")].Username
+		u := cli.configFile.ResolveAuthConfig(remoteInfo.Get("IndexServerAddress")).Username
 		if len(u) > 0 {
 			fmt.Fprintf(cli.out, "Username: %v\n", u)
 			fmt.Fprintf(cli.out, "Registry: %v\n", remoteInfo.GetList("IndexServerAddress"))
@@ -963,7 +977,9 @@ func (cli *DockerCli) CmdKill(args ...string) error {
 }
 
 func (cli *DockerCli) CmdImport(args ...string) error {
-	cmd := cli.Subcmd("import", "URL|- [REPOSITORY[:TAG]]", "Create an empty filesystem image and import the contents of the tarball (.tar, .tar.gz, .tgz, .bzip, .tar.xz, .txz) into it, then optionally tag it.")
+	cmd := cli.Subcmd("import", "[OPTIONS] URL|- [REPOSITORY[:TAG]]", "Create an empty filesystem image and import the contents of the tarball (.tar, .tar.gz, .tgz, .bzip, .tar.xz, .txz) into it, then optionally tag it.")
+	flChanges := opts.NewListOpts(nil)
+	cmd.Var(&flChanges, []string{"c", "-change"}, "Apply a Dockerfile instruction (CMD, ENTRYPOINT, ENV, EXPOSE, USER, VOLUME or WORKDIR) to the configuration of the image")
 
 	if err := cmd.Parse(args); err != nil {
 		return nil
@@ -994,6 +1010,9 @@ func (cli *DockerCli) CmdImport(args ...string) error {
 	v.Set("repo", repository)
 	v.Set("tag", tag)
 	v.Set("fromSrc", src)
+	for _, change := range flChanges.GetAll() {
+		v.Add("changes", change)
+	}
 
 	var in io.Reader
 
@@ -1032,7 +1051,7 @@ func (cli *DockerCli) CmdPush(args ...string) error {
 	// Custom repositories can have different rules, and we must also
 	// allow pushing by image ID.
 	if len(strings.SplitN(name, "/", 2)) == 1 {
-		username := cli.configFile.Configs[registry.IndexServerAddress()].Username
+		username := cli.configFile.ResolveAuthConfig(registry.IndexServerAddress()).Username
 		if username == "" {
 			username = "<user>"
 		}
@@ -1069,8 +1088,96 @@ func (cli *DockerCli) CmdPush(args ...string) error {
 	return nil
 }
 
+func (cli *DockerCli) CmdPrune(args ...string) error {
+	var (
+		cmd     = cli.Subcmd("prune", "[OPTIONS]", "Remove the stopped containers, the untagged images and the volumes no container uses,\nand the layers and temporary files left by the storage driver")
+		dryRun  = cmd.Bool([]string{"n", "-dry-run"}, false, "Only list what would be removed")
+		until   = cmd.String([]string{"-until"}, "24h", "Only remove the containers stopped for longer than this duration")
+		noTrunc = cmd.Bool([]string{"#notrunc", "-no-trunc"}, false, "Don't truncate output")
+	)
+	if err := cmd.Parse(args); err != nil {
+		return nil
+	}
+	if cmd.NArg() != 0 {
+		cmd.Usage()
+		return nil
+	}
+
+	v := url.Values{}
+	if *dryRun {
+		v.Set("dryrun", "1")
+	}
+	if *until != "" {
+		v.Set("until", *until)
+	}
+	body, _, err := readBody(cli.call("POST", "/prune?"+v.Encode(), nil, false))
+	if err != nil {
+		return err
+	}
+	outs := engine.NewTable("", 0)
+	if _, err := outs.ReadListFrom(body); err != nil {
+		return err
+	}
+
+	var total int64
+	w := tabwriter.NewWriter(cli.out, 20, 1, 3, ' ', 0)
+	fmt.Fprint(w, "TYPE\tID\tSIZE\n")
+	for _, out := range outs.Data {
+		id := out.Get("ID")
+		if !*noTrunc {
+			id = utils.TruncateID(id)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", out.Get("Type"), id, units.HumanSize(out.GetInt64("Size")))
+		total += out.GetInt64("Size")
+	}
+	w.Flush()
+	if *dryRun {
+		fmt.Fprintf(cli.out, "Total reclaimable space: %s\n", units.HumanSize(total))
+	} else {
+		fmt.Fprintf(cli.out, "Total reclaimed space: %s\n", units.HumanSize(total))
+	}
+	return nil
+}
+
+func (cli *DockerCli) CmdShare(args ...string) error {
+	var (
+		cmd     = cli.Subcmd("share", "[OPTIONS] STORE IMAGE", "Add the layers of an image and of its parents to the layer store STORE of the daemon's host,\nthe daemons started with --layer-store=STORE copy them rather than downloading them")
+		noTrunc = cmd.Bool([]string{"#notrunc", "-no-trunc"}, false, "Don't truncate output")
+	)
+	if err := cmd.Parse(args); err != nil {
+		return nil
+	}
+	if cmd.NArg() != 2 {
+		cmd.Usage()
+		return nil
+	}
+
+	v := url.Values{}
+	v.Set("store", cmd.Arg(0))
+	body, _, err := readBody(cli.call("POST", "/images/"+cmd.Arg(1)+"/share?"+v.Encode(), nil, false))
+	if err != nil {
+		return err
+	}
+	outs := engine.NewTable("", 0)
+	if _, err := outs.ReadListFrom(body); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(cli.out, 20, 1, 3, ' ', 0)
+	fmt.Fprint(w, "IMAGE ID\tCHECKSUM\n")
+	for _, out := range outs.Data {
+		id := out.Get("ID")
+		if !*noTrunc {
+			id = utils.TruncateID(id)
+		}
+		fmt.Fprintf(w, "%s\t%s\n", id, out.Get("Checksum"))
+	}
+	w.Flush()
+	return nil
+}
+
 func (cli *DockerCli) CmdPull(args ...string) error {
-	cmd := cli.Subcmd("pull", "NAME[:TAG]", "Pull an image or a repository from the registry")
+	cmd := cli.Subcmd("pull", "NAME[:TAG|@DIGEST]", "Pull an image or a repository from the registry")
 	tag := cmd.String([]string{"#t", "#-tag"}, "", "Download tagged image in repository")
 	if err := cmd.Parse(args); err != nil {
 		return nil
@@ -1525,7 +1632,7 @@ func (cli *DockerCli) CmdEvents(args ...string) error {
 }
 
 func (cli *DockerCli) CmdExport(args ...string) error {
-	cmd := cli.Subcmd("export", "CONTAINER", "Export the contents of a filesystem as a tar archive to STDOUT")
+	cmd := cli.Subcmd("export", "CONTAINER", "Export the contents of a filesystem and its configuration as a tar archive to STDOUT")
 	if err := cmd.Parse(args); err != nil {
 		return nil
 	}
@@ -1541,6 +1648,96 @@ func (cli *DockerCli) CmdExport(args ...string) error {
 	return nil
 }
 
+func (cli *DockerCli) CmdDf(args ...string) error {
+	var (
+		cmd     = cli.Subcmd("df", "[OPTIONS]", "Show the space used by the images, containers and volumes")
+		verbose = cmd.Bool([]string{"v", "-verbose"}, false, "Show the space used by each image, container and volume")
+		noTrunc = cmd.Bool([]string{"#notrunc", "-no-trunc"}, false, "Don't truncate output")
+	)
+	if err := cmd.Parse(args); err != nil {
+		return nil
+	}
+	if cmd.NArg() != 0 {
+		cmd.Usage()
+		return nil
+	}
+
+	stream, _, err := cli.call("GET", "/df", nil, false)
+	if err != nil {
+		return err
+	}
+	var df engine.Env
+	if err := df.Decode(stream); err != nil {
+		return err
+	}
+	lists := make(map[string]*engine.Table)
+	for _, key := range []string{"Images", "Containers", "Volumes"} {
+		lists[key] = engine.NewTable("", 0)
+		if _, err := lists[key].ReadListFrom([]byte(df.Get(key))); err != nil {
+			return err
+		}
+	}
+
+	reclaimable := func(reclaimable, size int64) string {
+		if size == 0 {
+			return units.HumanSize(reclaimable)
+		}
+		return fmt.Sprintf("%s (%d%%)", units.HumanSize(reclaimable), reclaimable*100/size)
+	}
+	truncate := func(id string) string {
+		if *noTrunc {
+			return id
+		}
+		return utils.TruncateID(id)
+	}
+
+	w := tabwriter.NewWriter(cli.out, 20, 1, 3, ' ', 0)
+	fmt.Fprint(w, "TYPE\tTOTAL\tACTIVE\tSIZE\tRECLAIMABLE\n")
+	fmt.Fprintf(w, "Images\t%d\t%d\t%s\t%s\n", lists["Images"].Len(), df.GetInt("ImagesActive"),
+		units.HumanSize(df.GetInt64("LayersSize")), reclaimable(df.GetInt64("ImagesReclaimable"), df.GetInt64("LayersSize")))
+	fmt.Fprintf(w, "Containers\t%d\t%d\t%s\t%s\n", lists["Containers"].Len(), df.GetInt("ContainersActive"),
+		units.HumanSize(df.GetInt64("ContainersSize")), reclaimable(df.GetInt64("ContainersReclaimable"), df.GetInt64("ContainersSize")))
+	fmt.Fprintf(w, "Volumes\t%d\t%d\t%s\t%s\n", lists["Volumes"].Len(), df.GetInt("VolumesActive"),
+		units.HumanSize(df.GetInt64("VolumesSize")), reclaimable(df.GetInt64("VolumesReclaimable"), df.GetInt64("VolumesSize")))
+	w.Flush()
+	if !*verbose {
+		return nil
+	}
+
+	fmt.Fprint(cli.out, "\nImages space usage:\n\n")
+	w = tabwriter.NewWriter(cli.out, 20, 1, 3, ' ', 0)
+	fmt.Fprint(w, "REPOSITORY\tTAG\tIMAGE ID\tCREATED\tSIZE\tSHARED SIZE\tUNIQUE SIZE\tCONTAINERS\n")
+	for _, out := range lists["Images"].Data {
+		for _, repotag := range out.GetList("RepoTags") {
+			repo, tag := utils.ParseRepositoryTag(repotag)
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s ago\t%s\t%s\t%s\t%d\n", repo, tag, truncate(out.Get("Id")),
+				units.HumanDuration(time.Now().UTC().Sub(time.Unix(out.GetInt64("Created"), 0))),
+				units.HumanSize(out.GetInt64("VirtualSize")), units.HumanSize(out.GetInt64("SharedSize")),
+				units.HumanSize(out.GetInt64("UniqueSize")), out.GetInt("Containers"))
+		}
+	}
+	w.Flush()
+
+	fmt.Fprint(cli.out, "\nContainers space usage:\n\n")
+	w = tabwriter.NewWriter(cli.out, 20, 1, 3, ' ', 0)
+	fmt.Fprint(w, "CONTAINER ID\tIMAGE\tCREATED\tSTATUS\tSIZE\tNAME\n")
+	for _, out := range lists["Containers"].Data {
+		fmt.Fprintf(w, "%s\t%s\t%s ago\t%s\t%s\t%s\n", truncate(out.Get("Id")), out.Get("Image"),
+			units.HumanDuration(time.Now().UTC().Sub(time.Unix(out.GetInt64("Created"), 0))),
+			out.Get("Status"), units.HumanSize(out.GetInt64("SizeRw")), strings.TrimPrefix(out.Get("Name"), "/"))
+	}
+	w.Flush()
+
+	fmt.Fprint(cli.out, "\nVolumes space usage:\n\n")
+	w = tabwriter.NewWriter(cli.out, 20, 1, 3, ' ', 0)
+	fmt.Fprint(w, "VOLUME ID\tSIZE\tCONTAINERS\n")
+	for _, out := range lists["Volumes"].Data {
+		fmt.Fprintf(w, "%s\t%s\t%d\n", truncate(out.Get("Id")), units.HumanSize(out.GetInt64("Size")), out.GetInt("Containers"))
+	}
+	w.Flush()
+	return nil
+}
+
 func (cli *DockerCli) CmdDiff(args ...string) error {
 	cmd := cli.Subcmd("diff", "CONTAINER", "Inspect changes on a container's filesystem")
 	if err := cmd.Parse(args); err != nil {
@@ -1682,8 +1879,12 @@ func (cli *DockerCli) CmdAttach(args ...string) error {
 func (cli *DockerCli) CmdSearch(args ...string) error {
 	cmd := cli.Subcmd("search", "TERM", "Search the docker index for images")
 	noTrunc := cmd.Bool([]string{"#notrunc", "-no-trunc"}, false, "Don't truncate output")
-	trusted := cmd.Bool([]string{"t", "#trusted", "-trusted"}, false, "Only show trusted builds")
+	trusted := cmd.Bool([]string{"t", "#trusted", "-trusted"}, false, "Only show trusted (automated) builds")
+	official := cmd.Bool([]string{"-official"}, false, "Only show official images")
 	stars := cmd.Int([]string{"s", "#stars", "-stars"}, 0, "Only displays with at least xxx stars")
+	registryHost := cmd.String([]string{"-registry"}, "", "Search the private registry at this host instead of the index")
+	page := cmd.Int([]string{"-page"}, 0, "Show this page of the results")
+	limit := cmd.Int([]string{"-limit"}, 0, "Number of results by page")
 	if err := cmd.Parse(args); err != nil {
 		return nil
 	}
@@ -1694,8 +1895,30 @@ func (cli *DockerCli) CmdSearch(args ...string) error {
 
 	v := url.Values{}
 	v.Set("term", cmd.Arg(0))
+	if *registryHost != "" {
+		v.Set("registry", *registryHost)
+	}
+	if *trusted {
+		v.Set("automated", "1")
+	}
+	if *official {
+		v.Set("official", "1")
+	}
+	if *stars > 0 {
+		v.Set("stars", strconv.Itoa(*stars))
+	}
+	if *page > 0 {
+		v.Set("page", strconv.Itoa(*page))
+	}
+	if *limit > 0 {
+		v.Set("limit", strconv.Itoa(*limit))
+	}
 
-	body, _, err := readBody(cli.call("GET", "/images/search?"+v.Encode(), nil, true))
+	hostname := registry.IndexServerAddress()
+	if *registryHost != "" {
+		hostname = *registryHost
+	}
+	body, _, err := readBody(cli.callWithAuth("GET", "/images/search?"+v.Encode(), nil, hostname))
 
 	if err != nil {
 		return err
@@ -1707,9 +1930,6 @@ func (cli *DockerCli) CmdSearch(args ...string) error {
 	w := tabwriter.NewWriter(cli.out, 10, 1, 3, ' ', 0)
 	fmt.Fprintf(w, "NAME\tDESCRIPTION\tSTARS\tOFFICIAL\tTRUSTED\n")
 	for _, out := range outs.Data {
-		if (*trusted && !out.GetBool("is_trusted")) || (*stars > out.GetInt("star_count")) {
-			continue
-		}
 		desc := strings.Replace(out.Get("description"), "\n", " ", -1)
 		desc = strings.Replace(desc, "\r", " ", -1)
 		if !*noTrunc && len(desc) > 45 {
@@ -1730,6 +1950,52 @@ func (cli *DockerCli) CmdSearch(args ...string) error {
 	return nil
 }
 
+func (cli *DockerCli) CmdTags(args ...string) error {
+	cmd := cli.Subcmd("tags", "[OPTIONS] NAME", "List the tags of a repository in its registry")
+	noTrunc := cmd.Bool([]string{"#notrunc", "-no-trunc"}, false, "Don't truncate output")
+	page := cmd.Int([]string{"-page"}, 0, "Show this page of the tags")
+	limit := cmd.Int([]string{"-limit"}, 0, "Number of tags by page")
+	if err := cmd.Parse(args); err != nil {
+		return nil
+	}
+	if cmd.NArg() != 1 {
+		cmd.Usage()
+		return nil
+	}
+
+	name := cmd.Arg(0)
+	hostname, _, err := registry.ResolveRepositoryName(name)
+	if err != nil {
+		return err
+	}
+	v := url.Values{}
+	if *page > 0 {
+		v.Set("page", strconv.Itoa(*page))
+	}
+	if *limit > 0 {
+		v.Set("limit", strconv.Itoa(*limit))
+	}
+	body, _, err := readBody(cli.callWithAuth("GET", "/images/"+name+"/tags?"+v.Encode(), nil, hostname))
+	if err != nil {
+		return err
+	}
+	outs := engine.NewTable("", 0)
+	if _, err := outs.ReadListFrom(body); err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(cli.out, 20, 1, 3, ' ', 0)
+	fmt.Fprintf(w, "TAG\tIMAGE ID\n")
+	for _, out := range outs.Data {
+		id := out.Get("Id")
+		if !*noTrunc {
+			id = utils.TruncateID(id)
+		}
+		fmt.Fprintf(w, "%s\t%s\n", out.Get("Tag"), id)
+	}
+	w.Flush()
+	return nil
+}
+
 // Ports type - Used to parse multiple -p flags
 type ports []int
 
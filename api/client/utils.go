@@ -40,6 +40,16 @@ func (cli *DockerCli) HTTPClient() *http.Client {
 }
 
 func (cli *DockerCli) call(method, path string, data interface{}, passAuthInfo bool) (io.ReadCloser, int, error) {
+	hostname := ""
+	if passAuthInfo {
+		hostname = registry.IndexServerAddress()
+	}
+	return cli.callWithAuth(method, path, data, hostname)
+}
+
+// callWithAuth is call passing the credentials of the registry at hostname,
+// none when hostname is empty
+func (cli *DockerCli) callWithAuth(method, path string, data interface{}, hostname string) (io.ReadCloser, int, error) {
 	params := bytes.NewBuffer(nil)
 	if data != nil {
 		if env, ok := data.(engine.Env); ok {
@@ -61,10 +71,10 @@ func (cli *DockerCli) call(method, path string, data interface{}, passAuthInfo b
 	if err != nil {
 		return nil, -1, err
 	}
-	if passAuthInfo {
+	if hostname != "" {
 		cli.LoadConfigFile()
 		// Resolve the Auth config relevant for this server
-		authConfig := cli.configFile.ResolveAuthConfig(registry.IndexServerAddress())
+		authConfig := cli.configFile.ResolveAuthConfig(hostname)
 		getHeaders := func(authConfig registry.AuthConfig) (map[string][]string, error) {
 			buf, err := json.Marshal(authConfig)
 			if err != nil {
@@ -239,6 +239,12 @@ func getInfo(eng *engine.Engine, version version.Version, w http.ResponseWriter,
 	return nil
 }
 
+func getDf(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	var job = eng.Job("df")
+	streamJSON(job, w, false)
+	return job.Run()
+}
+
 func getEvents(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if err := parseForm(r); err != nil {
 		return err
@@ -450,6 +456,7 @@ func postImagesCreate(eng *engine.Engine, version version.Version, w http.Respon
 		job.SetenvJson("authConfig", authConfig)
 	} else { //import
 		job = eng.Job("import", r.Form.Get("fromSrc"), r.Form.Get("repo"), tag)
+		job.SetenvList("changes", r.Form["changes"])
 		job.Stdin.Add(r.Body)
 	}
 
@@ -497,6 +504,48 @@ func getImagesSearch(eng *engine.Engine, version version.Version, w http.Respons
 	var job = eng.Job("search", r.Form.Get("term"))
 	job.SetenvJson("metaHeaders", metaHeaders)
 	job.SetenvJson("authConfig", authConfig)
+	job.Setenv("registry", r.Form.Get("registry"))
+	job.Setenv("page", r.Form.Get("page"))
+	job.Setenv("limit", r.Form.Get("limit"))
+	job.Setenv("official", r.Form.Get("official"))
+	job.Setenv("automated", r.Form.Get("automated"))
+	job.Setenv("stars", r.Form.Get("stars"))
+	streamJSON(job, w, false)
+
+	return job.Run()
+}
+
+func getImagesTags(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	var (
+		authEncoded = r.Header.Get("X-Registry-Auth")
+		authConfig  = &registry.AuthConfig{}
+		metaHeaders = map[string][]string{}
+	)
+
+	if authEncoded != "" {
+		authJson := base64.NewDecoder(base64.URLEncoding, strings.NewReader(authEncoded))
+		if err := json.NewDecoder(authJson).Decode(authConfig); err != nil {
+			// the public repositories can be listed without auth
+			authConfig = &registry.AuthConfig{}
+		}
+	}
+	for k, v := range r.Header {
+		if strings.HasPrefix(k, "X-Meta-") {
+			metaHeaders[k] = v
+		}
+	}
+
+	var job = eng.Job("tags", vars["name"])
+	job.SetenvJson("metaHeaders", metaHeaders)
+	job.SetenvJson("authConfig", authConfig)
+	job.Setenv("page", r.Form.Get("page"))
+	job.Setenv("limit", r.Form.Get("limit"))
 	streamJSON(job, w, false)
 
 	return job.Run()
@@ -678,6 +727,30 @@ func deleteImages(eng *engine.Engine, version version.Version, w http.ResponseWr
 	return job.Run()
 }
 
+func postImagesShare(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	var job = eng.Job("share", r.Form.Get("store"), vars["name"])
+	streamJSON(job, w, false)
+	return job.Run()
+}
+
+func postPrune(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
+	var job = eng.Job("prune")
+	streamJSON(job, w, false)
+	job.Setenv("DryRun", r.Form.Get("dryrun"))
+	job.Setenv("Until", r.Form.Get("until"))
+
+	return job.Run()
+}
+
 func postContainersStart(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if vars == nil {
 		return fmt.Errorf("Missing parameter")
@@ -1044,6 +1117,7 @@ func createRouter(eng *engine.Engine, logging, enableCors bool, dockerVersion st
 			"/_ping":                          ping,
 			"/events":                         getEvents,
 			"/info":                           getInfo,
+			"/df":                             getDf,
 			"/version":                        getVersion,
 			"/images/json":                    getImagesJSON,
 			"/images/viz":                     getImagesViz,
@@ -1051,6 +1125,7 @@ func createRouter(eng *engine.Engine, logging, enableCors bool, dockerVersion st
 			"/images/{name:.*}/get":           getImagesGet,
 			"/images/{name:.*}/history":       getImagesHistory,
 			"/images/{name:.*}/json":          getImagesByName,
+			"/images/{name:.*}/tags":          getImagesTags,
 			"/containers/ps":                  getContainersJSON,
 			"/containers/json":                getContainersJSON,
 			"/containers/{name:.*}/export":    getContainersExport,
@@ -1068,6 +1143,7 @@ func createRouter(eng *engine.Engine, logging, enableCors bool, dockerVersion st
 			"/images/{name:.*}/insert":      postImagesInsert,
 			"/images/load":                  postImagesLoad,
 			"/images/{name:.*}/push":        postImagesPush,
+			"/images/{name:.*}/share":       postImagesShare,
 			"/images/{name:.*}/tag":         postImagesTag,
 			"/containers/create":            postContainersCreate,
 			"/containers/{name:.*}/kill":    postContainersKill,
@@ -1078,6 +1154,7 @@ func createRouter(eng *engine.Engine, logging, enableCors bool, dockerVersion st
 			"/containers/{name:.*}/resize":  postContainersResize,
 			"/containers/{name:.*}/attach":  postContainersAttach,
 			"/containers/{name:.*}/copy":    postContainersCopy,
+			"/prune":                        postPrune,
 		},
 		"DELETE": {
 			"/containers/{name:.*}": deleteContainers,
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
@@ -292,8 +293,17 @@ func collectFileInfo(sourceDir string) (*FileInfo, error) {
 	return root, nil
 }
 
-// Compare two directories and generate an array of Change objects describing the changes
+// Compare two directories and generate an array of Change objects describing the changes.
+// An empty oldDir is an empty directory, everything in newDir is added.
 func ChangesDirs(newDir, oldDir string) ([]Change, error) {
+	if oldDir == "" {
+		emptyDir, err := ioutil.TempDir("", "empty")
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(emptyDir)
+		oldDir = emptyDir
+	}
 	var (
 		oldRoot, newRoot *FileInfo
 		err1, err2       error
@@ -7,6 +7,7 @@ import (
 	apiserver "github.com/dotcloud/docker/api/server"
 	"github.com/dotcloud/docker/daemon"
 	"github.com/dotcloud/docker/daemon/networkdriver/bridge"
+	"github.com/dotcloud/docker/daemon/networkdriver/remote"
 	"github.com/dotcloud/docker/dockerversion"
 	"github.com/dotcloud/docker/engine"
 	"github.com/dotcloud/docker/registry"
@@ -57,7 +58,13 @@ func registerDaemon(eng *engine.Engine) error {
 	if err := eng.Register("initserver", server.InitServer); err != nil {
 		return err
 	}
-	return eng.Register("init_networkdriver", bridge.InitDriver)
+	if err := eng.Register("migrate_storage", daemon.MigrateStorage); err != nil {
+		return err
+	}
+	if err := eng.Register("init_networkdriver", bridge.InitDriver); err != nil {
+		return err
+	}
+	return eng.Register("init_remote_networkdriver", remote.InitDriver)
 }
 
 // builtins jobs independent of any subsystem
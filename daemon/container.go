@@ -7,6 +7,7 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"os"
 	"path"
 	"path/filepath"
@@ -207,6 +208,22 @@ func populateCommand(c *Container, env []string) error {
 				Bridge:      network.Bridge,
 				IPAddress:   network.IPAddress,
 				IPPrefixLen: network.IPPrefixLen,
+				HairpinMode: network.HairpinMode,
+
+				HostInterface: network.HostInterface,
+			}
+		}
+	case "macvlan", "ipvlan":
+		if !c.Config.NetworkDisabled {
+			network := c.NetworkSettings
+			parent, mode := c.hostConfig.NetworkMode.ParentInterface()
+			en.Interface = &execdriver.NetworkInterface{
+				Gateway:     network.Gateway,
+				IPAddress:   network.IPAddress,
+				IPPrefixLen: network.IPPrefixLen,
+				Type:        parts[0],
+				Parent:      parent,
+				Mode:        mode,
 			}
 		}
 	case "container":
@@ -294,7 +311,13 @@ func (container *Container) Start() (err error) {
 	}
 	container.waitLock = make(chan struct{})
 
-	return container.waitForStart()
+	if err := container.waitForStart(); err != nil {
+		return err
+	}
+	// the parents are locked in turn, do not hold our own lock meanwhile
+	// since two containers can be linked to each other
+	go container.updateParentsLinks(container.linkTarget())
+	return nil
 }
 
 func (container *Container) Run() error {
@@ -385,11 +408,109 @@ func (container *Container) buildHostnameAndHostsFiles(IP string) error {
 	return etchosts.Build(container.HostsPath, IP, container.Config.Hostname, container.Config.Domainname, &extraContent)
 }
 
+// linkTarget is what the links to a container use of it, copied while
+// the container is locked
+type linkTarget struct {
+	id           string
+	ip           string
+	env          []string
+	exposedPorts map[nat.Port]struct{}
+}
+
+// linkTarget returns the copy of the container its links use, the caller
+// holds its lock
+func (container *Container) linkTarget() *linkTarget {
+	target := &linkTarget{
+		id:           container.ID,
+		ip:           container.NetworkSettings.IPAddress,
+		env:          append([]string(nil), container.Config.Env...),
+		exposedPorts: make(map[nat.Port]struct{}),
+	}
+	for port := range container.Config.ExposedPorts {
+		target.exposedPorts[port] = struct{}{}
+	}
+	return target
+}
+
+// updateParentsLinks points the hosts file entries and the iptables
+// link rules of the running containers linking to this container at
+// its current address, given by child. The <ALIAS>_* environment of
+// their process is left as is, it is refreshed when they are restarted.
+func (container *Container) updateParentsLinks(child *linkTarget) {
+	parents, err := container.daemon.Parents(container.Name)
+	if err != nil {
+		utils.Errorf("%s: Error getting the containers linking to it: %s", container.ID, err)
+		return
+	}
+	for _, parent := range parents {
+		if err := parent.updateLinks(child); err != nil {
+			utils.Errorf("%s: Error updating the link to %s: %s", parent.ID, container.ID, err)
+		}
+	}
+}
+
+// updateLinks refreshes the links of the container to child
+func (container *Container) updateLinks(child *linkTarget) error {
+	container.Lock()
+	defer container.Unlock()
+
+	if !container.State.IsRunning() {
+		return nil
+	}
+
+	children, err := container.daemon.Children(container.Name)
+	if err != nil {
+		return err
+	}
+	for linkAlias, c := range children {
+		if c.ID != child.id {
+			continue
+		}
+		_, alias := path.Split(linkAlias)
+
+		// The hosts file is shared with the host or with another
+		// container in those modes, it has no entry for the link
+		if mode := container.hostConfig.NetworkMode; !mode.IsHost() && !mode.IsContainer() {
+			if err := etchosts.Update(container.HostsPath, child.ip, alias); err != nil {
+				return err
+			}
+		}
+
+		if container.activeLinks == nil {
+			continue
+		}
+		if link, exists := container.activeLinks[alias]; exists {
+			if link.ChildIP == child.ip {
+				continue
+			}
+			link.Disable()
+		}
+		link, err := links.NewLink(
+			container.NetworkSettings.IPAddress,
+			child.ip,
+			linkAlias,
+			child.env,
+			child.exposedPorts,
+			container.daemon.eng)
+		if err != nil {
+			return err
+		}
+		container.activeLinks[alias] = link
+		if err := link.Enable(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (container *Container) allocateNetwork() error {
 	mode := container.hostConfig.NetworkMode
 	if container.Config.NetworkDisabled || mode.IsContainer() || mode.IsHost() {
 		return nil
 	}
+	if mode.IsMacVlan() || mode.IsIpVlan() {
+		return container.setStaticAddress()
+	}
 
 	var (
 		env *engine.Env
@@ -429,10 +550,17 @@ func (container *Container) allocateNetwork() error {
 
 	container.NetworkSettings.PortMapping = nil
 
-	for port := range portSpecs {
-		if err := container.allocatePort(eng, port, bindings); err != nil {
+	ports := sortedPorts(portSpecs)
+	for len(ports) > 0 {
+		n := portRangeLength(ports, bindings)
+		if n > 1 {
+			if err := container.allocatePortRange(eng, ports[:n], bindings); err != nil {
+				return err
+			}
+		} else if err := container.allocatePort(eng, ports[0], bindings); err != nil {
 			return err
 		}
+		ports = ports[n:]
 	}
 	container.WriteHostConfig()
 
@@ -441,17 +569,36 @@ func (container *Container) allocateNetwork() error {
 	container.NetworkSettings.IPAddress = env.Get("IP")
 	container.NetworkSettings.IPPrefixLen = env.GetInt("IPPrefixLen")
 	container.NetworkSettings.Gateway = env.Get("Gateway")
+	container.NetworkSettings.HairpinMode = env.GetBool("HairpinMode")
+	container.NetworkSettings.HostInterface = env.Get("HostInterface")
 
 	return nil
 }
 
+// setStaticAddress sets the network settings of a container whose
+// interface is not allocated by the network driver but given by the user
+func (container *Container) setStaticAddress() error {
+	ip, ipNet, err := net.ParseCIDR(container.hostConfig.IPAddress)
+	if err != nil {
+		return fmt.Errorf("invalid static address %s: %s", container.hostConfig.IPAddress, err)
+	}
+	prefixLen, _ := ipNet.Mask.Size()
+
+	container.NetworkSettings.PortMapping = nil
+	container.NetworkSettings.Ports = make(nat.PortMap)
+	container.NetworkSettings.IPAddress = ip.String()
+	container.NetworkSettings.IPPrefixLen = prefixLen
+	container.NetworkSettings.Gateway = container.hostConfig.Gateway
+	return nil
+}
+
 func (container *Container) releaseNetwork() {
 	if container.Config.NetworkDisabled {
 		return
 	}
-	eng := container.daemon.eng
-
-	eng.Job("release_interface", container.ID).Run()
+	if mode := container.hostConfig.NetworkMode; !mode.IsMacVlan() && !mode.IsIpVlan() {
+		container.daemon.eng.Job("release_interface", container.ID).Run()
+	}
 	container.NetworkSettings = &NetworkSettings{}
 }
 
@@ -728,21 +875,12 @@ func (container *Container) GetSize() (int64, int64) {
 	}
 	defer container.Unmount()
 
-	if differ, ok := container.daemon.driver.(graphdriver.Differ); ok {
-		sizeRw, err = differ.DiffSize(container.ID)
-		if err != nil {
-			utils.Errorf("Warning: driver %s couldn't return diff size of container %s: %s", driver, container.ID, err)
-			// FIXME: GetSize should return an error. Not changing it now in case
-			// there is a side-effect.
-			sizeRw = -1
-		}
-	} else {
-		changes, _ := container.Changes()
-		if changes != nil {
-			sizeRw = archive.ChangesSize(container.basefs, changes)
-		} else {
-			sizeRw = -1
-		}
+	sizeRw, err = graphdriver.GetDiffer(driver).DiffSize(container.ID, container.ID+"-init")
+	if err != nil {
+		utils.Errorf("Warning: driver %s couldn't return diff size of container %s: %s", driver, container.ID, err)
+		// FIXME: GetSize should return an error. Not changing it now in case
+		// there is a side-effect.
+		sizeRw = -1
 	}
 
 	if _, err = os.Stat(container.basefs); err != nil {
@@ -892,7 +1030,7 @@ func (container *Container) initializeNetworking() error {
 		container.ResolvConfPath = nc.ResolvConfPath
 		container.Config.Hostname = nc.Config.Hostname
 		container.Config.Domainname = nc.Config.Domainname
-	} else if container.daemon.config.DisableNetwork {
+	} else if mode := container.hostConfig.NetworkMode; container.daemon.config.DisableNetwork && !mode.IsMacVlan() && !mode.IsIpVlan() {
 		container.Config.NetworkDisabled = true
 		return container.buildHostnameAndHostsFiles("127.0.1.1")
 	} else {
@@ -1086,6 +1224,36 @@ func (container *Container) allocatePort(eng *engine.Engine, port nat.Port, bind
 	return nil
 }
 
+// allocatePortRange maps consecutive ports published on the same host
+// ports with a single allocate_port job
+func (container *Container) allocatePortRange(eng *engine.Engine, ports []nat.Port, bindings nat.PortMap) error {
+	var (
+		first = ports[0]
+		last  = ports[len(ports)-1]
+	)
+
+	job := eng.Job("allocate_port", container.ID)
+	job.Setenv("HostIP", bindings[first][0].HostIp)
+	job.Setenv("HostPort", first.Port())
+	job.Setenv("Proto", first.Proto())
+	job.Setenv("ContainerPort", first.Port())
+	job.Setenv("ContainerPortEnd", last.Port())
+
+	portEnv, err := job.Stdout.AddEnv()
+	if err != nil {
+		return err
+	}
+	if err := job.Run(); err != nil {
+		eng.Job("release_interface", container.ID).Run()
+		return err
+	}
+
+	for _, port := range ports {
+		bindings[port][0].HostIp = portEnv.Get("HostIP")
+	}
+	return nil
+}
+
 func (container *Container) GetProcessLabel() string {
 	// even if we have a process label return "" if we are running
 	// in privileged mode
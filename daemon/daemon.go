@@ -32,7 +32,9 @@ import (
 	"github.com/dotcloud/docker/pkg/networkfs/resolvconf"
 	"github.com/dotcloud/docker/pkg/selinux"
 	"github.com/dotcloud/docker/pkg/sysinfo"
+	"github.com/dotcloud/docker/registry"
 	"github.com/dotcloud/docker/runconfig"
+	"github.com/dotcloud/docker/trust"
 	"github.com/dotcloud/docker/utils"
 )
 
@@ -567,6 +569,11 @@ func (daemon *Daemon) newContainer(name string, config *runconfig.Config, img *i
 }
 
 func (daemon *Daemon) createRootfs(container *Container, img *image.Image) error {
+	quotaDriver, hasQuota := daemon.driver.(graphdriver.QuotaDriver)
+	if container.Config.StorageSize > 0 && !hasQuota {
+		return fmt.Errorf("The %s storage driver cannot limit the storage size of the containers", daemon.driver)
+	}
+
 	// Step 1: create the container directory.
 	// This doubles as a barrier to avoid race conditions.
 	if err := os.Mkdir(container.root, 0700); err != nil {
@@ -586,6 +593,9 @@ func (daemon *Daemon) createRootfs(container *Container, img *image.Image) error
 		return err
 	}
 
+	if container.Config.StorageSize > 0 {
+		return quotaDriver.CreateWithQuota(container.ID, initID, container.Config.StorageSize)
+	}
 	if err := daemon.driver.Create(container.ID, initID); err != nil {
 		return err
 	}
@@ -681,6 +691,36 @@ func (daemon *Daemon) Children(name string) (map[string]*Container, error) {
 	return children, nil
 }
 
+// Parents returns the running and stopped containers linking to the
+// container with the given name
+func (daemon *Daemon) Parents(name string) ([]*Container, error) {
+	name, err := GetFullContainerName(name)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := daemon.containerGraph.Parents(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		parents []*Container
+		seen    = make(map[string]bool)
+	)
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		c := daemon.Get(id)
+		if c == nil {
+			return nil, fmt.Errorf("Could not get container for id %s", id)
+		}
+		parents = append(parents, c)
+	}
+	return parents, nil
+}
+
 func (daemon *Daemon) RegisterLink(parent, child *Container, alias string) error {
 	fullName := path.Join(parent.Name, alias)
 	if !daemon.containerGraph.Exists(fullName) {
@@ -733,6 +773,10 @@ func NewDaemonFromDirectory(config *daemonconfig.Config, eng *engine.Engine) (*D
 		selinux.SetDisabled()
 	}
 
+	if err := registry.SetInsecureRegistries(config.InsecureRegistries); err != nil {
+		return nil, err
+	}
+
 	// Create the root directory if it doesn't exists
 	if err := os.MkdirAll(config.Root, 0700); err != nil && !os.IsExist(err) {
 		return nil, err
@@ -742,7 +786,7 @@ func NewDaemonFromDirectory(config *daemonconfig.Config, eng *engine.Engine) (*D
 	graphdriver.DefaultDriver = config.GraphDriver
 
 	// Load storage driver
-	driver, err := graphdriver.New(config.Root)
+	driver, err := graphdriver.New(config.Root, config.GraphOptions)
 	if err != nil {
 		return nil, err
 	}
@@ -769,9 +813,17 @@ func NewDaemonFromDirectory(config *daemonconfig.Config, eng *engine.Engine) (*D
 		return nil, err
 	}
 
+	if config.LayerStore != "" {
+		layerStore, err := graph.NewLayerStore(config.LayerStore)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't open the layer store: %s", err)
+		}
+		g.SetLayerStore(layerStore)
+	}
+
 	// We don't want to use a complex driver like aufs or devmapper
 	// for volumes, just a plain filesystem
-	volumesDriver, err := graphdriver.GetDriver("vfs", config.Root)
+	volumesDriver, err := graphdriver.GetDriver("vfs", config.Root, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -785,8 +837,21 @@ func NewDaemonFromDirectory(config *daemonconfig.Config, eng *engine.Engine) (*D
 	if err != nil {
 		return nil, fmt.Errorf("Couldn't create Tag store: %s", err)
 	}
+	utils.Debugf("Loading the signing and the trusted keys")
+	trustStore, err := trust.NewStore(path.Join(config.Root, "trust", "key.pem"), config.TrustKeys, config.RequireSignature)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't create Trust store: %s", err)
+	}
+	repositories.SetTrustStore(trustStore)
 
-	if !config.DisableNetwork {
+	if !config.DisableNetwork && config.NetworkPlugin != "" {
+		job := eng.Job("init_remote_networkdriver")
+		job.Setenv("Socket", config.NetworkPlugin)
+		job.SetenvInt("Mtu", config.Mtu)
+		if err := job.Run(); err != nil {
+			return nil, err
+		}
+	} else if !config.DisableNetwork {
 		job := eng.Job("init_networkdriver")
 
 		job.SetenvBool("EnableIptables", config.EnableIptables)
@@ -795,6 +860,15 @@ func NewDaemonFromDirectory(config *daemonconfig.Config, eng *engine.Engine) (*D
 		job.Setenv("BridgeIface", config.BridgeIface)
 		job.Setenv("BridgeIP", config.BridgeIP)
 		job.Setenv("DefaultBindingIP", config.DefaultIp.String())
+		job.Setenv("PortRange", config.PortRange)
+
+		enableUserlandProxy := config.EnableUserlandProxy
+		if !enableUserlandProxy && config.ExecDriver == "lxc" {
+			// lxc has no way to set hairpin mode on the bridge port of the container
+			log.Printf("WARNING: hairpin NAT is not supported by the lxc driver, falling back to the userland proxy")
+			enableUserlandProxy = true
+		}
+		job.SetenvBool("EnableUserlandProxy", enableUserlandProxy)
 
 		if err := job.Run(); err != nil {
 			return nil, err
@@ -926,46 +1000,15 @@ func (daemon *Daemon) Unmount(container *Container) error {
 }
 
 func (daemon *Daemon) Changes(container *Container) ([]archive.Change, error) {
-	if differ, ok := daemon.driver.(graphdriver.Differ); ok {
-		return differ.Changes(container.ID)
-	}
-	cDir, err := daemon.driver.Get(container.ID, "")
+	changes, err := graphdriver.GetDiffer(daemon.driver).Changes(container.ID, container.ID+"-init")
 	if err != nil {
-		return nil, fmt.Errorf("Error getting container rootfs %s from driver %s: %s", container.ID, container.daemon.driver, err)
+		return nil, fmt.Errorf("Error getting the changes of container %s from driver %s: %s", container.ID, daemon.driver, err)
 	}
-	defer daemon.driver.Put(container.ID)
-	initDir, err := daemon.driver.Get(container.ID+"-init", "")
-	if err != nil {
-		return nil, fmt.Errorf("Error getting container init rootfs %s from driver %s: %s", container.ID, container.daemon.driver, err)
-	}
-	defer daemon.driver.Put(container.ID + "-init")
-	return archive.ChangesDirs(cDir, initDir)
+	return changes, nil
 }
 
 func (daemon *Daemon) Diff(container *Container) (archive.Archive, error) {
-	if differ, ok := daemon.driver.(graphdriver.Differ); ok {
-		return differ.Diff(container.ID)
-	}
-
-	changes, err := daemon.Changes(container)
-	if err != nil {
-		return nil, err
-	}
-
-	cDir, err := daemon.driver.Get(container.ID, "")
-	if err != nil {
-		return nil, fmt.Errorf("Error getting container rootfs %s from driver %s: %s", container.ID, container.daemon.driver, err)
-	}
-
-	archive, err := archive.ExportChanges(cDir, changes)
-	if err != nil {
-		return nil, err
-	}
-	return utils.NewReadCloserWrapper(archive, func() error {
-		err := archive.Close()
-		daemon.driver.Put(container.ID)
-		return err
-	}), nil
+	return graphdriver.GetDiffer(daemon.driver).Diff(container.ID, container.ID+"-init")
 }
 
 func (daemon *Daemon) Run(c *Container, pipes *execdriver.Pipes, startCallback execdriver.StartCallback) (int, error) {
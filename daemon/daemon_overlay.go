@@ -0,0 +1,7 @@
+// +build !exclude_graphdriver_overlay
+
+package daemon
+
+import (
+	_ "github.com/dotcloud/docker/daemon/graphdriver/overlay"
+)
@@ -100,6 +100,17 @@ type NetworkInterface struct {
 	IPAddress   string `json:"ip"`
 	Bridge      string `json:"bridge"`
 	IPPrefixLen int    `json:"ip_prefix_len"`
+	HairpinMode bool   `json:"hairpin_mode"` // allow the container to reach itself through the host's published ports
+
+	// HostInterface is an existing interface of the host moved into the
+	// container, in place of a veth pair attached to Bridge
+	HostInterface string `json:"host_interface"`
+
+	// Type is macvlan or ipvlan when the interface is created on top of
+	// the Parent interface of the host in the given Mode, empty otherwise
+	Type   string `json:"type"`
+	Parent string `json:"parent"`
+	Mode   string `json:"mode"`
 }
 
 type Resources struct {
@@ -83,6 +83,9 @@ func (d *driver) Name() string {
 }
 
 func (d *driver) Run(c *execdriver.Command, pipes *execdriver.Pipes, startCallback execdriver.StartCallback) (int, error) {
+	if c.Network.Interface != nil && c.Network.Interface.Type == "ipvlan" {
+		return -1, fmt.Errorf("ipvlan networking is not supported by the %s driver", DriverName)
+	}
 	if err := execdriver.SetTerminal(c, pipes); err != nil {
 		return -1, err
 	}
@@ -11,8 +11,17 @@ import (
 const LxcTemplate = `
 {{if .Network.Interface}}
 # network configuration
+{{if eq .Network.Interface.Type "macvlan"}}
+lxc.network.type = macvlan
+lxc.network.link = {{.Network.Interface.Parent}}
+lxc.network.macvlan.mode = {{if .Network.Interface.Mode}}{{.Network.Interface.Mode}}{{else}}bridge{{end}}
+{{else if .Network.Interface.HostInterface}}
+lxc.network.type = phys
+lxc.network.link = {{.Network.Interface.HostInterface}}
+{{else}}
 lxc.network.type = veth
 lxc.network.link = {{.Network.Interface.Bridge}}
+{{end}}
 lxc.network.name = eth0
 lxc.network.mtu = {{.Network.Mtu}}
 {{else if .Network.HostNetworking}}
@@ -67,7 +67,29 @@ func (d *driver) createNetwork(container *libcontainer.Container, c *execdriver.
 		},
 	}
 
-	if c.Network.Interface != nil {
+	if c.Network.Interface != nil && c.Network.Interface.Type != "" {
+		container.Networks = append(container.Networks, &libcontainer.Network{
+			Mtu:     c.Network.Mtu,
+			Address: fmt.Sprintf("%s/%d", c.Network.Interface.IPAddress, c.Network.Interface.IPPrefixLen),
+			Gateway: c.Network.Interface.Gateway,
+			Type:    c.Network.Interface.Type,
+			Context: libcontainer.Context{
+				"prefix": c.Network.Interface.Type,
+				"parent": c.Network.Interface.Parent,
+				"mode":   c.Network.Interface.Mode,
+			},
+		})
+	} else if c.Network.Interface != nil && c.Network.Interface.HostInterface != "" {
+		container.Networks = append(container.Networks, &libcontainer.Network{
+			Mtu:     c.Network.Mtu,
+			Address: fmt.Sprintf("%s/%d", c.Network.Interface.IPAddress, c.Network.Interface.IPPrefixLen),
+			Gateway: c.Network.Interface.Gateway,
+			Type:    "phys",
+			Context: libcontainer.Context{
+				"link": c.Network.Interface.HostInterface,
+			},
+		})
+	} else if c.Network.Interface != nil {
 		vethNetwork := libcontainer.Network{
 			Mtu:     c.Network.Mtu,
 			Address: fmt.Sprintf("%s/%d", c.Network.Interface.IPAddress, c.Network.Interface.IPPrefixLen),
@@ -78,6 +100,9 @@ func (d *driver) createNetwork(container *libcontainer.Container, c *execdriver.
 				"bridge": c.Network.Interface.Bridge,
 			},
 		}
+		if c.Network.Interface.HairpinMode {
+			vethNetwork.Context["hairpin"] = "true"
+		}
 		container.Networks = append(container.Networks, &vethNetwork)
 	}
 
@@ -51,11 +51,14 @@ type Driver struct {
 
 // New returns a new AUFS driver.
 // An error is returned if AUFS is not supported.
-func Init(root string) (graphdriver.Driver, error) {
+func Init(root string, options []string) (graphdriver.Driver, error) {
 	// Try to load the aufs kernel module
 	if err := supportsAufs(); err != nil {
 		return nil, graphdriver.ErrNotSupported
 	}
+	if err := graphdriver.NoOptions("aufs", options); err != nil {
+		return nil, err
+	}
 	paths := []string{
 		"mnt",
 		"diff",
@@ -124,6 +127,11 @@ func (a Driver) Status() [][2]string {
 	}
 }
 
+// Layers returns the ids of all the layers registered with this driver
+func (a *Driver) Layers() ([]string, error) {
+	return loadIds(path.Join(a.rootPath(), "layers"))
+}
+
 // Exists returns true if the given id is registered with
 // this driver
 func (a Driver) Exists(id string) bool {
@@ -270,22 +278,22 @@ func (a *Driver) Put(id string) {
 }
 
 // Returns an archive of the contents for the id
-func (a *Driver) Diff(id string) (archive.Archive, error) {
+func (a *Driver) Diff(id, parent string) (archive.Archive, error) {
 	return archive.TarFilter(path.Join(a.rootPath(), "diff", id), &archive.TarOptions{
 		Compression: archive.Uncompressed,
 	})
 }
 
-func (a *Driver) ApplyDiff(id string, diff archive.ArchiveReader) error {
+func (a *Driver) ApplyDiff(id, parent string, diff archive.ArchiveReader) error {
 	return archive.Untar(diff, path.Join(a.rootPath(), "diff", id), nil)
 }
 
 // Returns the size of the contents for the id
-func (a *Driver) DiffSize(id string) (int64, error) {
+func (a *Driver) DiffSize(id, parent string) (int64, error) {
 	return utils.TreeSize(path.Join(a.rootPath(), "diff", id))
 }
 
-func (a *Driver) Changes(id string) ([]archive.Change, error) {
+func (a *Driver) Changes(id, parent string) ([]archive.Change, error) {
 	layers, err := a.getParentLayerPaths(id)
 	if err != nil {
 		return nil, err
@@ -12,6 +12,8 @@ import "C"
 import (
 	"fmt"
 	"github.com/dotcloud/docker/daemon/graphdriver"
+	"github.com/dotcloud/docker/utils"
+	"io/ioutil"
 	"os"
 	"path"
 	"syscall"
@@ -22,7 +24,7 @@ func init() {
 	graphdriver.Register("btrfs", Init)
 }
 
-func Init(home string) (graphdriver.Driver, error) {
+func Init(home string, options []string) (graphdriver.Driver, error) {
 	rootdir := path.Dir(home)
 
 	var buf syscall.Statfs_t
@@ -33,6 +35,9 @@ func Init(home string) (graphdriver.Driver, error) {
 	if buf.Type != 0x9123683E {
 		return nil, graphdriver.ErrNotSupported
 	}
+	if err := graphdriver.NoOptions("btrfs", options); err != nil {
+		return nil, err
+	}
 
 	return &Driver{
 		home: home,
@@ -147,6 +152,43 @@ func subvolDelete(path, name string) error {
 	return nil
 }
 
+// subvolEnableQuota enables the quotas of the filesystem of path
+func subvolEnableQuota(path string) error {
+	dir, err := openDir(path)
+	if err != nil {
+		return err
+	}
+	defer closeDir(dir)
+
+	var args C.struct_btrfs_ioctl_quota_ctl_args
+	args.cmd = C.BTRFS_QUOTA_CTL_ENABLE
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, getDirFd(dir), C.BTRFS_IOC_QUOTA_CTL,
+		uintptr(unsafe.Pointer(&args)))
+	if errno != 0 {
+		return fmt.Errorf("Failed to enable btrfs quota: %v", errno.Error())
+	}
+	return nil
+}
+
+// subvolLimitQgroup limits the data referenced by the subvolume path to size bytes
+func subvolLimitQgroup(path string, size uint64) error {
+	dir, err := openDir(path)
+	if err != nil {
+		return err
+	}
+	defer closeDir(dir)
+
+	var args C.struct_btrfs_ioctl_qgroup_limit_args
+	args.lim.max_referenced = C.__u64(size)
+	args.lim.flags = C.BTRFS_QGROUP_LIMIT_MAX_RFER
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, getDirFd(dir), C.BTRFS_IOC_QGROUP_LIMIT,
+		uintptr(unsafe.Pointer(&args)))
+	if errno != 0 {
+		return fmt.Errorf("Failed to limit the qgroup of %s: %v", path, errno.Error())
+	}
+	return nil
+}
+
 func (d *Driver) subvolumesDir() string {
 	return path.Join(d.home, "subvolumes")
 }
@@ -176,6 +218,29 @@ func (d *Driver) Create(id string, parent string) error {
 	return nil
 }
 
+// CreateWithQuota limits the data referenced by the subvolume
+// of the layer, its whole filesystem, with a qgroup
+func (d *Driver) CreateWithQuota(id, parent string, size int64) error {
+	if err := d.Create(id, parent); err != nil {
+		return err
+	}
+	dir := d.subvolumesDirId(id)
+	if err := subvolEnableQuota(dir); err != nil {
+		d.Remove(id)
+		return err
+	}
+	if err := subvolLimitQgroup(dir, uint64(size)); err != nil {
+		d.Remove(id)
+		return err
+	}
+	return nil
+}
+
+// Usage returns the size of the files of the subvolume of the layer
+func (d *Driver) Usage(id string) (int64, error) {
+	return utils.TreeSize(d.subvolumesDirId(id))
+}
+
 func (d *Driver) Remove(id string) error {
 	dir := d.subvolumesDirId(id)
 	if _, err := os.Stat(dir); err != nil {
@@ -211,3 +276,18 @@ func (d *Driver) Exists(id string) bool {
 	_, err := os.Stat(dir)
 	return err == nil
 }
+
+func (d *Driver) Layers() ([]string, error) {
+	files, err := ioutil.ReadDir(d.subvolumesDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	ids := make([]string, 0, len(files))
+	for _, file := range files {
+		ids = append(ids, file.Name())
+	}
+	return ids, nil
+}
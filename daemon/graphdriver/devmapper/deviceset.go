@@ -13,11 +13,14 @@ import (
 	"path"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/dotcloud/docker/daemon/graphdriver"
 	"github.com/dotcloud/docker/pkg/label"
+	"github.com/dotcloud/docker/pkg/units"
 	"github.com/dotcloud/docker/utils"
 )
 
@@ -25,6 +28,7 @@ var (
 	DefaultDataLoopbackSize     int64  = 100 * 1024 * 1024 * 1024
 	DefaultMetaDataLoopbackSize int64  = 2 * 1024 * 1024 * 1024
 	DefaultBaseFsSize           uint64 = 10 * 1024 * 1024 * 1024
+	DefaultFilesystem                  = "ext4"
 )
 
 type DevInfo struct {
@@ -64,6 +68,15 @@ type DeviceSet struct {
 	TransactionId    uint64
 	NewTransactionId uint64
 	nextDeviceId     int
+
+	// Options
+	dataLoopbackSize     int64
+	metaDataLoopbackSize int64
+	baseFsSize           uint64
+	filesystem           string
+	mountOptions         string
+	mkfsArgs             []string
+	thinPoolDevice       string
 }
 
 type DiskUsage struct {
@@ -126,6 +139,9 @@ func (devices *DeviceSet) oldMetadataFile() string {
 }
 
 func (devices *DeviceSet) getPoolName() string {
+	if devices.thinPoolDevice != "" {
+		return devices.thinPoolDevice
+	}
 	return devices.devicePrefix + "-pool"
 }
 
@@ -273,9 +289,19 @@ func (devices *DeviceSet) activateDeviceIfNeeded(info *DevInfo) error {
 func (devices *DeviceSet) createFilesystem(info *DevInfo) error {
 	devname := info.DevName()
 
-	err := exec.Command("mkfs.ext4", "-E", "discard,lazy_itable_init=0,lazy_journal_init=0", devname).Run()
-	if err != nil {
-		err = exec.Command("mkfs.ext4", "-E", "discard,lazy_itable_init=0", devname).Run()
+	args := append(append([]string{}, devices.mkfsArgs...), devname)
+
+	var err error
+	switch devices.filesystem {
+	case "xfs":
+		err = exec.Command("mkfs.xfs", args...).Run()
+	case "ext4":
+		err = exec.Command("mkfs.ext4", append([]string{"-E", "discard,lazy_itable_init=0,lazy_journal_init=0"}, args...)...).Run()
+		if err != nil {
+			err = exec.Command("mkfs.ext4", append([]string{"-E", "discard,lazy_itable_init=0"}, args...)...).Run()
+		}
+	default:
+		err = fmt.Errorf("Unsupported filesystem type %s", devices.filesystem)
 	}
 	if err != nil {
 		utils.Debugf("\n--->Err: %s\n", err)
@@ -377,8 +403,8 @@ func (devices *DeviceSet) setupBaseImage() error {
 	// Ids are 24bit, so wrap around
 	devices.nextDeviceId = (id + 1) & 0xffffff
 
-	utils.Debugf("Registering base device (id %v) with FS size %v", id, DefaultBaseFsSize)
-	info, err := devices.registerDevice(id, "", DefaultBaseFsSize)
+	utils.Debugf("Registering base device (id %v) with FS size %v", id, devices.baseFsSize)
+	info, err := devices.registerDevice(id, "", devices.baseFsSize)
 	if err != nil {
 		_ = deleteDevice(devices.getPoolDevName(), id)
 		utils.Debugf("\n--->Err: %s\n", err)
@@ -438,6 +464,10 @@ func minor(device uint64) uint64 {
 }
 
 func (devices *DeviceSet) ResizePool(size int64) error {
+	if devices.thinPoolDevice != "" {
+		return fmt.Errorf("Can't resize the thin pool %s, it was not created by docker", devices.thinPoolDevice)
+	}
+
 	dirname := devices.loopbackDir()
 	datafilename := path.Join(dirname, "data")
 	metadatafilename := path.Join(dirname, "metadata")
@@ -546,6 +576,9 @@ func (devices *DeviceSet) initDevmapper(doInit bool) error {
 
 	// If the pool doesn't exist, create it
 	if info.Exists == 0 {
+		if devices.thinPoolDevice != "" {
+			return fmt.Errorf("The thin pool %s does not exist", devices.thinPoolDevice)
+		}
 		utils.Debugf("Pool doesn't exist. Creating it.")
 
 		hasData := devices.hasImage("data")
@@ -560,12 +593,12 @@ func (devices *DeviceSet) initDevmapper(doInit bool) error {
 		}
 
 		createdLoopback = !hasData || !hasMetadata
-		data, err := devices.ensureImage("data", DefaultDataLoopbackSize)
+		data, err := devices.ensureImage("data", devices.dataLoopbackSize)
 		if err != nil {
 			utils.Debugf("Error device ensureImage (data): %s\n", err)
 			return err
 		}
-		metadata, err := devices.ensureImage("metadata", DefaultMetaDataLoopbackSize)
+		metadata, err := devices.ensureImage("metadata", devices.metaDataLoopbackSize)
 		if err != nil {
 			utils.Debugf("Error device ensureImage (metadata): %s\n", err)
 			return err
@@ -612,6 +645,13 @@ func (devices *DeviceSet) initDevmapper(doInit bool) error {
 }
 
 func (devices *DeviceSet) AddDevice(hash, baseHash string) error {
+	return devices.AddDeviceWithSize(hash, baseHash, 0)
+}
+
+// AddDeviceWithSize creates the device hash as a snapshot of baseHash grown
+// to size bytes, keeping the size of baseHash when size is 0. A snapshot
+// can't be shrunk, a size smaller than the one of baseHash is an error.
+func (devices *DeviceSet) AddDeviceWithSize(hash, baseHash string, size uint64) error {
 	baseInfo, err := devices.lookupDevice(baseHash)
 	if err != nil {
 		return err
@@ -627,6 +667,14 @@ func (devices *DeviceSet) AddDevice(hash, baseHash string) error {
 		return fmt.Errorf("device %s already exists", hash)
 	}
 
+	if size == 0 {
+		size = baseInfo.Size
+	}
+	if size < baseInfo.Size {
+		return fmt.Errorf("devicemapper: the storage size %s is smaller than the size of the base device, %s, it can only grow the filesystem of a container, lower dm.basesize to limit it",
+			units.HumanSize(int64(size)), units.HumanSize(int64(baseInfo.Size)))
+	}
+
 	deviceId := devices.nextDeviceId
 
 	if err := createSnapDevice(devices.getPoolDevName(), &deviceId, baseInfo.Name(), baseInfo.DeviceId); err != nil {
@@ -637,11 +685,54 @@ func (devices *DeviceSet) AddDevice(hash, baseHash string) error {
 	// Ids are 24bit, so wrap around
 	devices.nextDeviceId = (deviceId + 1) & 0xffffff
 
-	if _, err := devices.registerDevice(deviceId, hash, baseInfo.Size); err != nil {
+	info, err := devices.registerDevice(deviceId, hash, size)
+	if err != nil {
 		deleteDevice(devices.getPoolDevName(), deviceId)
 		utils.Debugf("Error registering device: %s\n", err)
 		return err
 	}
+
+	if size > baseInfo.Size {
+		if err := devices.growFs(info); err != nil {
+			devices.deleteDevice(info)
+			return err
+		}
+	}
+	return nil
+}
+
+// growFs grows the filesystem of a new device to the size of the device
+func (devices *DeviceSet) growFs(info *DevInfo) error {
+	if err := devices.activateDeviceIfNeeded(info); err != nil {
+		return fmt.Errorf("Error activating devmapper device for '%s': %s", info.Hash, err)
+	}
+	defer devices.deactivateDevice(info)
+
+	fsMountPoint := path.Join(devices.root, "mnt", "grow-"+info.Hash)
+	if err := os.MkdirAll(fsMountPoint, 0700); err != nil {
+		return err
+	}
+	defer os.RemoveAll(fsMountPoint)
+
+	options := devices.mountOptions
+	if devices.filesystem == "xfs" {
+		options = joinMountOptions(options, "nouuid")
+	}
+	if err := syscall.Mount(info.DevName(), fsMountPoint, devices.filesystem, syscall.MS_MGC_VAL, options); err != nil {
+		return fmt.Errorf("Error mounting '%s' on '%s': %s", info.DevName(), fsMountPoint, err)
+	}
+	defer syscall.Unmount(fsMountPoint, 0)
+
+	// Both grow mounted filesystems
+	var cmd *exec.Cmd
+	if devices.filesystem == "xfs" {
+		cmd = exec.Command("xfs_growfs", fsMountPoint)
+	} else {
+		cmd = exec.Command("resize2fs", info.DevName())
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("Failed to grow the filesystem of '%s': %s (%s)", info.DevName(), err, output)
+	}
 	return nil
 }
 
@@ -871,11 +962,14 @@ func (devices *DeviceSet) Shutdown() error {
 		info.lock.Unlock()
 	}
 
-	devices.Lock()
-	if err := devices.deactivatePool(); err != nil {
-		utils.Debugf("Shutdown deactivate pool , error: %s\n", err)
+	// A thin pool given by the user is left as is
+	if devices.thinPoolDevice == "" {
+		devices.Lock()
+		if err := devices.deactivatePool(); err != nil {
+			utils.Debugf("Shutdown deactivate pool , error: %s\n", err)
+		}
+		devices.Unlock()
 	}
-	devices.Unlock()
 
 	return nil
 }
@@ -907,11 +1001,17 @@ func (devices *DeviceSet) MountDevice(hash, path, mountLabel string) error {
 
 	var flags uintptr = syscall.MS_MGC_VAL
 
-	mountOptions := label.FormatMountLabel("discard", mountLabel)
-	err = syscall.Mount(info.DevName(), path, "ext4", flags, mountOptions)
+	options := devices.mountOptions
+	if devices.filesystem == "xfs" {
+		// The snapshots share the uuid of the base filesystem
+		options = joinMountOptions(options, "nouuid")
+	}
+
+	mountOptions := label.FormatMountLabel(joinMountOptions("discard", options), mountLabel)
+	err = syscall.Mount(info.DevName(), path, devices.filesystem, flags, mountOptions)
 	if err != nil && err == syscall.EINVAL {
-		mountOptions = label.FormatMountLabel("", mountLabel)
-		err = syscall.Mount(info.DevName(), path, "ext4", flags, mountOptions)
+		mountOptions = label.FormatMountLabel(options, mountLabel)
+		err = syscall.Mount(info.DevName(), path, devices.filesystem, flags, mountOptions)
 	}
 	if err != nil {
 		return fmt.Errorf("Error mounting '%s' on '%s': %s", info.DevName(), path, err)
@@ -971,6 +1071,25 @@ func (devices *DeviceSet) HasDevice(hash string) bool {
 	return info != nil
 }
 
+// List returns the hashes of all the devices but the base one
+func (devices *DeviceSet) List() ([]string, error) {
+	devices.Lock()
+	defer devices.Unlock()
+
+	files, err := ioutil.ReadDir(devices.metadataDir())
+	if err != nil {
+		return nil, err
+	}
+	hashes := []string{}
+	for _, file := range files {
+		// Skip the base device and the metadata being saved
+		if name := file.Name(); name != "base" && !strings.HasPrefix(name, ".") {
+			hashes = append(hashes, name)
+		}
+	}
+	return hashes, nil
+}
+
 func (devices *DeviceSet) HasActivatedDevice(hash string) bool {
 	info, _ := devices.lookupDevice(hash)
 	if info == nil {
@@ -1063,8 +1182,10 @@ func (devices *DeviceSet) Status() *Status {
 	status := &Status{}
 
 	status.PoolName = devices.getPoolName()
-	status.DataLoopback = path.Join(devices.loopbackDir(), "data")
-	status.MetadataLoopback = path.Join(devices.loopbackDir(), "metadata")
+	if devices.thinPoolDevice == "" {
+		status.DataLoopback = path.Join(devices.loopbackDir(), "data")
+		status.MetadataLoopback = path.Join(devices.loopbackDir(), "metadata")
+	}
 
 	totalSizeInSectors, _, dataUsed, dataTotal, metadataUsed, metadataTotal, err := devices.poolStatus()
 	if err == nil {
@@ -1084,12 +1205,74 @@ func (devices *DeviceSet) Status() *Status {
 	return status
 }
 
-func NewDeviceSet(root string, doInit bool) (*DeviceSet, error) {
+// joinMountOptions joins two comma separated lists of mount options
+func joinMountOptions(a, b string) string {
+	if a == "" {
+		return b
+	}
+	if b == "" {
+		return a
+	}
+	return a + "," + b
+}
+
+// NewDeviceSet returns the device set of root, configured by the options:
+//
+//   dm.basesize          size of the base device, hence the maximum size of the containers (default 10G)
+//   dm.loopdatasize      size of the data loopback file of the pool (default 100G)
+//   dm.loopmetadatasize  size of the metadata loopback file of the pool (default 2G)
+//   dm.fs                filesystem of the base device, ext4 or xfs (default ext4)
+//   dm.mkfsarg           extra argument of mkfs when creating the base filesystem, may be repeated
+//   dm.mountopt          extra comma separated mount options of the devices
+//   dm.thinpooldev       name of an existing thin pool device under /dev/mapper to use in place of loopback files
+func NewDeviceSet(root string, doInit bool, options []string) (*DeviceSet, error) {
 	SetDevDir("/dev")
 
 	devices := &DeviceSet{
-		root:     root,
-		MetaData: MetaData{Devices: make(map[string]*DevInfo)},
+		root:                 root,
+		MetaData:             MetaData{Devices: make(map[string]*DevInfo)},
+		dataLoopbackSize:     DefaultDataLoopbackSize,
+		metaDataLoopbackSize: DefaultMetaDataLoopbackSize,
+		baseFsSize:           DefaultBaseFsSize,
+		filesystem:           DefaultFilesystem,
+	}
+
+	for _, option := range options {
+		key, val, err := graphdriver.ParseOption(option)
+		if err != nil {
+			return nil, err
+		}
+		switch key {
+		case "dm.basesize", "dm.loopdatasize", "dm.loopmetadatasize":
+			size, err := units.RAMInBytes(val)
+			if err != nil {
+				return nil, fmt.Errorf("devicemapper: %s: %s", key, err)
+			}
+			if size <= 0 {
+				return nil, fmt.Errorf("devicemapper: %s should be positive", key)
+			}
+			switch key {
+			case "dm.basesize":
+				devices.baseFsSize = uint64(size)
+			case "dm.loopdatasize":
+				devices.dataLoopbackSize = size
+			case "dm.loopmetadatasize":
+				devices.metaDataLoopbackSize = size
+			}
+		case "dm.fs":
+			if val != "ext4" && val != "xfs" {
+				return nil, fmt.Errorf("devicemapper: unsupported filesystem %s, expected ext4 or xfs", val)
+			}
+			devices.filesystem = val
+		case "dm.mkfsarg":
+			devices.mkfsArgs = append(devices.mkfsArgs, val)
+		case "dm.mountopt":
+			devices.mountOptions = joinMountOptions(devices.mountOptions, val)
+		case "dm.thinpooldev":
+			devices.thinPoolDevice = strings.TrimPrefix(val, "/dev/mapper/")
+		default:
+			return nil, fmt.Errorf("devicemapper: unknown option %s", key)
+		}
 	}
 
 	if err := devices.initDevmapper(doInit); err != nil {
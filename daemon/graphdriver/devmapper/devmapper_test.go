@@ -32,6 +32,10 @@ func TestDevmapperCreateSnap(t *testing.T) {
 	graphtest.DriverTestCreateSnap(t, "devicemapper")
 }
 
+func TestDevmapperDiffApply(t *testing.T) {
+	graphtest.DriverTestDiffApply(t, "devicemapper")
+}
+
 func TestDevmapperTeardown(t *testing.T) {
 	graphtest.PutDriver(t)
 }
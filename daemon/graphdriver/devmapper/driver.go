@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"syscall"
 
 	"github.com/dotcloud/docker/daemon/graphdriver"
 	"github.com/dotcloud/docker/utils"
@@ -26,8 +27,8 @@ type Driver struct {
 	home string
 }
 
-func Init(home string) (graphdriver.Driver, error) {
-	deviceSet, err := NewDeviceSet(home, true)
+func Init(home string, options []string) (graphdriver.Driver, error) {
+	deviceSet, err := NewDeviceSet(home, true, options)
 	if err != nil {
 		return nil, err
 	}
@@ -69,6 +70,28 @@ func (d *Driver) Create(id, parent string) error {
 	return nil
 }
 
+// CreateWithQuota creates the device of the layer at size bytes, the
+// size of its whole filesystem. It can only grow the filesystem beyond the
+// size of the base device, a smaller size is an error.
+func (d *Driver) CreateWithQuota(id, parent string, size int64) error {
+	return d.DeviceSet.AddDeviceWithSize(id, parent, uint64(size))
+}
+
+// Usage returns the space used on the filesystem of the layer
+func (d *Driver) Usage(id string) (int64, error) {
+	dir, err := d.Get(id, "")
+	if err != nil {
+		return -1, err
+	}
+	defer d.Put(id)
+
+	var buf syscall.Statfs_t
+	if err := syscall.Statfs(dir, &buf); err != nil {
+		return -1, err
+	}
+	return int64(buf.Blocks-buf.Bfree) * buf.Bsize, nil
+}
+
 func (d *Driver) Remove(id string) error {
 	if !d.DeviceSet.HasDevice(id) {
 		// Consider removing a non-existing device a no-op
@@ -131,3 +154,7 @@ func (d *Driver) Put(id string) {
 func (d *Driver) Exists(id string) bool {
 	return d.DeviceSet.HasDevice(id)
 }
+
+func (d *Driver) Layers() ([]string, error) {
+	return d.DeviceSet.List()
+}
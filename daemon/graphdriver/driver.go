@@ -6,9 +6,12 @@ import (
 	"github.com/dotcloud/docker/archive"
 	"os"
 	"path"
+	"strings"
 )
 
-type InitFunc func(root string) (Driver, error)
+// InitFunc initializes a driver in root with the key=value options given
+// by --storage-opt, a driver refuses the options it does not know.
+type InitFunc func(root string, options []string) (Driver, error)
 
 type Driver interface {
 	String() string
@@ -25,11 +28,29 @@ type Driver interface {
 	Cleanup() error
 }
 
+// Differ is implemented by the drivers computing the diffs of their layers
+// themselves, see NaiveDiffDriver for the others. The parent of a layer is
+// the one it was created on, the drivers tracking it may ignore it.
 type Differ interface {
-	Diff(id string) (archive.Archive, error)
-	Changes(id string) ([]archive.Change, error)
-	ApplyDiff(id string, diff archive.ArchiveReader) error
-	DiffSize(id string) (bytes int64, err error)
+	Diff(id, parent string) (archive.Archive, error)
+	Changes(id, parent string) ([]archive.Change, error)
+	ApplyDiff(id, parent string, diff archive.ArchiveReader) error
+	DiffSize(id, parent string) (bytes int64, err error)
+}
+
+// QuotaDriver is implemented by the drivers able to limit the size of a layer
+type QuotaDriver interface {
+	// CreateWithQuota creates the layer id like Create,
+	// limiting the size of its content to size bytes
+	CreateWithQuota(id, parent string, size int64) error
+	// Usage returns the bytes used by the content of the layer id
+	Usage(id string) (bytes int64, err error)
+}
+
+// Lister is implemented by the drivers able to list their layers
+type Lister interface {
+	// Layers returns the ids of all the layers of the driver
+	Layers() ([]string, error)
 }
 
 var (
@@ -41,6 +62,8 @@ var (
 		"aufs",
 		"btrfs",
 		"devicemapper",
+		"overlay",
+		"zfs",
 		"vfs",
 	}
 
@@ -60,41 +83,99 @@ func Register(name string, initFunc InitFunc) error {
 	return nil
 }
 
-func GetDriver(name, home string) (Driver, error) {
+func GetDriver(name, home string, options []string) (Driver, error) {
 	if initFunc, exists := drivers[name]; exists {
-		return initFunc(path.Join(home, name))
+		return initFunc(path.Join(home, name), options)
 	}
 	return nil, ErrNotSupported
 }
 
-func New(root string) (driver Driver, err error) {
+// New returns the driver named by DOCKER_DRIVER or DefaultDriver, given all
+// the options, otherwise the first supported driver in order of priority.
+// While looking for it each driver is only given the options of its prefix,
+// see OptionPrefix, and the options of the other drivers are refused once
+// it is found.
+func New(root string, options []string) (driver Driver, err error) {
 	for _, name := range []string{os.Getenv("DOCKER_DRIVER"), DefaultDriver} {
 		if name != "" {
-			return GetDriver(name, root)
+			return GetDriver(name, root, options)
 		}
 	}
 
 	// Check for priority drivers first
 	for _, name := range priority {
-		driver, err = GetDriver(name, root)
+		driver, err = GetDriver(name, root, driverOptions(name, options))
 		if err != nil {
 			if err == ErrNotSupported {
 				continue
 			}
 			return nil, err
 		}
-		return driver, nil
+		return checkOptions(name, driver, options)
 	}
 
 	// Check all registered drivers if no priority driver is found
-	for _, initFunc := range drivers {
-		if driver, err = initFunc(root); err != nil {
+	for name, initFunc := range drivers {
+		if driver, err = initFunc(root, driverOptions(name, options)); err != nil {
 			if err == ErrNotSupported {
 				continue
 			}
 			return nil, err
 		}
-		return driver, nil
+		return checkOptions(name, driver, options)
 	}
 	return nil, fmt.Errorf("No supported storage backend found")
 }
+
+// OptionPrefix returns the prefix of the options of the driver name,
+// e.g. dm for the devicemapper options dm.basesize, dm.fs, ...
+func OptionPrefix(name string) string {
+	if name == "devicemapper" {
+		return "dm"
+	}
+	return name
+}
+
+func isDriverOption(name, option string) bool {
+	return strings.HasPrefix(strings.ToLower(option), OptionPrefix(name)+".")
+}
+
+// driverOptions returns the options of the driver name among options
+func driverOptions(name string, options []string) []string {
+	var own []string
+	for _, option := range options {
+		if isDriverOption(name, option) {
+			own = append(own, option)
+		}
+	}
+	return own
+}
+
+// checkOptions returns driver, or an error if options has options of
+// another driver than name
+func checkOptions(name string, driver Driver, options []string) (Driver, error) {
+	for _, option := range options {
+		if !isDriverOption(name, option) {
+			driver.Cleanup()
+			return nil, fmt.Errorf("Storage option %s is not an option of the %s driver, select its driver with -s", option, name)
+		}
+	}
+	return driver, nil
+}
+
+// ParseOption splits a key=value driver option, the key is lowercased
+func ParseOption(option string) (key string, value string, err error) {
+	parts := strings.SplitN(option, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", "", fmt.Errorf("Invalid storage option %s, expected key=value", option)
+	}
+	return strings.ToLower(strings.TrimSpace(parts[0])), strings.TrimSpace(parts[1]), nil
+}
+
+// NoOptions returns an error if a driver without options is given some
+func NoOptions(driver string, options []string) error {
+	if len(options) > 0 {
+		return fmt.Errorf("%s: unknown option %s", driver, options[0])
+	}
+	return nil
+}
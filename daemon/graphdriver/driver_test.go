@@ -0,0 +1,55 @@
+package graphdriver
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// optionsDriver is a driver of the test, it records the options it is given
+type optionsDriver struct {
+	options []string
+}
+
+func (d *optionsDriver) String() string                            { return "options" }
+func (d *optionsDriver) Create(id, parent string) error            { return nil }
+func (d *optionsDriver) Remove(id string) error                    { return nil }
+func (d *optionsDriver) Get(id, mountLabel string) (string, error) { return "", nil }
+func (d *optionsDriver) Put(id string)                             {}
+func (d *optionsDriver) Exists(id string) bool                     { return false }
+func (d *optionsDriver) Status() [][2]string                       { return nil }
+func (d *optionsDriver) Cleanup() error                            { return nil }
+
+func TestNewGivesEachDriverItsOptions(t *testing.T) {
+	root, err := ioutil.TempDir("", "docker-graphdriver-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	defer func(saved map[string]InitFunc) { drivers = saved }(drivers)
+	drivers = make(map[string]InitFunc)
+	// aufs is not supported on this host, it refuses any option
+	Register("aufs", func(root string, options []string) (Driver, error) {
+		if err := NoOptions("aufs", options); err != nil {
+			return nil, err
+		}
+		return nil, ErrNotSupported
+	})
+	Register("devicemapper", func(root string, options []string) (Driver, error) {
+		return &optionsDriver{options: options}, nil
+	})
+
+	driver, err := New(root, []string{"dm.basesize=20G", "DM.fs=xfs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options := driver.(*optionsDriver).options; !reflect.DeepEqual(options, []string{"dm.basesize=20G", "DM.fs=xfs"}) {
+		t.Fatalf("Expected devicemapper to be given its options, got %v", options)
+	}
+
+	if _, err := New(root, []string{"dm.basesize=20G", "zfs.fsname=tank"}); err == nil {
+		t.Fatal("Expected the option of another driver to be refused")
+	}
+}
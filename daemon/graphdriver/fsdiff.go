@@ -0,0 +1,137 @@
+package graphdriver
+
+import (
+	"time"
+
+	"github.com/dotcloud/docker/archive"
+	"github.com/dotcloud/docker/utils"
+)
+
+// NaiveDiffDriver gives a Driver without its own Differ, e.g. btrfs or
+// devmapper, the diffs of its layers by mounting a layer and its parent
+// and comparing their content.
+type NaiveDiffDriver struct {
+	Driver
+}
+
+func NewNaiveDiffDriver(driver Driver) *NaiveDiffDriver {
+	return &NaiveDiffDriver{driver}
+}
+
+// GetDiffer returns the Differ of driver, the driver itself if it
+// implements Differ, a NaiveDiffDriver otherwise
+func GetDiffer(driver Driver) Differ {
+	if differ, ok := driver.(Differ); ok {
+		return differ
+	}
+	return NewNaiveDiffDriver(driver)
+}
+
+// Diff returns the changes of the layer id from its parent as a layer
+// archive, the whole layer if it has no parent
+func (gdw *NaiveDiffDriver) Diff(id, parent string) (arch archive.Archive, err error) {
+	driver := gdw.Driver
+
+	layerFs, err := driver.Get(id, "")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			driver.Put(id)
+		}
+	}()
+
+	if parent == "" {
+		archive, err := archive.Tar(layerFs, archive.Uncompressed)
+		if err != nil {
+			return nil, err
+		}
+		return utils.NewReadCloserWrapper(archive, func() error {
+			err := archive.Close()
+			driver.Put(id)
+			return err
+		}), nil
+	}
+
+	parentFs, err := driver.Get(parent, "")
+	if err != nil {
+		return nil, err
+	}
+	defer driver.Put(parent)
+
+	changes, err := archive.ChangesDirs(layerFs, parentFs)
+	if err != nil {
+		return nil, err
+	}
+
+	archive, err := archive.ExportChanges(layerFs, changes)
+	if err != nil {
+		return nil, err
+	}
+	return utils.NewReadCloserWrapper(archive, func() error {
+		err := archive.Close()
+		driver.Put(id)
+		return err
+	}), nil
+}
+
+// Changes returns the changes of the layer id from its parent,
+// all its content if it has no parent
+func (gdw *NaiveDiffDriver) Changes(id, parent string) ([]archive.Change, error) {
+	driver := gdw.Driver
+
+	layerFs, err := driver.Get(id, "")
+	if err != nil {
+		return nil, err
+	}
+	defer driver.Put(id)
+
+	parentFs := ""
+	if parent != "" {
+		parentFs, err = driver.Get(parent, "")
+		if err != nil {
+			return nil, err
+		}
+		defer driver.Put(parent)
+	}
+	return archive.ChangesDirs(layerFs, parentFs)
+}
+
+// ApplyDiff extracts the layer archive diff in the layer id, a new
+// snapshot of parent
+func (gdw *NaiveDiffDriver) ApplyDiff(id, parent string, diff archive.ArchiveReader) error {
+	driver := gdw.Driver
+
+	layerFs, err := driver.Get(id, "")
+	if err != nil {
+		return err
+	}
+	defer driver.Put(id)
+
+	start := time.Now().UTC()
+	utils.Debugf("Start untar layer")
+	if err := archive.ApplyLayer(layerFs, diff); err != nil {
+		return err
+	}
+	utils.Debugf("Untar time: %vs", time.Now().UTC().Sub(start).Seconds())
+	return nil
+}
+
+// DiffSize returns the size of the changes of the layer id from its parent
+func (gdw *NaiveDiffDriver) DiffSize(id, parent string) (int64, error) {
+	driver := gdw.Driver
+
+	changes, err := gdw.Changes(id, parent)
+	if err != nil {
+		return -1, err
+	}
+
+	layerFs, err := driver.Get(id, "")
+	if err != nil {
+		return -1, err
+	}
+	defer driver.Put(id)
+
+	return archive.ChangesSize(layerFs, changes), nil
+}
@@ -1,10 +1,12 @@
 package graphtest
 
 import (
+	"github.com/dotcloud/docker/archive"
 	"github.com/dotcloud/docker/daemon/graphdriver"
 	"io/ioutil"
 	"os"
 	"path"
+	"sort"
 	"syscall"
 	"testing"
 )
@@ -19,7 +21,7 @@ type Driver struct {
 	refCount int
 }
 
-func newDriver(t *testing.T, name string) *Driver {
+func newDriver(t *testing.T, name string, options []string) *Driver {
 	root, err := ioutil.TempDir("/var/tmp", "docker-graphtest-")
 	if err != nil {
 		t.Fatal(err)
@@ -29,7 +31,7 @@ func newDriver(t *testing.T, name string) *Driver {
 		t.Fatal(err)
 	}
 
-	d, err := graphdriver.GetDriver(name, root)
+	d, err := graphdriver.GetDriver(name, root, options)
 	if err != nil {
 		if err == graphdriver.ErrNotSupported {
 			t.Skip("Driver %s not supported", name)
@@ -46,9 +48,11 @@ func cleanup(t *testing.T, d *Driver) {
 	os.RemoveAll(d.root)
 }
 
-func GetDriver(t *testing.T, name string) graphdriver.Driver {
+// GetDriver returns the driver name of the tests, created with the
+// given --storage-opt options by the first call
+func GetDriver(t *testing.T, name string, options ...string) graphdriver.Driver {
 	if drv == nil {
-		drv = newDriver(t, name)
+		drv = newDriver(t, name, options)
 	} else {
 		drv.refCount++
 	}
@@ -218,6 +222,17 @@ func DriverTestCreateSnap(t *testing.T, drivername string) {
 
 	verifyBase(t, driver, "Snap")
 
+	if lister, ok := driver.(graphdriver.Lister); ok {
+		layers, err := lister.Layers()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(layers)
+		if len(layers) != 2 || layers[0] != "Base" || layers[1] != "Snap" {
+			t.Fatalf("Expected the layers Base and Snap, got %v", layers)
+		}
+	}
+
 	if err := driver.Remove("Snap"); err != nil {
 		t.Fatal(err)
 	}
@@ -226,3 +241,100 @@ func DriverTestCreateSnap(t *testing.T, drivername string) {
 		t.Fatal(err)
 	}
 }
+
+// Verifies the diffs of the driver, its own ones or the naive ones:
+// the changes of a layer, and its diff applied on the same parent
+func DriverTestDiffApply(t *testing.T, drivername string) {
+	driver := GetDriver(t, drivername)
+	defer PutDriver(t)
+	differ := graphdriver.GetDiffer(driver)
+
+	createBase(t, driver, "Base")
+	if err := driver.Create("Child", "Base"); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := driver.Get("Child", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(path.Join(dir, "a file")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(dir, "a new file"), []byte("Some new data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(dir, "a subdir", "another file"), []byte("More data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	driver.Put("Child")
+
+	changes, err := differ.Changes("Child", "Base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]archive.ChangeType{
+		"/a file":                archive.ChangeDelete,
+		"/a new file":            archive.ChangeAdd,
+		"/a subdir/another file": archive.ChangeAdd,
+	}
+	for _, change := range changes {
+		// The parent dirs of the changes may or may not change
+		if change.Path == "/a subdir" {
+			continue
+		}
+		kind, exists := expected[change.Path]
+		if !exists || kind != change.Kind {
+			t.Fatalf("Unexpected change %s", change.String())
+		}
+		delete(expected, change.Path)
+	}
+	if len(expected) != 0 {
+		t.Fatalf("Missing changes %v in %v", expected, changes)
+	}
+
+	size, err := differ.DiffSize("Child", "Base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(len("Some new data")+len("More data")) {
+		t.Fatalf("Unexpected diff size %d", size)
+	}
+
+	diff, err := differ.Diff("Child", "Base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := driver.Create("Applied", "Base"); err != nil {
+		t.Fatal(err)
+	}
+	err = differ.ApplyDiff("Applied", "Base", diff)
+	diff.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err = driver.Get("Applied", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(path.Join(dir, "a file")); !os.IsNotExist(err) {
+		t.Fatalf("Expected the deleted file to be gone, got %v", err)
+	}
+	verifyFile(t, path.Join(dir, "a subdir"), 0705|os.ModeDir|os.ModeSticky, 1, 2)
+	verifyFile(t, path.Join(dir, "a new file"), 0644, 0, 0)
+	verifyFile(t, path.Join(dir, "a subdir", "another file"), 0600, 0, 0)
+	if data, err := ioutil.ReadFile(path.Join(dir, "a subdir", "another file")); err != nil || string(data) != "More data" {
+		t.Fatalf("Unexpected content %q (%v)", data, err)
+	}
+	driver.Put("Applied")
+
+	if size, err := differ.DiffSize("Applied", "Base"); err != nil || size != int64(len("Some new data")+len("More data")) {
+		t.Fatalf("Unexpected diff size %d of the applied layer (%v)", size, err)
+	}
+
+	for _, name := range []string{"Applied", "Child", "Base"} {
+		if err := driver.Remove(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
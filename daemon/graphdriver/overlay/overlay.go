@@ -0,0 +1,552 @@
+// +build linux
+
+/*
+
+overlay driver directory structure
+
+.
+└── <id>
+    ├── root      // Whole filesystem of an image layer
+    ├── lower-id  // Id of the image layer an overlay layer is mounted on
+    ├── upper     // Changes of an overlay layer
+    ├── work      // Work dir of the overlay mount
+    └── merged    // Mount point of the overlay layer
+
+The layers without parent and the ones a diff was applied to are image
+layers, their root holds their whole filesystem. The root of the child of
+an image layer is built by hard-linking the root of its parent before
+applying the diff. The other layers, e.g. the ones of the containers, are
+overlay mounts of their upper dir on the root of an image layer.
+
+*/
+
+package overlay
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/dotcloud/docker/archive"
+	"github.com/dotcloud/docker/daemon/graphdriver"
+	"github.com/dotcloud/docker/daemon/graphdriver/quota"
+	"github.com/dotcloud/docker/pkg/label"
+	mountpk "github.com/dotcloud/docker/pkg/mount"
+	"github.com/dotcloud/docker/pkg/system"
+	"github.com/dotcloud/docker/utils"
+)
+
+var (
+	ErrOverlayNotSupported = fmt.Errorf("overlay was not found in /proc/filesystems")
+)
+
+func init() {
+	graphdriver.Register("overlay", Init)
+}
+
+type Driver struct {
+	home       string
+	sync.Mutex // Protects concurrent modification to active
+	active     map[string]int
+
+	quotaOnce sync.Once
+	// nil when the backing filesystem has no project quotas
+	quotaCtl *quota.Control
+}
+
+// Init returns a new overlay driver.
+// An error is returned if overlay is not supported.
+func Init(home string, options []string) (graphdriver.Driver, error) {
+	if err := supportsOverlay(); err != nil {
+		return nil, graphdriver.ErrNotSupported
+	}
+	if err := graphdriver.NoOptions("overlay", options); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(home, 0700); err != nil {
+		return nil, err
+	}
+	return &Driver{
+		home:   home,
+		active: make(map[string]int),
+	}, nil
+}
+
+// Return a nil error if the kernel supports overlay
+func supportsOverlay() error {
+	// Try to load the overlay kernel module first,
+	// it fails inside dind
+	exec.Command("modprobe", "overlay").Run()
+
+	f, err := os.Open("/proc/filesystems")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if fields := strings.Fields(s.Text()); len(fields) > 0 && fields[len(fields)-1] == "overlay" {
+			return nil
+		}
+	}
+	return ErrOverlayNotSupported
+}
+
+func (d *Driver) String() string {
+	return "overlay"
+}
+
+func (d *Driver) Status() [][2]string {
+	files, _ := ioutil.ReadDir(d.home)
+	dirs := 0
+	for _, file := range files {
+		if file.IsDir() {
+			dirs++
+		}
+	}
+	return [][2]string{
+		{"Root Dir", d.home},
+		{"Dirs", fmt.Sprintf("%d", dirs)},
+	}
+}
+
+// During cleanup overlay needs to unmount all mountpoints
+func (d *Driver) Cleanup() error {
+	ids, err := ioutil.ReadDir(d.home)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if !id.IsDir() {
+			continue
+		}
+		if err := d.unmount(id.Name()); err != nil {
+			utils.Errorf("Unmounting %s: %s", utils.TruncateID(id.Name()), err)
+		}
+	}
+	return nil
+}
+
+func (d *Driver) dir(id string) string {
+	return path.Join(d.home, path.Base(id))
+}
+
+func (d *Driver) Exists(id string) bool {
+	_, err := os.Lstat(d.dir(id))
+	return err == nil
+}
+
+func (d *Driver) Layers() ([]string, error) {
+	files, err := ioutil.ReadDir(d.home)
+	if err != nil {
+		return nil, err
+	}
+	ids := []string{}
+	for _, file := range files {
+		// Skip the block device of the quotas
+		if file.IsDir() {
+			ids = append(ids, file.Name())
+		}
+	}
+	return ids, nil
+}
+
+// isImage returns true if the layer id holds its whole filesystem
+func (d *Driver) isImage(id string) bool {
+	_, err := os.Lstat(path.Join(d.dir(id), "root"))
+	return err == nil
+}
+
+// readId returns the id stored in the file name of the layer id,
+// an empty string if there is no such file
+func (d *Driver) readId(id, name string) (string, error) {
+	data, err := ioutil.ReadFile(path.Join(d.dir(id), name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return string(data), nil
+}
+
+func (d *Driver) Create(id, parent string) error {
+	return d.create(id, parent, 0)
+}
+
+// CreateWithQuota limits the size of the layer with the project
+// quotas of the backing filesystem
+func (d *Driver) CreateWithQuota(id, parent string, size int64) error {
+	return d.create(id, parent, size)
+}
+
+// quota returns the quota control of the layers, initialized at their first use
+func (d *Driver) quota() (*quota.Control, error) {
+	d.quotaOnce.Do(func() {
+		var err error
+		if d.quotaCtl, err = quota.NewControl(d.home); err != nil {
+			utils.Debugf("overlay: %s", err)
+		}
+	})
+	if d.quotaCtl == nil {
+		return nil, fmt.Errorf("overlay: the storage size cannot be limited, the filesystem of %s does not support project quotas", d.home)
+	}
+	return d.quotaCtl, nil
+}
+
+func (d *Driver) create(id, parent string, size int64) (err error) {
+	var quotaCtl *quota.Control
+	if size > 0 {
+		if quotaCtl, err = d.quota(); err != nil {
+			return err
+		}
+	}
+	dir := d.dir(id)
+	if err := os.Mkdir(dir, 0700); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(dir)
+		}
+	}()
+	if quotaCtl != nil {
+		// Everything created in dir, the upper dir included, counts in the quota
+		if err := quotaCtl.SetQuota(dir, uint64(size)); err != nil {
+			return err
+		}
+	}
+
+	// Toplevel images are just a root dir
+	if parent == "" {
+		return os.Mkdir(path.Join(dir, "root"), 0755)
+	}
+	if !d.Exists(parent) {
+		return fmt.Errorf("Parent layer %s does not exist", parent)
+	}
+
+	// The child of an image is mounted on it, the child of an overlay layer
+	// is mounted on the same image with a copy of its changes
+	lowerId := parent
+	upperSrc := ""
+	if !d.isImage(parent) {
+		if lowerId, err = d.readId(parent, "lower-id"); err != nil {
+			return err
+		}
+		upperSrc = path.Join(d.dir(parent), "upper")
+	}
+	if err := ioutil.WriteFile(path.Join(dir, "lower-id"), []byte(lowerId), 0600); err != nil {
+		return err
+	}
+	upper := path.Join(dir, "upper")
+	if upperSrc == "" {
+		if err := os.Mkdir(upper, 0755); err != nil {
+			return err
+		}
+	} else if err := copyDir(upperSrc, upper, false); err != nil {
+		return err
+	}
+	for _, p := range []string{"work", "merged"} {
+		if err := os.Mkdir(path.Join(dir, p), 0700); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyDir copies the tree src to dst, hard-linking the files rather than
+// copying them when link is true
+func copyDir(src, dst string, link bool) error {
+	flags := "-aT"
+	if link {
+		flags = "-alT"
+	}
+	if output, err := exec.Command("cp", flags, src, dst).CombinedOutput(); err != nil {
+		return fmt.Errorf("Error copying %s to %s: %s (%s)", src, dst, err, output)
+	}
+	return nil
+}
+
+func (d *Driver) Remove(id string) error {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.active[id] != 0 {
+		utils.Errorf("Warning: removing active id %s\n", id)
+	}
+	if err := d.unmount(id); err != nil {
+		return err
+	}
+	delete(d.active, id)
+
+	dir := d.dir(id)
+	// Move the dir out of the way first so that docker
+	// doesn't find it anymore
+	tmpDir := dir + "-removing"
+	if err := os.Rename(dir, tmpDir); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return os.RemoveAll(tmpDir)
+}
+
+// Get returns the root of an image layer as is,
+// and mounts an overlay layer on its image
+func (d *Driver) Get(id, mountLabel string) (string, error) {
+	dir := d.dir(id)
+	if _, err := os.Stat(dir); err != nil {
+		return "", err
+	}
+	if d.isImage(id) {
+		return path.Join(dir, "root"), nil
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	count := d.active[id]
+	if count == 0 {
+		if err := d.mount(id, mountLabel); err != nil {
+			return "", err
+		}
+	}
+	d.active[id] = count + 1
+	return path.Join(dir, "merged"), nil
+}
+
+func (d *Driver) Put(id string) {
+	d.Lock()
+	defer d.Unlock()
+
+	if count := d.active[id]; count > 1 {
+		d.active[id] = count - 1
+		return
+	}
+	if err := d.unmount(id); err != nil {
+		utils.Errorf("Unmounting %s: %s", utils.TruncateID(id), err)
+	}
+	delete(d.active, id)
+}
+
+func (d *Driver) mount(id, mountLabel string) error {
+	target := path.Join(d.dir(id), "merged")
+	if mounted, err := mountpk.Mounted(target); err != nil || mounted {
+		return err
+	}
+	lowerId, err := d.readId(id, "lower-id")
+	if err != nil {
+		return err
+	}
+	var (
+		lower   = path.Join(d.dir(lowerId), "root")
+		upper   = path.Join(d.dir(id), "upper")
+		work    = path.Join(d.dir(id), "work")
+		options = fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", lower, upper, work)
+	)
+	if err := syscall.Mount("overlay", target, "overlay", 0, label.FormatMountLabel(options, mountLabel)); err != nil {
+		return fmt.Errorf("Error mounting overlay for %s: %s", utils.TruncateID(id), err)
+	}
+	return nil
+}
+
+func (d *Driver) unmount(id string) error {
+	target := path.Join(d.dir(id), "merged")
+	if mounted, err := mountpk.Mounted(target); err != nil || !mounted {
+		return err
+	}
+	return syscall.Unmount(target, 0)
+}
+
+// ApplyDiff turns the layer id, freshly created on an image layer, into an
+// image layer: the root of its parent is hard-linked and the diff applied
+// to it. Applying a diff never writes in place, the files of the parent are
+// left alone.
+func (d *Driver) ApplyDiff(id, parent string, diff archive.ArchiveReader) error {
+	dir := d.dir(id)
+	if d.isImage(id) {
+		return archive.ApplyLayer(path.Join(dir, "root"), diff)
+	}
+	if parent == "" || !d.isImage(parent) {
+		return fmt.Errorf("Cannot apply a diff to %s, its parent is not an image", utils.TruncateID(id))
+	}
+
+	tmpRoot, err := ioutil.TempDir(dir, "tmproot")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpRoot)
+	if err := copyDir(path.Join(d.dir(parent), "root"), tmpRoot, true); err != nil {
+		return err
+	}
+	if err := archive.ApplyLayer(tmpRoot, diff); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpRoot, path.Join(dir, "root")); err != nil {
+		return err
+	}
+	for _, p := range []string{"lower-id", "upper", "work", "merged"} {
+		if err := os.RemoveAll(path.Join(dir, p)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Changes returns the changes of the layer id from its parent
+func (d *Driver) Changes(id, parent string) ([]archive.Change, error) {
+	if d.isImage(id) {
+		root := path.Join(d.dir(id), "root")
+		if parent == "" {
+			return archive.ChangesDirs(root, "")
+		}
+		return archive.ChangesDirs(root, path.Join(d.dir(parent), "root"))
+	}
+
+	// The changes of a layer mounted on its parent are in its upper dir
+	if d.isImage(parent) {
+		return upperChanges(path.Join(d.dir(id), "upper"), path.Join(d.dir(parent), "root"))
+	}
+	dir, err := d.Get(id, "")
+	if err != nil {
+		return nil, err
+	}
+	defer d.Put(id)
+	parentDir, err := d.Get(parent, "")
+	if err != nil {
+		return nil, err
+	}
+	defer d.Put(parent)
+	return archive.ChangesDirs(dir, parentDir)
+}
+
+// Diff returns the changes of the layer id from its parent as a layer archive
+func (d *Driver) Diff(id, parent string) (archive.Archive, error) {
+	if d.isImage(id) && parent == "" {
+		return archive.TarFilter(path.Join(d.dir(id), "root"), &archive.TarOptions{
+			Compression: archive.Uncompressed,
+		})
+	}
+	changes, err := d.Changes(id, parent)
+	if err != nil {
+		return nil, err
+	}
+	if d.isImage(id) {
+		return archive.ExportChanges(path.Join(d.dir(id), "root"), changes)
+	}
+	// Whiteouts are only exported as such, the content of the
+	// other changes is in the upper dir
+	return archive.ExportChanges(path.Join(d.dir(id), "upper"), changes)
+}
+
+// DiffSize returns the size of the changes of the layer id from its parent
+func (d *Driver) DiffSize(id, parent string) (int64, error) {
+	changes, err := d.Changes(id, parent)
+	if err != nil {
+		return -1, err
+	}
+	dir := path.Join(d.dir(id), "root")
+	if !d.isImage(id) {
+		dir = path.Join(d.dir(id), "upper")
+	}
+	return archive.ChangesSize(dir, changes), nil
+}
+
+// Usage returns the bytes used by the layer, from the project
+// quotas when it has one
+func (d *Driver) Usage(id string) (int64, error) {
+	dir := d.dir(id)
+	if d.quotaCtl != nil {
+		if usage, err := d.quotaCtl.GetUsage(dir); err == nil {
+			return int64(usage), nil
+		}
+	}
+	if d.isImage(id) {
+		return utils.TreeSize(path.Join(dir, "root"))
+	}
+	return utils.TreeSize(path.Join(dir, "upper"))
+}
+
+// isWhiteout returns true if fi is an overlay whiteout, a 0/0 char device
+func isWhiteout(fi os.FileInfo) bool {
+	if fi.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	return ok && stat.Rdev == 0
+}
+
+// isOpaque returns true if the dir p of an upper dir hides the content
+// of the same dir in the lower one
+func isOpaque(p string) bool {
+	opaque, err := system.Lgetxattr(p, "trusted.overlay.opaque")
+	return err == nil && string(opaque) == "y"
+}
+
+// upperChanges translates the content of the upper dir of an overlay mount
+// on lower to changes. The whiteouts are deletions, and the entries of an
+// opaque dir of lower that are not in upper are deleted too.
+func upperChanges(upper, lower string) ([]archive.Change, error) {
+	var (
+		changes []archive.Change
+		// the dirs of upper hiding the content of lower
+		opaqueDirs = make(map[string]bool)
+	)
+	err := filepath.Walk(upper, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(upper, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		rel = "/" + rel
+
+		if isWhiteout(fi) {
+			changes = append(changes, archive.Change{Path: rel, Kind: archive.ChangeDelete})
+			return nil
+		}
+		kind := archive.ChangeType(archive.ChangeModify)
+		lowerFi, err := os.Lstat(path.Join(lower, rel))
+		if err != nil {
+			kind = archive.ChangeAdd
+		}
+		changes = append(changes, archive.Change{Path: rel, Kind: kind})
+
+		if !fi.IsDir() || lowerFi == nil || !lowerFi.IsDir() {
+			return nil
+		}
+		if !isOpaque(p) && !opaqueDirs[path.Dir(rel)] {
+			return nil
+		}
+		// A dir of an opaque dir is opaque too, the content of both
+		// in lower is hidden
+		opaqueDirs[rel] = true
+		hidden, err := ioutil.ReadDir(path.Join(lower, rel))
+		if err != nil {
+			return err
+		}
+		for _, h := range hidden {
+			if _, err := os.Lstat(path.Join(p, h.Name())); os.IsNotExist(err) {
+				changes = append(changes, archive.Change{Path: path.Join(rel, h.Name()), Kind: archive.ChangeDelete})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
@@ -0,0 +1,247 @@
+// +build linux
+
+// Package quota limits the size of directories with the project quotas of
+// their backing filesystem, xfs or ext4 mounted with the prjquota option.
+//
+// Each limited directory gets its own project id, inherited by everything
+// created under it, and the quota of the project is set on the block
+// device of the filesystem.
+package quota
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// from linux/fs.h
+	fsIocFsGetXattr    = 0x801c581f
+	fsIocFsSetXattr    = 0x401c5820
+	fsXflagProjInherit = 0x00000200
+
+	// from linux/quota.h
+	qGetQuota  = 0x800007
+	qSetQuota  = 0x800008
+	prjQuota   = 2
+	qifBLimits = 1
+	qifBlkSize = 1024
+)
+
+// fsxattr is struct fsxattr of linux/fs.h
+type fsxattr struct {
+	xflags     uint32
+	extsize    uint32
+	nextents   uint32
+	projid     uint32
+	cowextsize uint32
+	pad        [8]byte
+}
+
+// ifDqblk is struct if_dqblk of linux/quota.h
+type ifDqblk struct {
+	bhardlimit uint64
+	bsoftlimit uint64
+	curspace   uint64
+	ihardlimit uint64
+	isoftlimit uint64
+	curinodes  uint64
+	btime      uint64
+	itime      uint64
+	valid      uint32
+}
+
+// Control sets the quotas of the directories under a base path
+type Control struct {
+	backingFsBlockDev string
+
+	sync.Mutex
+	nextProjectId uint32
+	// the project id of each limited directory
+	quotas map[string]uint32
+}
+
+// NewControl returns the quota control of the directories under basePath,
+// an error if its backing filesystem does not support project quotas
+func NewControl(basePath string) (*Control, error) {
+	backingFsBlockDev, err := makeBackingFsDev(basePath)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &Control{
+		backingFsBlockDev: backingFsBlockDev,
+		quotas:            make(map[string]uint32),
+	}
+
+	// The directories limited before a restart keep their project id
+	if err := q.findNextProjectId(basePath); err != nil {
+		return nil, err
+	}
+
+	// Check the filesystem really enforces the quotas by limiting
+	// the project of basePath itself, which is never used
+	if err := setProjectQuota(backingFsBlockDev, 0, 0); err != nil {
+		return nil, fmt.Errorf("The backing filesystem of %s does not support project quotas: %s", basePath, err)
+	}
+	return q, nil
+}
+
+// SetQuota limits the content of targetPath, an empty directory,
+// to size bytes
+func (q *Control) SetQuota(targetPath string, size uint64) error {
+	q.Lock()
+	defer q.Unlock()
+
+	projectId, exists := q.quotas[targetPath]
+	if !exists {
+		projectId = q.nextProjectId
+		if err := setProjectId(targetPath, projectId); err != nil {
+			return err
+		}
+		q.quotas[targetPath] = projectId
+		q.nextProjectId++
+	}
+	return setProjectQuota(q.backingFsBlockDev, projectId, size)
+}
+
+// GetUsage returns the bytes used by the content of targetPath
+func (q *Control) GetUsage(targetPath string) (uint64, error) {
+	projectId, err := getProjectId(targetPath)
+	if err != nil {
+		return 0, err
+	}
+	// Project 0 holds everything without a project, not targetPath alone
+	if projectId == 0 {
+		return 0, fmt.Errorf("%s has no quota", targetPath)
+	}
+	d := ifDqblk{}
+	if err := quotactl(qGetQuota, q.backingFsBlockDev, projectId, &d); err != nil {
+		return 0, fmt.Errorf("Failed to get the quota of %s: %s", targetPath, err)
+	}
+	return d.curspace, nil
+}
+
+func quotactl(cmd int, special string, id uint32, d *ifDqblk) error {
+	specialPtr, err := syscall.BytePtrFromString(special)
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall6(syscall.SYS_QUOTACTL,
+		uintptr(cmd<<8|prjQuota),
+		uintptr(unsafe.Pointer(specialPtr)),
+		uintptr(id),
+		uintptr(unsafe.Pointer(d)), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func setProjectQuota(backingFsBlockDev string, projectId uint32, size uint64) error {
+	d := ifDqblk{
+		bhardlimit: size / qifBlkSize,
+		bsoftlimit: size / qifBlkSize,
+		valid:      qifBLimits,
+	}
+	if err := quotactl(qSetQuota, backingFsBlockDev, projectId, &d); err != nil {
+		return fmt.Errorf("Failed to set the quota of project %d: %s", projectId, err)
+	}
+	return nil
+}
+
+func fsGetXattr(targetPath string) (*fsxattr, error) {
+	dir, err := os.Open(targetPath)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	fsx := &fsxattr{}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, dir.Fd(), fsIocFsGetXattr, uintptr(unsafe.Pointer(fsx))); errno != 0 {
+		return nil, fmt.Errorf("Failed to get the project id of %s: %s", targetPath, errno)
+	}
+	return fsx, nil
+}
+
+func getProjectId(targetPath string) (uint32, error) {
+	fsx, err := fsGetXattr(targetPath)
+	if err != nil {
+		return 0, err
+	}
+	return fsx.projid, nil
+}
+
+func setProjectId(targetPath string, projectId uint32) error {
+	fsx, err := fsGetXattr(targetPath)
+	if err != nil {
+		return err
+	}
+	fsx.projid = projectId
+	fsx.xflags |= fsXflagProjInherit
+
+	dir, err := os.Open(targetPath)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, dir.Fd(), fsIocFsSetXattr, uintptr(unsafe.Pointer(fsx))); errno != 0 {
+		return fmt.Errorf("Failed to set the project id of %s: %s", targetPath, errno)
+	}
+	return nil
+}
+
+// findNextProjectId picks the project id following the
+// ones of the directories under basePath
+func (q *Control) findNextProjectId(basePath string) error {
+	q.nextProjectId = 1
+	files, err := ioutil.ReadDir(basePath)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if !file.IsDir() {
+			continue
+		}
+		p := path.Join(basePath, file.Name())
+		projectId, err := getProjectId(p)
+		if err != nil {
+			return err
+		}
+		if projectId == 0 {
+			continue
+		}
+		q.quotas[p] = projectId
+		if projectId >= q.nextProjectId {
+			q.nextProjectId = projectId + 1
+		}
+	}
+	return nil
+}
+
+// makeBackingFsDev creates a block device node of the backing filesystem
+// of home, quotactl takes one rather than a path
+func makeBackingFsDev(home string) (string, error) {
+	fi, err := os.Stat(home)
+	if err != nil {
+		return "", err
+	}
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", fmt.Errorf("Failed to stat %s", home)
+	}
+
+	backingFsBlockDev := path.Join(home, "backingFsBlockDev")
+	// Re-create the node, the device may have changed since the last start
+	if err := os.Remove(backingFsBlockDev); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	if err := syscall.Mknod(backingFsBlockDev, syscall.S_IFBLK|0600, int(stat.Dev)); err != nil {
+		return "", fmt.Errorf("Failed to create the block device of the backing filesystem of %s: %s", home, err)
+	}
+	return backingFsBlockDev, nil
+}
@@ -0,0 +1,48 @@
+// +build linux
+
+package quota
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestSetQuota(t *testing.T) {
+	home, err := ioutil.TempDir("/var/tmp", "docker-quota-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+
+	q, err := NewControl(home)
+	if err != nil {
+		t.Skip(err)
+	}
+	dir := path.Join(home, "limited")
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.SetQuota(dir, 1024*1024); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(dir, "small"), make([]byte, 512*1024), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if usage, err := q.GetUsage(dir); err != nil || usage < 512*1024 {
+		t.Fatalf("Expected a usage of at least 512k, got %d (%v)", usage, err)
+	}
+	if err := ioutil.WriteFile(path.Join(dir, "big"), make([]byte, 1024*1024), 0600); err == nil {
+		t.Fatal("Expected the quota to be exceeded")
+	}
+
+	// A new control finds the project ids in use
+	q, err = NewControl(home)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if projectId := q.quotas[dir]; projectId == 0 || q.nextProjectId <= projectId {
+		t.Fatalf("Expected the project of %s to be found, got %d (next %d)", dir, projectId, q.nextProjectId)
+	}
+}
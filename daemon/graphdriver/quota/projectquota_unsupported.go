@@ -0,0 +1,21 @@
+// +build !linux
+
+package quota
+
+import (
+	"fmt"
+)
+
+type Control struct{}
+
+func NewControl(basePath string) (*Control, error) {
+	return nil, fmt.Errorf("Project quotas are only supported on linux")
+}
+
+func (q *Control) SetQuota(targetPath string, size uint64) error {
+	return fmt.Errorf("Project quotas are only supported on linux")
+}
+
+func (q *Control) GetUsage(targetPath string) (uint64, error) {
+	return 0, fmt.Errorf("Project quotas are only supported on linux")
+}
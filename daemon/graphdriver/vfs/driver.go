@@ -3,16 +3,23 @@ package vfs
 import (
 	"fmt"
 	"github.com/dotcloud/docker/daemon/graphdriver"
+	"github.com/dotcloud/docker/daemon/graphdriver/quota"
+	"github.com/dotcloud/docker/utils"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path"
+	"sync"
 )
 
 func init() {
 	graphdriver.Register("vfs", Init)
 }
 
-func Init(home string) (graphdriver.Driver, error) {
+func Init(home string, options []string) (graphdriver.Driver, error) {
+	if err := graphdriver.NoOptions("vfs", options); err != nil {
+		return nil, err
+	}
 	d := &Driver{
 		home: home,
 	}
@@ -21,6 +28,10 @@ func Init(home string) (graphdriver.Driver, error) {
 
 type Driver struct {
 	home string
+
+	quotaOnce sync.Once
+	// nil when the backing filesystem has no project quotas
+	quotaCtl *quota.Control
 }
 
 func (d *Driver) String() string {
@@ -43,6 +54,41 @@ func copyDir(src, dst string) error {
 }
 
 func (d *Driver) Create(id, parent string) error {
+	return d.create(id, parent, 0)
+}
+
+// CreateWithQuota limits the size of the layer with the project
+// quotas of the backing filesystem
+func (d *Driver) CreateWithQuota(id, parent string, size int64) error {
+	return d.create(id, parent, size)
+}
+
+// quota returns the quota control of the layers, initialized at their first
+// use rather than in Init as most vfs layers, e.g. volumes, have no quota
+func (d *Driver) quota() (*quota.Control, error) {
+	d.quotaOnce.Do(func() {
+		dir := path.Join(d.home, "dir")
+		err := os.MkdirAll(dir, 0700)
+		if err == nil {
+			d.quotaCtl, err = quota.NewControl(dir)
+		}
+		if err != nil {
+			utils.Debugf("vfs: %s", err)
+		}
+	})
+	if d.quotaCtl == nil {
+		return nil, fmt.Errorf("vfs: the storage size cannot be limited, the filesystem of %s does not support project quotas", d.home)
+	}
+	return d.quotaCtl, nil
+}
+
+func (d *Driver) create(id, parent string, size int64) (err error) {
+	var quotaCtl *quota.Control
+	if size > 0 {
+		if quotaCtl, err = d.quota(); err != nil {
+			return err
+		}
+	}
 	dir := d.dir(id)
 	if err := os.MkdirAll(path.Dir(dir), 0700); err != nil {
 		return err
@@ -50,6 +96,13 @@ func (d *Driver) Create(id, parent string) error {
 	if err := os.Mkdir(dir, 0755); err != nil {
 		return err
 	}
+	if quotaCtl != nil {
+		// The copy of the parent below counts in the quota
+		if err := quotaCtl.SetQuota(dir, uint64(size)); err != nil {
+			os.RemoveAll(dir)
+			return err
+		}
+	}
 	if parent == "" {
 		return nil
 	}
@@ -89,7 +142,37 @@ func (d *Driver) Put(id string) {
 	// to clean up, so we don't need anything here
 }
 
+// Usage returns the bytes used by the layer, from the project
+// quotas when it has one
+func (d *Driver) Usage(id string) (int64, error) {
+	dir := d.dir(id)
+	if d.quotaCtl != nil {
+		if usage, err := d.quotaCtl.GetUsage(dir); err == nil {
+			return int64(usage), nil
+		}
+	}
+	return utils.TreeSize(dir)
+}
+
 func (d *Driver) Exists(id string) bool {
 	_, err := os.Stat(d.dir(id))
 	return err == nil
 }
+
+func (d *Driver) Layers() ([]string, error) {
+	files, err := ioutil.ReadDir(path.Join(d.home, "dir"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	ids := make([]string, 0, len(files))
+	for _, file := range files {
+		// Skip the block device of the quotas
+		if file.IsDir() {
+			ids = append(ids, file.Name())
+		}
+	}
+	return ids, nil
+}
@@ -2,6 +2,9 @@ package vfs
 
 import (
 	"github.com/dotcloud/docker/daemon/graphdriver/graphtest"
+	"io/ioutil"
+	"os"
+	"path"
 	"testing"
 )
 
@@ -23,6 +26,38 @@ func TestVfsCreateSnap(t *testing.T) {
 	graphtest.DriverTestCreateSnap(t, "vfs")
 }
 
+func TestVfsDiffApply(t *testing.T) {
+	graphtest.DriverTestDiffApply(t, "vfs")
+}
+
 func TestVfsTeardown(t *testing.T) {
 	graphtest.PutDriver(t)
 }
+
+func TestVfsLayersSkipQuotaDevice(t *testing.T) {
+	home, err := ioutil.TempDir("", "docker-test-vfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	d, err := Init(home, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Create("Base", ""); err != nil {
+		t.Fatal(err)
+	}
+	// quota.NewControl creates the block device next to the layers, a file
+	// stands for it without privileges
+	if err := ioutil.WriteFile(path.Join(home, "dir", "backingFsBlockDev"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	layers, err := d.(*Driver).Layers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(layers) != 1 || layers[0] != "Base" {
+		t.Fatalf("Expected the layer Base, got %v", layers)
+	}
+}
@@ -0,0 +1,304 @@
+// +build linux
+
+/*
+
+zfs driver datasets
+
+<parent dataset>
+├── <id>           // Layer without parent, created empty
+├── <id>           // Clone of the snapshot <parent id>@<id> of its parent
+└── ...
+
+The parent dataset is the one given by zfs.fsname, by default the child
+"docker" of the dataset holding the docker root. The datasets of the layers
+have a legacy mountpoint, they are only mounted on <home>/<id> while in use.
+
+*/
+
+package zfs
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/dotcloud/docker/daemon/graphdriver"
+	"github.com/dotcloud/docker/pkg/label"
+	mountpk "github.com/dotcloud/docker/pkg/mount"
+	"github.com/dotcloud/docker/utils"
+)
+
+func init() {
+	graphdriver.Register("zfs", Init)
+}
+
+type Driver struct {
+	home       string
+	fsname     string
+	sync.Mutex // Protects concurrent modification to active
+	active     map[string]int
+}
+
+// Init returns a new zfs driver storing its layers under the dataset given
+// by the zfs.fsname option. Without it, the docker root must be on zfs.
+func Init(home string, options []string) (graphdriver.Driver, error) {
+	var fsname string
+	for _, option := range options {
+		key, val, err := graphdriver.ParseOption(option)
+		if err != nil {
+			return nil, err
+		}
+		switch key {
+		case "zfs.fsname":
+			fsname = strings.Trim(val, "/")
+		default:
+			return nil, fmt.Errorf("zfs: unknown option %s", key)
+		}
+	}
+
+	if _, err := exec.LookPath("zfs"); err != nil {
+		if fsname == "" {
+			return nil, graphdriver.ErrNotSupported
+		}
+		return nil, fmt.Errorf("zfs: the zfs command is not installed")
+	}
+
+	if fsname == "" {
+		dataset, err := datasetOf(path.Dir(home))
+		if err != nil {
+			return nil, err
+		}
+		if dataset == "" {
+			return nil, graphdriver.ErrNotSupported
+		}
+		fsname = dataset + "/docker"
+		if !datasetExists(fsname) {
+			if _, err := zfs("create", "-o", "mountpoint=legacy", fsname); err != nil {
+				return nil, err
+			}
+		}
+	} else if !datasetExists(fsname) {
+		return nil, fmt.Errorf("zfs: the dataset %s does not exist", fsname)
+	}
+
+	if err := os.MkdirAll(home, 0700); err != nil {
+		return nil, err
+	}
+	return &Driver{
+		home:   home,
+		fsname: fsname,
+		active: make(map[string]int),
+	}, nil
+}
+
+// datasetOf returns the zfs dataset mounted on dir or on its closest
+// parent, an empty string if that filesystem is not a zfs one
+func datasetOf(dir string) (string, error) {
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+	mounts, err := mountpk.GetMounts()
+	if err != nil {
+		return "", err
+	}
+	var closest *mountpk.MountInfo
+	for _, m := range mounts {
+		if m.Mountpoint != "/" && dir != m.Mountpoint && !strings.HasPrefix(dir, m.Mountpoint+"/") {
+			continue
+		}
+		if closest == nil || len(m.Mountpoint) >= len(closest.Mountpoint) {
+			closest = m
+		}
+	}
+	if closest == nil || closest.Fstype != "zfs" {
+		return "", nil
+	}
+	return closest.Source, nil
+}
+
+// zfs runs the zfs command with args and returns its output
+func zfs(args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("zfs", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("zfs %s: %s (%s)", strings.Join(args, " "), strings.TrimSpace(stderr.String()), err)
+	}
+	return stdout.String(), nil
+}
+
+func datasetExists(name string) bool {
+	_, err := zfs("list", "-H", "-o", "name", name)
+	return err == nil
+}
+
+func (d *Driver) String() string {
+	return "zfs"
+}
+
+func (d *Driver) dataset(id string) string {
+	return path.Join(d.fsname, id)
+}
+
+func (d *Driver) dir(id string) string {
+	return path.Join(d.home, id)
+}
+
+// Status reports the parent dataset and the usage of its pool
+func (d *Driver) Status() [][2]string {
+	pool := strings.SplitN(d.fsname, "/", 2)[0]
+	status := [][2]string{
+		{"Zpool", pool},
+		{"Parent Dataset", d.fsname},
+	}
+	if out, err := exec.Command("zpool", "list", "-H", "-o", "health,size,allocated,free", pool).Output(); err == nil {
+		if fields := strings.Fields(string(out)); len(fields) == 4 {
+			status = append(status,
+				[2]string{"Zpool Health", fields[0]},
+				[2]string{"Pool Size", fields[1]},
+				[2]string{"Pool Allocated", fields[2]},
+				[2]string{"Pool Free", fields[3]},
+			)
+		}
+	}
+	if out, err := zfs("get", "-H", "-o", "value", "used,available", d.fsname); err == nil {
+		if fields := strings.Fields(out); len(fields) == 2 {
+			status = append(status,
+				[2]string{"Space Used By Parent", fields[0]},
+				[2]string{"Space Available", fields[1]},
+			)
+		}
+	}
+	return status
+}
+
+// During cleanup zfs needs to unmount the layers in use
+func (d *Driver) Cleanup() error {
+	d.Lock()
+	defer d.Unlock()
+
+	for id := range d.active {
+		if err := d.unmount(id); err != nil {
+			utils.Errorf("Unmounting %s: %s", utils.TruncateID(id), err)
+		}
+		delete(d.active, id)
+	}
+	return nil
+}
+
+// Layers returns the ids of the child datasets of the parent dataset
+func (d *Driver) Layers() ([]string, error) {
+	out, err := zfs("list", "-H", "-o", "name", "-t", "filesystem", "-r", "-d", "1", d.fsname)
+	if err != nil {
+		return nil, err
+	}
+	ids := []string{}
+	for _, name := range strings.Split(out, "\n") {
+		if strings.HasPrefix(name, d.fsname+"/") {
+			ids = append(ids, strings.TrimPrefix(name, d.fsname+"/"))
+		}
+	}
+	return ids, nil
+}
+
+// Create creates an empty dataset for a layer without parent, otherwise
+// snapshots the dataset of the parent and clones it
+func (d *Driver) Create(id, parent string) error {
+	if parent == "" {
+		_, err := zfs("create", "-o", "mountpoint=legacy", d.dataset(id))
+		return err
+	}
+	snapshot := d.dataset(parent) + "@" + id
+	if _, err := zfs("snapshot", snapshot); err != nil {
+		return err
+	}
+	if _, err := zfs("clone", "-o", "mountpoint=legacy", snapshot, d.dataset(id)); err != nil {
+		zfs("destroy", snapshot)
+		return err
+	}
+	return nil
+}
+
+// Remove destroys the dataset of the layer id, then the snapshot of its
+// parent it was cloned from
+func (d *Driver) Remove(id string) error {
+	if !d.Exists(id) {
+		return nil
+	}
+	origin, err := zfs("get", "-H", "-o", "value", "origin", d.dataset(id))
+	if err != nil {
+		return err
+	}
+	if _, err := zfs("destroy", "-r", d.dataset(id)); err != nil {
+		return err
+	}
+	if origin = strings.TrimSpace(origin); origin != "-" {
+		if _, err := zfs("destroy", origin); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(d.dir(id))
+}
+
+func (d *Driver) Get(id, mountLabel string) (string, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	dir := d.dir(id)
+	count := d.active[id]
+	if count == 0 {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return "", err
+		}
+		if err := d.mount(id, mountLabel); err != nil {
+			return "", err
+		}
+	}
+	d.active[id] = count + 1
+	return dir, nil
+}
+
+func (d *Driver) Put(id string) {
+	d.Lock()
+	defer d.Unlock()
+
+	if count := d.active[id]; count > 1 {
+		d.active[id] = count - 1
+		return
+	}
+	if err := d.unmount(id); err != nil {
+		utils.Errorf("Unmounting %s: %s", utils.TruncateID(id), err)
+	}
+	delete(d.active, id)
+}
+
+func (d *Driver) mount(id, mountLabel string) error {
+	target := d.dir(id)
+	if mounted, err := mountpk.Mounted(target); err != nil || mounted {
+		return err
+	}
+	if err := syscall.Mount(d.dataset(id), target, "zfs", 0, label.FormatMountLabel("", mountLabel)); err != nil {
+		return fmt.Errorf("Error mounting the dataset of %s: %s", utils.TruncateID(id), err)
+	}
+	return nil
+}
+
+func (d *Driver) unmount(id string) error {
+	target := d.dir(id)
+	if mounted, err := mountpk.Mounted(target); err != nil || !mounted {
+		return err
+	}
+	return syscall.Unmount(target, 0)
+}
+
+func (d *Driver) Exists(id string) bool {
+	return datasetExists(d.dataset(id))
+}
@@ -0,0 +1,83 @@
+// +build linux
+
+package zfs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+
+	"github.com/dotcloud/docker/daemon/graphdriver/graphtest"
+)
+
+// The tests run on a pool backed by a sparse file
+var (
+	testPool    = fmt.Sprintf("docker-test-%d", os.Getpid())
+	testPoolDir string
+)
+
+func createTestPool(t *testing.T) {
+	for _, cmd := range []string{"zfs", "zpool"} {
+		if _, err := exec.LookPath(cmd); err != nil {
+			t.Skipf("The %s command is not installed", cmd)
+		}
+	}
+	dir, err := ioutil.TempDir("/var/tmp", "docker-zfs-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := path.Join(dir, "pool")
+	if err := ioutil.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(file, 128*1024*1024); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command("zpool", "create", "-m", "none", testPool, file).CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		t.Skipf("Cannot create a zfs pool: %s", out)
+	}
+	testPoolDir = dir
+}
+
+func destroyTestPool(t *testing.T) {
+	if testPoolDir == "" {
+		return
+	}
+	if out, err := exec.Command("zpool", "destroy", testPool).CombinedOutput(); err != nil {
+		t.Fatalf("Cannot destroy the zfs pool: %s", out)
+	}
+	os.RemoveAll(testPoolDir)
+	testPoolDir = ""
+}
+
+// This avoids creating a new driver for each test if all tests are run
+// Make sure to put new tests between TestZfsSetup and TestZfsTeardown
+func TestZfsSetup(t *testing.T) {
+	createTestPool(t)
+	graphtest.GetDriver(t, "zfs", "zfs.fsname="+testPool)
+}
+
+func TestZfsCreateEmpty(t *testing.T) {
+	graphtest.DriverTestCreateEmpty(t, "zfs")
+}
+
+func TestZfsCreateBase(t *testing.T) {
+	graphtest.DriverTestCreateBase(t, "zfs")
+}
+
+func TestZfsCreateSnap(t *testing.T) {
+	graphtest.DriverTestCreateSnap(t, "zfs")
+}
+
+func TestZfsDiffApply(t *testing.T) {
+	graphtest.DriverTestDiffApply(t, "zfs")
+}
+
+func TestZfsTeardown(t *testing.T) {
+	graphtest.PutDriver(t)
+	destroyTestPool(t)
+}
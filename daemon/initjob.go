@@ -11,6 +11,7 @@ import (
 	"github.com/dotcloud/docker/engine"
 	"github.com/dotcloud/docker/opts"
 	flag "github.com/dotcloud/docker/pkg/mflag"
+	"github.com/dotcloud/docker/registry"
 	"github.com/dotcloud/docker/utils"
 )
 
@@ -38,14 +39,27 @@ func DaemonInit(job *engine.Job) engine.Status {
 		flEnableCors         = flags.Bool([]string{"#api-enable-cors", "-api-enable-cors"}, false, "Enable CORS headers in the remote API")
 		flDns                = opts.NewListOpts(opts.ValidateIp4Address)
 		flDnsSearch          = opts.NewListOpts(opts.ValidateDomain)
+		flMirrors            = opts.NewListOpts(registry.ValidateMirror)
+		flInsecureRegistries = opts.NewListOpts(registry.ValidateInsecureRegistry)
+		flTrustKeys          = opts.NewListOpts(nil)
+		flRequireSignature   = opts.NewListOpts(nil)
+		flGraphOpts          = opts.NewListOpts(nil)
 		flEnableIptables     = flags.Bool([]string{"#iptables", "-iptables"}, true, "Enable Docker's addition of iptables rules")
 		flEnableIpForward    = flags.Bool([]string{"#ip-forward", "-ip-forward"}, true, "Enable net.ipv4.ip_forward")
 		flDefaultIp          = flags.String([]string{"#ip", "-ip"}, "0.0.0.0", "Default IP address to use when binding container ports")
 		flInterContainerComm = flags.Bool([]string{"#icc", "-icc"}, true, "Enable inter-container communication")
 		flGraphDriver        = flags.String([]string{"s", "-storage-driver"}, "", "Force the docker runtime to use a specific storage driver")
 		flExecDriver         = flags.String([]string{"e", "-exec-driver"}, "native", "Force the docker runtime to use a specific exec driver")
+		flMigrateStorage     = flags.String([]string{"-migrate-storage"}, "", "Migrate the images and containers of this storage driver to the one given by -s, then quit")
 		flHosts              = opts.NewListOpts(api.ValidateHost)
 		flMtu                = flags.Int([]string{"#mtu", "-mtu"}, 0, "Set the containers network MTU\nif no value is provided: default to the default route MTU or 1500 if no default route is available")
+		flUserlandProxy      = flags.Bool([]string{"-userland-proxy"}, true, "Use a userland proxy for published ports\nif disabled: rely on iptables with hairpin NAT instead")
+		flNetworkPlugin      = flags.String([]string{"-network-plugin"}, "", "Path to the unix socket of a network driver plugin to use in place of the bridge")
+		flPortRange          = flags.String([]string{"-port-range"}, "", "Range of host ports to allocate dynamically for published container ports, in the form start-end\nif no value is provided: default to 49153-65535")
+		flDownloadRetries    = flags.Int([]string{"-download-retries"}, 5, "Number of times an interrupted layer download is resumed before the pull fails")
+		flLayerStore         = flags.String([]string{"-layer-store"}, "", "Path of a read-only store of image layers shared with other daemons, the layers it has are not downloaded by the pulls")
+		flMaxDownloads       = flags.Int([]string{"-max-concurrent-downloads"}, 3, "Maximum number of layers downloaded at once by the pulls")
+		flMaxUploads         = flags.Int([]string{"-max-concurrent-uploads"}, 5, "Maximum number of layers uploaded at once by the pushes")
 		flTls                = flags.Bool([]string{"-tls"}, false, "Use TLS; implied by tls-verify flags")
 		flTlsVerify          = flags.Bool([]string{"-tlsverify"}, false, "Use TLS and verify the remote (daemon: verify client, client: verify daemon)")
 		flCa                 = flags.String([]string{"-tlscacert"}, dockerConfDir+defaultCaFile, "Trust only remotes providing a certificate signed by the CA given here")
@@ -58,6 +72,11 @@ func DaemonInit(job *engine.Job) engine.Status {
 
 	flags.Var(&flDns, []string{"#dns", "-dns"}, "Force docker to use specific DNS servers")
 	flags.Var(&flDnsSearch, []string{"-dns-search"}, "Force Docker to use specific DNS search domains")
+	flags.Var(&flMirrors, []string{"-registry-mirror"}, "Specify a preferred Docker registry mirror for pulls from the official index")
+	flags.Var(&flInsecureRegistries, []string{"-insecure-registry"}, "Allow plain HTTP and unverified HTTPS to the registries of this CIDR (e.g. 10.1.0.0/16) or host (e.g. myregistry:5000)")
+	flags.Var(&flTrustKeys, []string{"-trust-key"}, "Public key (PEM) of a signer whose images pulled from v2 registries are trusted")
+	flags.Var(&flRequireSignature, []string{"-require-signature"}, "Refuse the images of the repositories under this namespace (e.g. myorg or myregistry:5000/team) unless signed by a trusted key")
+	flags.Var(&flGraphOpts, []string{"-storage-opt"}, "Set a storage driver option as key=value (e.g. dm.basesize=20G)")
 	flags.Var(&flHosts, []string{"H", "-host"}, "The socket(s) to bind to in daemon mode\nspecified using one or more tcp://host:port, unix:///path/to/socket, fd://* or fd://socketfd.")
 
 	flags.Parse(job.Args)
@@ -78,6 +97,19 @@ func DaemonInit(job *engine.Job) engine.Status {
 	if *bridgeName != "" && *bridgeIp != "" {
 		log.Fatal("You specified -b & --bip, mutually exclusive options. Please specify only one.")
 	}
+	if *flNetworkPlugin != "" && (*bridgeName != "" || *bridgeIp != "") {
+		log.Fatal("You specified --network-plugin & -b or --bip, mutually exclusive options. Please specify only one.")
+	}
+
+	if *flDownloadRetries < 0 {
+		log.Fatal("--download-retries can't be negative")
+	}
+	if *flMaxDownloads < 1 {
+		log.Fatal("--max-concurrent-downloads should be at least 1")
+	}
+	if *flMaxUploads < 1 {
+		log.Fatal("--max-concurrent-uploads should be at least 1")
+	}
 
 	if runtime.GOOS != "linux" {
 		log.Fatalf("The Docker daemon is only supported on linux")
@@ -114,6 +146,21 @@ func DaemonInit(job *engine.Job) engine.Status {
 		log.Fatal(err)
 	}
 
+	if *flMigrateStorage != "" {
+		if *flGraphDriver == "" {
+			log.Fatal("--migrate-storage needs the storage driver to migrate to with -s")
+		}
+		migrateJob := job.Job("migrate_storage", *flMigrateStorage, *flGraphDriver)
+		migrateJob.Setenv("Root", realRoot)
+		migrateJob.Setenv("Pidfile", *pidfile)
+		migrateJob.SetenvList("GraphOptions", flGraphOpts.GetAll())
+		migrateJob.Stdout.Add(os.Stdout)
+		if err := migrateJob.Run(); err != nil {
+			log.Fatal(err)
+		}
+		return engine.StatusOK
+	}
+
 	// load the daemon in the background so we can immediately start
 	// the http api so that connections don't fail while the daemon
 	// is booting
@@ -125,6 +172,10 @@ func DaemonInit(job *engine.Job) engine.Status {
 		initJob.SetenvBool("AutoRestart", *flAutoRestart)
 		initJob.SetenvList("Dns", flDns.GetAll())
 		initJob.SetenvList("DnsSearch", flDnsSearch.GetAll())
+		initJob.SetenvList("Mirrors", flMirrors.GetAll())
+		initJob.SetenvList("InsecureRegistries", flInsecureRegistries.GetAll())
+		initJob.SetenvList("TrustKeys", flTrustKeys.GetAll())
+		initJob.SetenvList("RequireSignature", flRequireSignature.GetAll())
 		initJob.SetenvBool("EnableIptables", *flEnableIptables)
 		initJob.SetenvBool("EnableIpForward", *flEnableIpForward)
 		initJob.Setenv("BridgeIface", *bridgeName)
@@ -132,8 +183,16 @@ func DaemonInit(job *engine.Job) engine.Status {
 		initJob.Setenv("DefaultIp", *flDefaultIp)
 		initJob.SetenvBool("InterContainerCommunication", *flInterContainerComm)
 		initJob.Setenv("GraphDriver", *flGraphDriver)
+		initJob.SetenvList("GraphOptions", flGraphOpts.GetAll())
 		initJob.Setenv("ExecDriver", *flExecDriver)
 		initJob.SetenvInt("Mtu", *flMtu)
+		initJob.Setenv("PortRange", *flPortRange)
+		initJob.SetenvInt("DownloadRetries", *flDownloadRetries)
+		initJob.Setenv("LayerStore", *flLayerStore)
+		initJob.SetenvInt("MaxConcurrentDownloads", *flMaxDownloads)
+		initJob.SetenvInt("MaxConcurrentUploads", *flMaxUploads)
+		initJob.SetenvBool("EnableUserlandProxy", *flUserlandProxy)
+		initJob.Setenv("NetworkPlugin", *flNetworkPlugin)
 		initJob.SetenvBool("EnableSelinuxSupport", *flSelinuxEnabled)
 
 		if err := initJob.Run(); err != nil {
@@ -3,8 +3,10 @@ package daemon
 import (
 	"encoding/json"
 
+	"github.com/dotcloud/docker/daemon/graphdriver"
 	"github.com/dotcloud/docker/engine"
 	"github.com/dotcloud/docker/runconfig"
+	"github.com/dotcloud/docker/utils"
 )
 
 func (daemon *Daemon) ContainerInspect(job *engine.Job) engine.Status {
@@ -13,10 +15,21 @@ func (daemon *Daemon) ContainerInspect(job *engine.Job) engine.Status {
 	}
 	name := job.Args[0]
 	if container := daemon.Get(name); container != nil {
+		// The usage of the containers with a storage size, in bytes
+		var storageUsage int64
+		if quotaDriver, ok := daemon.driver.(graphdriver.QuotaDriver); ok && container.Config.StorageSize > 0 {
+			usage, err := quotaDriver.Usage(container.ID)
+			if err != nil {
+				utils.Errorf("Warning: driver %s couldn't return the storage usage of container %s: %s", daemon.driver, container.ID, err)
+				usage = -1
+			}
+			storageUsage = usage
+		}
 		b, err := json.Marshal(&struct {
 			*Container
-			HostConfig *runconfig.HostConfig
-		}{container, container.HostConfig()})
+			HostConfig   *runconfig.HostConfig
+			StorageUsage int64 `json:",omitempty"`
+		}{container, container.HostConfig(), storageUsage})
 		if err != nil {
 			return job.Error(err)
 		}
@@ -0,0 +1,273 @@
+package daemon
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/dotcloud/docker/daemon/graphdriver"
+	"github.com/dotcloud/docker/engine"
+	"github.com/dotcloud/docker/graph"
+	"github.com/dotcloud/docker/image"
+	"github.com/dotcloud/docker/utils"
+)
+
+// MigrateStorage copies the images and containers of the docker root from
+// the storage driver FROM to the storage driver TO, offline: the pid file
+// keeps a daemon from starting on the root in the meantime.
+//
+// The images stay in FROM, the containers are moved to TO.
+func MigrateStorage(job *engine.Job) engine.Status {
+	if len(job.Args) != 2 {
+		return job.Errorf("Usage: %s FROM TO", job.Name)
+	}
+	var (
+		root    = job.Getenv("Root")
+		pidfile = job.Getenv("Pidfile")
+		from    = job.Args[0]
+		to      = job.Args[1]
+	)
+	if from == to {
+		return job.Errorf("Cannot migrate the %s storage driver to itself", from)
+	}
+
+	if err := utils.CreatePidFile(pidfile); err != nil {
+		return job.Error(err)
+	}
+	defer utils.RemovePidFile(pidfile)
+
+	if err := remountPrivate(root); err != nil {
+		return job.Error(err)
+	}
+	// The options are the ones of the new driver, the old one gets its defaults
+	fromDriver, err := graphdriver.GetDriver(from, root, nil)
+	if err != nil {
+		return job.Errorf("Cannot load the %s storage driver: %s", from, err)
+	}
+	defer fromDriver.Cleanup()
+	toDriver, err := graphdriver.GetDriver(to, root, job.GetenvList("GraphOptions"))
+	if err != nil {
+		return job.Errorf("Cannot load the %s storage driver: %s", to, err)
+	}
+	defer toDriver.Cleanup()
+
+	if err := migrateStorage(root, fromDriver, toDriver, job.Stdout); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+// migrateStorage re-registers the image layers of root, parents first,
+// then the layers of the containers in the driver to. The layers already
+// in to are kept, so an interrupted migration can be resumed: the layer it
+// was migrating is migrated again.
+func migrateStorage(root string, from, to graphdriver.Driver, out io.Writer) error {
+	journal := path.Join(root, "migrating-"+to.String())
+	if err := resumeMigration(journal, to); err != nil {
+		return err
+	}
+
+	g, err := graph.NewGraph(path.Join(root, "graph"), from)
+	if err != nil {
+		return err
+	}
+	images, err := g.Map()
+	if err != nil {
+		return err
+	}
+
+	migrated := make(map[string]bool)
+	var migrateImage func(img *image.Image) error
+	migrateImage = func(img *image.Image) error {
+		if migrated[img.ID] {
+			return nil
+		}
+		if img.Parent != "" {
+			parent, exists := images[img.Parent]
+			if !exists {
+				return fmt.Errorf("Cannot migrate the image %s, its parent %s is missing", img.ID, img.Parent)
+			}
+			if err := migrateImage(parent); err != nil {
+				return err
+			}
+		}
+		migrated[img.ID] = true
+
+		fmt.Fprintf(out, "[%d/%d] Migrating the image %s\n", len(migrated), len(images), utils.TruncateID(img.ID))
+		if to.Exists(img.ID) {
+			return nil
+		}
+		size, err := migrateLayer(journal, from, to, img.ID, img.Parent, 0, false)
+		if err != nil {
+			return fmt.Errorf("Cannot migrate the image %s: %s", img.ID, err)
+		}
+		// The layersize of the image is the one it has in every driver
+		if img.Size >= 0 && size != img.Size {
+			to.Remove(img.ID)
+			return fmt.Errorf("Cannot migrate the image %s: its layer is %d bytes in %s but %d bytes in %s", img.ID, img.Size, from, size, to)
+		}
+		return nil
+	}
+	for _, img := range images {
+		if err := migrateImage(img); err != nil {
+			return err
+		}
+	}
+
+	if err := migrateRepositories(root, from.String(), to.String()); err != nil {
+		return err
+	}
+	return migrateContainers(path.Join(root, "containers"), journal, from, to, out)
+}
+
+// resumeMigration removes from to the layer an interrupted migration left
+// in the journal, it may be half-applied
+func resumeMigration(journal string, to graphdriver.Driver) error {
+	id, err := ioutil.ReadFile(journal)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if len(id) > 0 && to.Exists(string(id)) {
+		if err := to.Remove(string(id)); err != nil {
+			return fmt.Errorf("Cannot remove the layer %s left by an interrupted migration: %s", id, err)
+		}
+	}
+	return os.Remove(journal)
+}
+
+// migrateLayer copies the layer id, a child of parent, from one driver to
+// another through its diff and returns the size of the diff in to. A size
+// limits the new layer to size bytes. The layer is in the journal until it
+// is complete.
+//
+// The diff of the rw layer of a container is written through a mount of
+// the layer, as the container writes it: applying a diff makes an image
+// layer of it in drivers such as overlay, whose files may be the ones of
+// its image.
+func migrateLayer(journal string, from, to graphdriver.Driver, id, parent string, size int64, rw bool) (diffSize int64, err error) {
+	if err := ioutil.WriteFile(journal, []byte(id), 0600); err != nil {
+		return -1, err
+	}
+
+	diff, err := graphdriver.GetDiffer(from).Diff(id, parent)
+	if err != nil {
+		return -1, err
+	}
+	defer diff.Close()
+
+	if size > 0 {
+		quotaDriver, ok := to.(graphdriver.QuotaDriver)
+		if !ok {
+			return -1, fmt.Errorf("The %s storage driver cannot limit the storage size of the layers", to)
+		}
+		err = quotaDriver.CreateWithQuota(id, parent, size)
+	} else {
+		err = to.Create(id, parent)
+	}
+	if err != nil {
+		return -1, err
+	}
+	defer func() {
+		if err != nil {
+			to.Remove(id)
+		}
+	}()
+
+	differ := graphdriver.GetDiffer(to)
+	if rw {
+		err = graphdriver.NewNaiveDiffDriver(to).ApplyDiff(id, parent, diff)
+	} else {
+		err = differ.ApplyDiff(id, parent, diff)
+	}
+	if err != nil {
+		return -1, err
+	}
+	if diffSize, err = differ.DiffSize(id, parent); err != nil {
+		return -1, err
+	}
+	if err := os.Remove(journal); err != nil {
+		return -1, err
+	}
+	return diffSize, nil
+}
+
+// migrateRepositories adds the tags of the driver from to the ones of the
+// driver to, the tags already in to win
+func migrateRepositories(root, from, to string) error {
+	fromStore, err := graph.NewTagStore(path.Join(root, "repositories-"+from), nil)
+	if err != nil {
+		return err
+	}
+	toStore, err := graph.NewTagStore(path.Join(root, "repositories-"+to), nil)
+	if err != nil {
+		return err
+	}
+
+	merge := func(dst, src map[string]graph.Repository) {
+		for name, repository := range src {
+			if _, exists := dst[name]; !exists {
+				dst[name] = make(graph.Repository)
+			}
+			for tag, id := range repository {
+				if _, exists := dst[name][tag]; !exists {
+					dst[name][tag] = id
+				}
+			}
+		}
+	}
+	merge(toStore.Repositories, fromStore.Repositories)
+	merge(toStore.Digests, fromStore.Digests)
+	for id, verification := range fromStore.Verifications {
+		if _, exists := toStore.Verifications[id]; !exists {
+			toStore.Verifications[id] = verification
+		}
+	}
+	return toStore.Save()
+}
+
+// migrateContainers moves the containers of the driver from to the driver
+// to, with their init layer and their rw layer
+func migrateContainers(repository, journal string, from, to graphdriver.Driver, out io.Writer) error {
+	dir, err := ioutil.ReadDir(repository)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, v := range dir {
+		container := &Container{root: path.Join(repository, v.Name())}
+		if err := container.FromDisk(); err != nil {
+			utils.Errorf("Failed to load container %v: %v", v.Name(), err)
+			continue
+		}
+		// The containers of docker < 0.7 have no driver, they are aufs ones
+		if container.Driver != from.String() && !(container.Driver == "" && from.String() == "aufs") {
+			continue
+		}
+
+		fmt.Fprintf(out, "Migrating the container %s\n", utils.TruncateID(container.ID))
+		initID := fmt.Sprintf("%s-init", container.ID)
+		if !to.Exists(initID) {
+			if _, err := migrateLayer(journal, from, to, initID, container.Image, 0, false); err != nil {
+				return fmt.Errorf("Cannot migrate the container %s: %s", container.ID, err)
+			}
+		}
+		if !to.Exists(container.ID) {
+			if _, err := migrateLayer(journal, from, to, container.ID, initID, container.Config.StorageSize, true); err != nil {
+				return fmt.Errorf("Cannot migrate the container %s: %s", container.ID, err)
+			}
+		}
+
+		container.Driver = to.String()
+		if err := container.ToDisk(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package daemon
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/dotcloud/docker/archive"
+	"github.com/dotcloud/docker/daemon/graphdriver"
+	_ "github.com/dotcloud/docker/daemon/graphdriver/vfs"
+	"github.com/dotcloud/docker/graph"
+	"github.com/dotcloud/docker/image"
+	"github.com/dotcloud/docker/runconfig"
+)
+
+// layerArchive returns a layer archive with a file of the given content
+func layerArchive(t *testing.T, name, content string) archive.Archive {
+	dir, err := ioutil.TempDir("", "docker-test-layer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(path.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	layer, err := archive.Tar(dir, archive.Uncompressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(layer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ioutil.NopCloser(bytes.NewReader(data))
+}
+
+// newMigrationRoot fills root with an image of two layers tagged
+// test:latest and a container of it in the driver from, and returns the
+// images
+func newMigrationRoot(t *testing.T, root string, from graphdriver.Driver) (base, child *image.Image) {
+	g, err := graph.NewGraph(path.Join(root, "graph"), from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, err = g.Create(layerArchive(t, "base", "base data"), "", "", "", "", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	child, err = g.Create(layerArchive(t, "child", "child data"), "container", base.ID, "", "", &runconfig.Config{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := graph.NewTagStore(path.Join(root, "repositories-"+from.String()), g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("test", "latest", child.ID, false); err != nil {
+		t.Fatal(err)
+	}
+
+	container := &Container{
+		ID:         "c1",
+		Image:      child.ID,
+		Config:     &runconfig.Config{},
+		Driver:     from.String(),
+		root:       path.Join(root, "containers", "c1"),
+		hostConfig: &runconfig.HostConfig{},
+	}
+	if err := os.MkdirAll(container.root, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := container.ToDisk(); err != nil {
+		t.Fatal(err)
+	}
+	if err := from.Create("c1-init", child.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := from.Create("c1", "c1-init"); err != nil {
+		t.Fatal(err)
+	}
+	rootfs, err := from.Get("c1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(rootfs, "rw"), []byte("rw data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	from.Put("c1")
+	return base, child
+}
+
+// checkMigration checks the container and the tag of newMigrationRoot
+// were migrated to the driver to
+func checkMigration(t *testing.T, root string, to graphdriver.Driver, child *image.Image) {
+	rootfs, err := to.Get("c1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"base": "base data", "child": "child data", "rw": "rw data"} {
+		if data, err := ioutil.ReadFile(path.Join(rootfs, name)); err != nil || string(data) != content {
+			t.Fatalf("Expected %s to contain %q, got %q (%v)", name, content, data, err)
+		}
+	}
+	to.Put("c1")
+
+	migrated := &Container{root: path.Join(root, "containers", "c1")}
+	if err := migrated.FromDisk(); err != nil {
+		t.Fatal(err)
+	}
+	if migrated.Driver != to.String() {
+		t.Fatalf("Expected the container to be moved to %s, got %s", to, migrated.Driver)
+	}
+
+	store, err := graph.NewTagStore(path.Join(root, "repositories-"+to.String()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := store.Repositories["test"]["latest"]; id != child.ID {
+		t.Fatalf("Expected test:latest to be migrated, got %s", id)
+	}
+}
+
+func TestMigrateStorage(t *testing.T) {
+	root, err := ioutil.TempDir("", "docker-test-migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	from, err := graphdriver.GetDriver("vfs", root, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := graphdriver.GetDriver("overlay", root, nil)
+	if err != nil {
+		t.Skipf("The overlay storage driver is not supported: %s", err)
+	}
+	defer to.Cleanup()
+
+	_, child := newMigrationRoot(t, root, from)
+	if err := migrateStorage(root, from, to, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	checkMigration(t, root, to, child)
+
+	// The writes of the migrated container leave its image alone
+	rootfs, err := to.Get("c1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(rootfs, "child"), []byte("container data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	to.Put("c1")
+	imageRootfs, err := to.Get(child.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadFile(path.Join(imageRootfs, "child")); err != nil || string(data) != "child data" {
+		t.Fatalf("Expected the image to be unchanged by the container, got %q (%v)", data, err)
+	}
+	to.Put(child.ID)
+
+	// A second migration keeps the layers already migrated
+	if err := migrateStorage(root, from, to, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newVfsMigration returns a docker root and two vfs drivers, the second
+// one in a root of its own
+func newVfsMigration(t *testing.T) (root string, from, to graphdriver.Driver) {
+	root, err := ioutil.TempDir("", "docker-test-migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from, err = graphdriver.GetDriver("vfs", root, nil); err != nil {
+		t.Fatal(err)
+	}
+	if to, err = graphdriver.GetDriver("vfs", path.Join(root, "to"), nil); err != nil {
+		t.Fatal(err)
+	}
+	return root, from, to
+}
+
+func TestMigrateStorageVfs(t *testing.T) {
+	root, from, to := newVfsMigration(t)
+	defer os.RemoveAll(root)
+
+	_, child := newMigrationRoot(t, root, from)
+	if err := migrateStorage(root, from, to, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	checkMigration(t, root, to, child)
+	if _, err := os.Stat(path.Join(root, "migrating-vfs")); !os.IsNotExist(err) {
+		t.Fatalf("Expected the journal to be removed, got %v", err)
+	}
+}
+
+func TestMigrateStorageResumesHalfAppliedLayer(t *testing.T) {
+	root, from, to := newVfsMigration(t)
+	defer os.RemoveAll(root)
+
+	base, child := newMigrationRoot(t, root, from)
+
+	// An interrupted migration created the base layer but did not apply it
+	if err := to.Create(base.ID, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(root, "migrating-vfs"), []byte(base.ID), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrateStorage(root, from, to, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	checkMigration(t, root, to, child)
+}
@@ -9,12 +9,14 @@ import (
 type PortMapping map[string]string // Deprecated
 
 type NetworkSettings struct {
-	IPAddress   string
-	IPPrefixLen int
-	Gateway     string
-	Bridge      string
-	PortMapping map[string]PortMapping // Deprecated
-	Ports       nat.PortMap
+	IPAddress     string
+	IPPrefixLen   int
+	Gateway       string
+	Bridge        string
+	HairpinMode   bool
+	HostInterface string                 // set when the interface is provided by a network plugin
+	PortMapping   map[string]PortMapping // Deprecated
+	Ports         nat.PortMap
 }
 
 func (settings *NetworkSettings) PortMappingAPI() *engine.Table {
@@ -12,6 +12,7 @@ import (
 	"github.com/dotcloud/docker/daemon/networkdriver/portallocator"
 	"github.com/dotcloud/docker/daemon/networkdriver/portmapper"
 	"github.com/dotcloud/docker/engine"
+	"github.com/dotcloud/docker/nat"
 	"github.com/dotcloud/docker/pkg/iptables"
 	"github.com/dotcloud/docker/pkg/netlink"
 	"github.com/dotcloud/docker/pkg/networkfs/resolvconf"
@@ -51,6 +52,7 @@ var (
 
 	bridgeIface   string
 	bridgeNetwork *net.IPNet
+	hairpinMode   bool
 
 	defaultBindingIP  = net.ParseIP("0.0.0.0")
 	currentInterfaces = make(map[string]*networkInterface)
@@ -63,12 +65,24 @@ func InitDriver(job *engine.Job) engine.Status {
 		icc            = job.GetenvBool("InterContainerCommunication")
 		ipForward      = job.GetenvBool("EnableIpForward")
 		bridgeIP       = job.Getenv("BridgeIP")
+		// the userland proxy is used unless explicitly disabled
+		enableUserlandProxy = !job.EnvExists("EnableUserlandProxy") || job.GetenvBool("EnableUserlandProxy")
 	)
 
 	if defaultIP := job.Getenv("DefaultBindingIP"); defaultIP != "" {
 		defaultBindingIP = net.ParseIP(defaultIP)
 	}
 
+	if portRange := job.Getenv("PortRange"); portRange != "" {
+		begin, end, err := nat.ParsePortRange(portRange)
+		if err != nil {
+			return job.Errorf("invalid port range %s: %s", portRange, err)
+		}
+		if err := portallocator.SetPortRange(begin, end); err != nil {
+			return job.Errorf("invalid port range %s: %s", portRange, err)
+		}
+	}
+
 	bridgeIface = job.Getenv("BridgeIface")
 	usingDefaultBridge := false
 	if bridgeIface == "" {
@@ -109,9 +123,20 @@ func InitDriver(job *engine.Job) engine.Status {
 		}
 	}
 
+	hairpinMode = false
+	if !enableUserlandProxy {
+		if !enableIPTables {
+			job.Logf("WARNING: hairpin NAT requires iptables, falling back to the userland proxy")
+		} else if err := enableRouteLocalnet(bridgeIface); err != nil {
+			job.Logf("WARNING: unable to route the loopback to %s, falling back to the userland proxy: %s", bridgeIface, err)
+		} else {
+			hairpinMode = true
+		}
+	}
+
 	// Configure iptables for link support
 	if enableIPTables {
-		if err := setupIPTables(addr, icc); err != nil {
+		if err := setupIPTables(addr, icc, hairpinMode); err != nil {
 			return job.Error(err)
 		}
 	}
@@ -129,12 +154,13 @@ func InitDriver(job *engine.Job) engine.Status {
 	}
 
 	if enableIPTables {
-		chain, err := iptables.NewChain("DOCKER", bridgeIface)
+		chain, err := iptables.NewChain("DOCKER", bridgeIface, hairpinMode)
 		if err != nil {
 			return job.Error(err)
 		}
 		portmapper.SetIptablesChain(chain)
 	}
+	portmapper.SetUserlandProxy(!hairpinMode)
 
 	bridgeNetwork = network
 
@@ -154,7 +180,7 @@ func InitDriver(job *engine.Job) engine.Status {
 	return engine.StatusOK
 }
 
-func setupIPTables(addr net.Addr, icc bool) error {
+func setupIPTables(addr net.Addr, icc, hairpin bool) error {
 	// Enable NAT
 	natArgs := []string{"POSTROUTING", "-t", "nat", "-s", addr.String(), "!", "-d", addr.String(), "-j", "MASQUERADE"}
 
@@ -166,6 +192,20 @@ func setupIPTables(addr net.Addr, icc bool) error {
 		}
 	}
 
+	// In hairpin mode connections from the loopback to published ports are
+	// routed to the bridge, they must leave with the bridge address
+	localArgs := []string{"POSTROUTING", "-t", "nat", "-m", "addrtype", "--src-type", "LOCAL", "-o", bridgeIface, "-j", "MASQUERADE"}
+
+	if !hairpin {
+		iptables.Raw(append([]string{"-D"}, localArgs...)...)
+	} else if !iptables.Exists(localArgs...) {
+		if output, err := iptables.Raw(append([]string{"-I"}, localArgs...)...); err != nil {
+			return fmt.Errorf("Unable to enable loopback NAT: %s", err)
+		} else if len(output) != 0 {
+			return fmt.Errorf("Error iptables postrouting: %s", output)
+		}
+	}
+
 	var (
 		args       = []string{"FORWARD", "-i", bridgeIface, "-o", bridgeIface, "-j"}
 		acceptArgs = append(args, "ACCEPT")
@@ -219,6 +259,12 @@ func setupIPTables(addr net.Addr, icc bool) error {
 	return nil
 }
 
+// enableRouteLocalnet allows packets from and to the loopback addresses
+// to be routed to the bridge, which is needed to DNAT them to containers
+func enableRouteLocalnet(iface string) error {
+	return ioutil.WriteFile(fmt.Sprintf("/proc/sys/net/ipv4/conf/%s/route_localnet", iface), []byte{'1', '\n'}, 0644)
+}
+
 // CreateBridgeIface creates a network bridge interface on the host system with the name `ifaceName`,
 // and attempts to configure it with an address which doesn't conflict with any other interface on the host.
 // If it can't find an address which doesn't conflict, it will return an error.
@@ -317,6 +363,7 @@ func Allocate(job *engine.Job) engine.Status {
 	out.Set("Mask", bridgeNetwork.Mask.String())
 	out.Set("Gateway", bridgeNetwork.IP.String())
 	out.Set("Bridge", bridgeIface)
+	out.SetBool("HairpinMode", hairpinMode)
 
 	size, _ := bridgeNetwork.Mask.Size()
 	out.SetInt("IPPrefixLen", size)
@@ -373,23 +420,30 @@ func Release(job *engine.Job) engine.Status {
 }
 
 // Allocate an external port and map it to the interface
+// A range of ports is allocated and mapped at once when ContainerPortEnd
+// is set, in which case the host ports are the same as the container ports
 func AllocatePort(job *engine.Job) engine.Status {
 	var (
 		err error
 
-		ip            = defaultBindingIP
-		id            = job.Args[0]
-		hostIP        = job.Getenv("HostIP")
-		hostPort      = job.GetenvInt("HostPort")
-		containerPort = job.GetenvInt("ContainerPort")
-		proto         = job.Getenv("Proto")
-		network       = currentInterfaces[id]
+		ip               = defaultBindingIP
+		id               = job.Args[0]
+		hostIP           = job.Getenv("HostIP")
+		hostPort         = job.GetenvInt("HostPort")
+		containerPort    = job.GetenvInt("ContainerPort")
+		containerPortEnd = job.GetenvInt("ContainerPortEnd")
+		proto            = job.Getenv("Proto")
+		network          = currentInterfaces[id]
 	)
 
 	if hostIP != "" {
 		ip = net.ParseIP(hostIP)
 	}
 
+	if containerPortEnd > containerPort {
+		return allocatePortRange(job, network, ip, proto, containerPort, containerPortEnd)
+	}
+
 	// host ip, proto, and host port
 	hostPort, err = portallocator.RequestPort(ip, proto, hostPort)
 	if err != nil {
@@ -425,6 +479,48 @@ func AllocatePort(job *engine.Job) engine.Status {
 	return engine.StatusOK
 }
 
+func allocatePortRange(job *engine.Job, network *networkInterface, ip net.IP, proto string, start, end int) engine.Status {
+	for port := start; port <= end; port++ {
+		if _, err := portallocator.RequestPort(ip, proto, port); err != nil {
+			for allocated := start; allocated < port; allocated++ {
+				portallocator.ReleasePort(ip, proto, allocated)
+			}
+			return job.Error(err)
+		}
+	}
+
+	var container net.Addr
+	if proto == "tcp" {
+		container = &net.TCPAddr{IP: network.IP, Port: start}
+	} else {
+		container = &net.UDPAddr{IP: network.IP, Port: start}
+	}
+
+	if err := portmapper.MapRange(container, ip, start, end); err != nil {
+		for port := start; port <= end; port++ {
+			portallocator.ReleasePort(ip, proto, port)
+		}
+		return job.Error(err)
+	}
+
+	for port := start; port <= end; port++ {
+		if proto == "tcp" {
+			network.PortMappings = append(network.PortMappings, &net.TCPAddr{IP: ip, Port: port})
+		} else {
+			network.PortMappings = append(network.PortMappings, &net.UDPAddr{IP: ip, Port: port})
+		}
+	}
+
+	out := engine.Env{}
+	out.Set("HostIP", ip.String())
+	out.SetInt("HostPort", start)
+
+	if _, err := out.WriteTo(job.Stdout); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
 func LinkContainers(job *engine.Job) engine.Status {
 	var (
 		action       = job.Args[0]
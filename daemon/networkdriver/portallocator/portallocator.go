@@ -19,11 +19,14 @@ type (
 
 var (
 	ErrAllPortsAllocated    = errors.New("all ports are allocated")
+	ErrInvalidPortRange     = errors.New("invalid port range")
 	ErrPortAlreadyAllocated = errors.New("port has already been allocated")
 	ErrUnknownProtocol      = errors.New("unknown protocol")
 )
 
 var (
+	beginPortRange     = BeginPortRange
+	endPortRange       = EndPortRange
 	currentDynamicPort = map[string]int{
 		"tcp": BeginPortRange - 1,
 		"udp": BeginPortRange - 1,
@@ -39,6 +42,24 @@ func init() {
 	defaultAllocatedPorts["udp"] = collections.NewOrderedIntSet()
 }
 
+// SetPortRange changes the range of ports used for dynamic
+// allocation, the next allocated port will be begin
+func SetPortRange(begin, end int) error {
+	if begin <= 0 || end > 65535 || begin > end {
+		return ErrInvalidPortRange
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	beginPortRange = begin
+	endPortRange = end
+	currentDynamicPort["tcp"] = beginPortRange - 1
+	currentDynamicPort["udp"] = beginPortRange - 1
+
+	return nil
+}
+
 // RequestPort returns an available port if the port is 0
 // If the provided port is not 0 then it will be checked if
 // it is available for allocation
@@ -87,8 +108,8 @@ func ReleaseAll() error {
 	lock.Lock()
 	defer lock.Unlock()
 
-	currentDynamicPort["tcp"] = BeginPortRange - 1
-	currentDynamicPort["udp"] = BeginPortRange - 1
+	currentDynamicPort["tcp"] = beginPortRange - 1
+	currentDynamicPort["udp"] = beginPortRange - 1
 
 	defaultAllocatedPorts = portMappings{}
 	defaultAllocatedPorts["tcp"] = collections.NewOrderedIntSet()
@@ -164,8 +185,8 @@ func findNextPort(proto string, allocated *collections.OrderedIntSet) (int, erro
 
 func nextPort(proto string) int {
 	c := currentDynamicPort[proto] + 1
-	if c > EndPortRange {
-		c = BeginPortRange
+	if c > endPortRange || c < beginPortRange {
+		c = beginPortRange
 	}
 	currentDynamicPort[proto] = c
 	return c
@@ -211,3 +211,34 @@ func TestPortAllocation(t *testing.T) {
 		t.Fatal("Requesting a dynamic port should never allocate a used port")
 	}
 }
+
+func TestSetPortRange(t *testing.T) {
+	defer func() {
+		SetPortRange(BeginPortRange, EndPortRange)
+		reset()
+	}()
+
+	if err := SetPortRange(60000, 60001); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []int{60000, 60001} {
+		port, err := RequestPort(defaultIP, "tcp", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if port != expected {
+			t.Fatalf("Expected port %d got %d", expected, port)
+		}
+	}
+
+	if _, err := RequestPort(defaultIP, "tcp", 0); err != ErrAllPortsAllocated {
+		t.Fatalf("Expected error %s got %s", ErrAllPortsAllocated, err)
+	}
+
+	for _, r := range [][2]int{{0, 10}, {100, 70000}, {2000, 1000}} {
+		if err := SetPortRange(r[0], r[1]); err != ErrInvalidPortRange {
+			t.Fatalf("Expected error %s for range %d-%d got %s", ErrInvalidPortRange, r[0], r[1], err)
+		}
+	}
+}
@@ -10,16 +10,33 @@ import (
 )
 
 type mapping struct {
+	proto     string
+	host      net.Addr
+	container net.Addr
+	rule      *forwardRule
+}
+
+// forwardRule holds the iptables rules shared by all the ports of a mapped
+// range and the userland proxy of a single port, they are removed with the
+// last unmapped port
+type forwardRule struct {
 	proto         string
 	userlandProxy proxy.Proxy
-	host          net.Addr
-	container     net.Addr
+	hostIP        net.IP
+	hostPortStart int
+	hostPortEnd   int
+	containerIP   string
+	containerPort int
+	refs          int
 }
 
 var (
 	chain *iptables.Chain
 	lock  sync.Mutex
 
+	// without the userland proxy the ports are only forwarded by iptables
+	enableUserlandProxy = true
+
 	// udp:ip:port
 	currentMappings = make(map[string]*mapping)
 	newProxy        = proxy.NewProxy
@@ -29,55 +46,86 @@ var (
 	ErrUnknownBackendAddressType = errors.New("unknown container address type not supported")
 	ErrPortMappedForIP           = errors.New("port is already mapped to ip")
 	ErrPortNotMapped             = errors.New("port is not mapped")
+	ErrInvalidPortRange          = errors.New("invalid port range")
 )
 
 func SetIptablesChain(c *iptables.Chain) {
 	chain = c
 }
 
+func SetUserlandProxy(enabled bool) {
+	enableUserlandProxy = enabled
+}
+
 func Map(container net.Addr, hostIP net.IP, hostPort int) error {
+	return MapRange(container, hostIP, hostPort, hostPort)
+}
+
+// MapRange maps the host ports from hostPortStart to hostPortEnd to the
+// ports of the container starting at the container address. The ports of
+// a range are forwarded unchanged, so when more than one port is mapped
+// the container port has to be equal to hostPortStart. A range is only
+// forwarded by its iptables rules, without a userland proxy per port.
+func MapRange(container net.Addr, hostIP net.IP, hostPortStart, hostPortEnd int) error {
 	lock.Lock()
 	defer lock.Unlock()
 
-	var m *mapping
+	var proto string
 	switch container.(type) {
 	case *net.TCPAddr:
-		m = &mapping{
-			proto:     "tcp",
-			host:      &net.TCPAddr{IP: hostIP, Port: hostPort},
-			container: container,
-		}
+		proto = "tcp"
 	case *net.UDPAddr:
-		m = &mapping{
-			proto:     "udp",
-			host:      &net.UDPAddr{IP: hostIP, Port: hostPort},
-			container: container,
-		}
+		proto = "udp"
 	default:
 		return ErrUnknownBackendAddressType
 	}
 
-	key := getKey(m.host)
-	if _, exists := currentMappings[key]; exists {
-		return ErrPortMappedForIP
+	containerIP, containerPort := getIPAndPort(container)
+	if hostPortEnd < hostPortStart || (hostPortEnd != hostPortStart && containerPort != hostPortStart) {
+		return ErrInvalidPortRange
 	}
 
-	containerIP, containerPort := getIPAndPort(m.container)
-	if err := forward(iptables.Add, m.proto, hostIP, hostPort, containerIP.String(), containerPort); err != nil {
-		return err
+	mappings := make([]*mapping, 0, hostPortEnd-hostPortStart+1)
+	for hostPort := hostPortStart; hostPort <= hostPortEnd; hostPort++ {
+		m := &mapping{
+			proto:     proto,
+			host:      newAddr(proto, hostIP, hostPort),
+			container: newAddr(proto, containerIP, containerPort+hostPort-hostPortStart),
+		}
+		if _, exists := currentMappings[getKey(m.host)]; exists {
+			return ErrPortMappedForIP
+		}
+		mappings = append(mappings, m)
 	}
 
-	p, err := newProxy(m.host, m.container)
-	if err != nil {
-		// need to undo the iptables rules before we reutrn
-		forward(iptables.Delete, m.proto, hostIP, hostPort, containerIP.String(), containerPort)
+	rule := &forwardRule{
+		proto:         proto,
+		hostIP:        hostIP,
+		hostPortStart: hostPortStart,
+		hostPortEnd:   hostPortEnd,
+		containerIP:   containerIP.String(),
+		containerPort: containerPort,
+		refs:          len(mappings),
+	}
+	if err := rule.forward(iptables.Add); err != nil {
 		return err
 	}
 
-	m.userlandProxy = p
-	currentMappings[key] = m
+	if enableUserlandProxy && len(mappings) == 1 {
+		p, err := newProxy(mappings[0].host, mappings[0].container)
+		if err != nil {
+			// need to undo the iptables rules before we return
+			rule.forward(iptables.Delete)
+			return err
+		}
+		rule.userlandProxy = p
+		go p.Run()
+	}
 
-	go p.Run()
+	for _, m := range mappings {
+		m.rule = rule
+		currentMappings[getKey(m.host)] = m
+	}
 
 	return nil
 }
@@ -92,17 +140,37 @@ func Unmap(host net.Addr) error {
 		return ErrPortNotMapped
 	}
 
-	data.userlandProxy.Close()
 	delete(currentMappings, key)
 
-	containerIP, containerPort := getIPAndPort(data.container)
-	hostIP, hostPort := getIPAndPort(data.host)
-	if err := forward(iptables.Delete, data.proto, hostIP, hostPort, containerIP.String(), containerPort); err != nil {
-		return err
+	data.rule.refs--
+	if data.rule.refs == 0 {
+		if data.rule.userlandProxy != nil {
+			data.rule.userlandProxy.Close()
+		}
+		if err := data.rule.forward(iptables.Delete); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+func (r *forwardRule) forward(action iptables.Action) error {
+	if chain == nil {
+		return nil
+	}
+	if r.hostPortStart == r.hostPortEnd {
+		return chain.Forward(action, r.hostIP, r.hostPortStart, r.proto, r.containerIP, r.containerPort)
+	}
+	return chain.ForwardRange(action, r.hostIP, r.hostPortStart, r.hostPortEnd, r.proto, r.containerIP)
+}
+
+func newAddr(proto string, ip net.IP, port int) net.Addr {
+	if proto == "udp" {
+		return &net.UDPAddr{IP: ip, Port: port}
+	}
+	return &net.TCPAddr{IP: ip, Port: port}
+}
+
 func getKey(a net.Addr) string {
 	switch t := a.(type) {
 	case *net.TCPAddr:
@@ -122,10 +190,3 @@ func getIPAndPort(a net.Addr) (net.IP, int) {
 	}
 	return nil, 0
 }
-
-func forward(action iptables.Action, proto string, sourceIP net.IP, sourcePort int, containerIP string, containerPort int) error {
-	if chain == nil {
-		return nil
-	}
-	return chain.Forward(action, sourceIP, sourcePort, proto, containerIP, containerPort)
-}
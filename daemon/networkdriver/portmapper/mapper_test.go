@@ -14,6 +14,7 @@ func init() {
 
 func reset() {
 	chain = nil
+	enableUserlandProxy = true
 	currentMappings = make(map[string]*mapping)
 }
 
@@ -105,3 +106,63 @@ func TestGetUDPIPAndPort(t *testing.T) {
 		t.Fatalf("expected port %d got %d", ep, port)
 	}
 }
+
+func TestMapPortRange(t *testing.T) {
+	defer reset()
+
+	hostIp := net.ParseIP("192.168.0.1")
+	containerAddr := &net.UDPAddr{IP: net.ParseIP("172.16.0.1"), Port: 10000}
+
+	if err := MapRange(containerAddr, hostIp, 10000, 10099); err != nil {
+		t.Fatalf("Failed to map port range: %s", err)
+	}
+
+	if len(currentMappings) != 100 {
+		t.Fatalf("Expected 100 mappings, got %d", len(currentMappings))
+	}
+
+	// The range is only forwarded by iptables
+	for _, m := range currentMappings {
+		if m.rule.userlandProxy != nil {
+			t.Fatalf("Expected no userland proxy for the range")
+		}
+	}
+
+	if Map(&net.UDPAddr{IP: net.ParseIP("172.16.0.2"), Port: 53}, hostIp, 10050) == nil {
+		t.Fatalf("Port is in use - mapping should have failed")
+	}
+
+	if MapRange(&net.UDPAddr{IP: net.ParseIP("172.16.0.2"), Port: 20000}, hostIp, 10100, 10199) == nil {
+		t.Fatalf("Container ports differ from host ports - mapping should have failed")
+	}
+
+	for port := 10000; port <= 10099; port++ {
+		if err := Unmap(&net.UDPAddr{IP: hostIp, Port: port}); err != nil {
+			t.Fatalf("Failed to release port %d: %s", port, err)
+		}
+	}
+
+	if len(currentMappings) != 0 {
+		t.Fatalf("Expected no mappings left, got %d", len(currentMappings))
+	}
+}
+
+func TestMapWithoutUserlandProxy(t *testing.T) {
+	defer reset()
+
+	SetUserlandProxy(false)
+
+	hostIp := net.ParseIP("192.168.0.1")
+	if err := Map(&net.TCPAddr{IP: net.ParseIP("172.16.0.1"), Port: 80}, hostIp, 8080); err != nil {
+		t.Fatalf("Failed to allocate port: %s", err)
+	}
+
+	hostAddr := &net.TCPAddr{IP: hostIp, Port: 8080}
+	if m := currentMappings[getKey(hostAddr)]; m == nil || m.rule.userlandProxy != nil {
+		t.Fatalf("Expected a mapping without userland proxy, got %v", m)
+	}
+
+	if err := Unmap(hostAddr); err != nil {
+		t.Fatalf("Failed to release port: %s", err)
+	}
+}
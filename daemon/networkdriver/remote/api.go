@@ -0,0 +1,84 @@
+package remote
+
+// The network driver plugin API is a set of JSON requests POSTed over
+// HTTP to a unix socket. Each response carries an Err field which is set
+// when the plugin failed to honor the request.
+
+const (
+	createNetworkPath  = "/NetworkDriver.CreateNetwork"
+	createEndpointPath = "/NetworkDriver.CreateEndpoint"
+	joinPath           = "/NetworkDriver.Join"
+	leavePath          = "/NetworkDriver.Leave"
+	deleteEndpointPath = "/NetworkDriver.DeleteEndpoint"
+)
+
+type response struct {
+	Err string
+}
+
+func (r *response) GetError() string {
+	return r.Err
+}
+
+type maybeError interface {
+	GetError() string
+}
+
+// CreateNetworkRequest is sent once when the daemon starts
+type CreateNetworkRequest struct {
+	NetworkID string
+	Mtu       int
+}
+
+type CreateNetworkResponse struct {
+	response
+}
+
+// CreateEndpointRequest asks the plugin to reserve the resources of
+// a container on the network, the EndpointID is the container's ID
+type CreateEndpointRequest struct {
+	NetworkID  string
+	EndpointID string
+}
+
+type CreateEndpointResponse struct {
+	response
+}
+
+// JoinRequest is sent before the container starts
+type JoinRequest struct {
+	NetworkID  string
+	EndpointID string
+}
+
+// JoinResponse names the host interface which is moved into the container
+// and renamed eth0, Address is in CIDR notation
+type JoinResponse struct {
+	response
+	InterfaceName string
+	Address       string
+	Gateway       string
+}
+
+// LeaveRequest is sent when the container stops, the plugin releases
+// the endpoint and everything it reserved for it
+type LeaveRequest struct {
+	NetworkID  string
+	EndpointID string
+}
+
+type LeaveResponse struct {
+	response
+}
+
+// DeleteEndpointRequest is sent when the container could not join the
+// network after its endpoint was created, the plugin releases what it
+// reserved for the endpoint
+type DeleteEndpointRequest struct {
+	NetworkID  string
+	EndpointID string
+}
+
+type DeleteEndpointResponse struct {
+	response
+}
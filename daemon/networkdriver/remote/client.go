@@ -0,0 +1,58 @@
+package remote
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+const dialTimeout = 30 * time.Second
+
+// client talks to a network driver plugin listening on a unix socket
+type client struct {
+	socket string
+	http   *http.Client
+}
+
+func newClient(socket string) *client {
+	tr := &http.Transport{
+		Dial: func(_, _ string) (net.Conn, error) {
+			return net.DialTimeout("unix", socket, dialTimeout)
+		},
+	}
+	return &client{
+		socket: socket,
+		http:   &http.Client{Transport: tr},
+	}
+}
+
+// call POSTs args to the plugin and decodes the answer into ret
+func (c *client) call(path string, args interface{}, ret maybeError) error {
+	body, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+
+	// the host is ignored when dialing the unix socket
+	resp, err := c.http.Post("http://plugin"+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("network plugin %s: %s", c.socket, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("network plugin %s: %s %s: %s", c.socket, path, resp.Status, bytes.TrimSpace(msg))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(ret); err != nil {
+		return fmt.Errorf("network plugin %s: invalid response to %s: %s", c.socket, path, err)
+	}
+	if e := ret.GetError(); e != "" {
+		return fmt.Errorf("network plugin %s: %s", c.socket, e)
+	}
+	return nil
+}
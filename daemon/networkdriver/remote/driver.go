@@ -0,0 +1,153 @@
+package remote
+
+import (
+	"net"
+	"sync"
+
+	"github.com/dotcloud/docker/engine"
+	"github.com/dotcloud/docker/utils"
+)
+
+// DefaultNetworkID is the id of the network created on the plugin
+// when the daemon starts, every container joins it
+const DefaultNetworkID = "default"
+
+var (
+	plugin *client
+
+	// the ids of the containers which joined the network
+	currentEndpoints = make(map[string]struct{})
+	lock             sync.Mutex
+)
+
+// InitDriver connects the daemon to the network driver plugin listening
+// on the unix socket given in the Socket env and registers the jobs of
+// a network driver which forward to it
+func InitDriver(job *engine.Job) engine.Status {
+	socket := job.Getenv("Socket")
+	if socket == "" {
+		return job.Errorf("no socket given for the network plugin")
+	}
+	plugin = newClient(socket)
+
+	var (
+		req = &CreateNetworkRequest{
+			NetworkID: DefaultNetworkID,
+			Mtu:       job.GetenvInt("Mtu"),
+		}
+		res = &CreateNetworkResponse{}
+	)
+	if err := plugin.call(createNetworkPath, req, res); err != nil {
+		return job.Error(err)
+	}
+
+	for name, f := range map[string]engine.Handler{
+		"allocate_interface": Allocate,
+		"release_interface":  Release,
+		"allocate_port":      AllocatePort,
+		"link":               LinkContainers,
+	} {
+		if err := job.Eng.Register(name, f); err != nil {
+			return job.Error(err)
+		}
+	}
+	return engine.StatusOK
+}
+
+// Allocate creates an endpoint for the container and joins it to the network
+func Allocate(job *engine.Job) engine.Status {
+	id := job.Args[0]
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := plugin.call(createEndpointPath, &CreateEndpointRequest{
+		NetworkID:  DefaultNetworkID,
+		EndpointID: id,
+	}, &CreateEndpointResponse{}); err != nil {
+		return job.Error(err)
+	}
+
+	joined := &JoinResponse{}
+	if err := plugin.call(joinPath, &JoinRequest{
+		NetworkID:  DefaultNetworkID,
+		EndpointID: id,
+	}, joined); err != nil {
+		if err := deleteEndpoint(id); err != nil {
+			utils.Errorf("Unable to delete the endpoint of %s: %s", id, err)
+		}
+		return job.Error(err)
+	}
+	currentEndpoints[id] = struct{}{}
+
+	ip, network, err := net.ParseCIDR(joined.Address)
+	if err != nil {
+		release(id)
+		return job.Errorf("network plugin returned an invalid address %s: %s", joined.Address, err)
+	}
+	if joined.InterfaceName == "" {
+		release(id)
+		return job.Errorf("network plugin returned no interface for %s", id)
+	}
+
+	out := engine.Env{}
+	out.Set("IP", ip.String())
+	out.Set("Mask", network.Mask.String())
+	out.Set("Gateway", joined.Gateway)
+	out.Set("HostInterface", joined.InterfaceName)
+
+	size, _ := network.Mask.Size()
+	out.SetInt("IPPrefixLen", size)
+
+	out.WriteTo(job.Stdout)
+
+	return engine.StatusOK
+}
+
+// Release makes the container leave the network and deletes its endpoint
+func Release(job *engine.Job) engine.Status {
+	id := job.Args[0]
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if _, exists := currentEndpoints[id]; !exists {
+		return job.Errorf("No network information to release for %s", id)
+	}
+	if err := release(id); err != nil {
+		utils.Errorf("Unable to release the endpoint of %s: %s", id, err)
+	}
+	return engine.StatusOK
+}
+
+// release makes the container id leave the network, then deletes its
+// endpoint even if leaving failed
+func release(id string) error {
+	delete(currentEndpoints, id)
+	err := plugin.call(leavePath, &LeaveRequest{
+		NetworkID:  DefaultNetworkID,
+		EndpointID: id,
+	}, &LeaveResponse{})
+	if deleteErr := deleteEndpoint(id); err == nil {
+		err = deleteErr
+	}
+	return err
+}
+
+func deleteEndpoint(id string) error {
+	return plugin.call(deleteEndpointPath, &DeleteEndpointRequest{
+		NetworkID:  DefaultNetworkID,
+		EndpointID: id,
+	}, &DeleteEndpointResponse{})
+}
+
+// AllocatePort fails, exposing ports is up to the plugin's network
+func AllocatePort(job *engine.Job) engine.Status {
+	return job.Errorf("Publishing ports is not supported with a network plugin")
+}
+
+// LinkContainers does nothing, the isolation of the containers on the
+// network is handled by the plugin
+func LinkContainers(job *engine.Job) engine.Status {
+	return engine.StatusOK
+}
@@ -0,0 +1,187 @@
+package remote
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/dotcloud/docker/engine"
+)
+
+// fakePlugin records the requests it receives and hands out a fixed address
+type fakePlugin struct {
+	calls []string
+}
+
+func (p *fakePlugin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.calls = append(p.calls, r.URL.Path)
+
+	var req map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	res := map[string]string{}
+	switch r.URL.Path {
+	case joinPath:
+		if req["EndpointID"] == "unjoinable" {
+			res["Err"] = "no address left"
+			break
+		}
+		res["InterfaceName"] = "fake0"
+		res["Address"] = "10.10.0.2/16"
+		if req["EndpointID"] == "badaddress" {
+			res["Address"] = "10.10.0.2"
+		}
+		res["Gateway"] = "10.10.0.1"
+	case leavePath:
+		if req["EndpointID"] != "container" && req["EndpointID"] != "badaddress" {
+			res["Err"] = "unknown endpoint"
+		}
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+func startPlugin(t *testing.T) (*fakePlugin, string, func()) {
+	dir, err := ioutil.TempDir("", "docker-network-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	socket := path.Join(dir, "plugin.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &fakePlugin{}
+	go http.Serve(l, p)
+
+	return p, socket, func() {
+		l.Close()
+		os.RemoveAll(dir)
+		currentEndpoints = make(map[string]struct{})
+	}
+}
+
+func TestAllocateAndRelease(t *testing.T) {
+	p, socket, cleanup := startPlugin(t)
+	defer cleanup()
+
+	eng := engine.New()
+	eng.Register("init_remote_networkdriver", InitDriver)
+
+	job := eng.Job("init_remote_networkdriver")
+	job.Setenv("Socket", socket)
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	job = eng.Job("allocate_interface", "container")
+	env, err := job.Stdout.AddEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if ip := env.Get("IP"); ip != "10.10.0.2" {
+		t.Fatalf("Expected IP 10.10.0.2 got %s", ip)
+	}
+	if size := env.GetInt("IPPrefixLen"); size != 16 {
+		t.Fatalf("Expected prefix length 16 got %d", size)
+	}
+	if iface := env.Get("HostInterface"); iface != "fake0" {
+		t.Fatalf("Expected interface fake0 got %s", iface)
+	}
+
+	if err := eng.Job("release_interface", "container").Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := eng.Job("release_interface", "container").Run(); err == nil {
+		t.Fatal("Releasing twice should fail")
+	}
+
+	expected := []string{createNetworkPath, createEndpointPath, joinPath, leavePath, deleteEndpointPath}
+	if len(p.calls) != len(expected) {
+		t.Fatalf("Expected calls %v got %v", expected, p.calls)
+	}
+	for i := range expected {
+		if p.calls[i] != expected[i] {
+			t.Fatalf("Expected calls %v got %v", expected, p.calls)
+		}
+	}
+}
+
+func TestPluginError(t *testing.T) {
+	_, socket, cleanup := startPlugin(t)
+	defer cleanup()
+
+	plugin = newClient(socket)
+	if err := plugin.call(leavePath, &LeaveRequest{EndpointID: "unknown"}, &LeaveResponse{}); err == nil {
+		t.Fatal("Expected the error of the plugin to be returned")
+	}
+}
+
+func TestAllocateDeletesEndpointOnJoinError(t *testing.T) {
+	p, socket, cleanup := startPlugin(t)
+	defer cleanup()
+
+	eng := engine.New()
+	eng.Register("init_remote_networkdriver", InitDriver)
+
+	job := eng.Job("init_remote_networkdriver")
+	job.Setenv("Socket", socket)
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := eng.Job("allocate_interface", "unjoinable").Run(); err == nil {
+		t.Fatal("Expected the allocation to fail")
+	}
+
+	expected := []string{createNetworkPath, createEndpointPath, joinPath, deleteEndpointPath}
+	if len(p.calls) != len(expected) {
+		t.Fatalf("Expected calls %v got %v", expected, p.calls)
+	}
+	for i := range expected {
+		if p.calls[i] != expected[i] {
+			t.Fatalf("Expected calls %v got %v", expected, p.calls)
+		}
+	}
+	if _, exists := currentEndpoints["unjoinable"]; exists {
+		t.Fatal("Expected no endpoint to be recorded")
+	}
+}
+
+func TestAllocateReleasesEndpointOnInvalidJoin(t *testing.T) {
+	p, socket, cleanup := startPlugin(t)
+	defer cleanup()
+
+	eng := engine.New()
+	eng.Register("init_remote_networkdriver", InitDriver)
+
+	job := eng.Job("init_remote_networkdriver")
+	job.Setenv("Socket", socket)
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := eng.Job("allocate_interface", "badaddress").Run(); err == nil {
+		t.Fatal("Expected the allocation to fail")
+	}
+
+	expected := []string{createNetworkPath, createEndpointPath, joinPath, leavePath, deleteEndpointPath}
+	if len(p.calls) != len(expected) {
+		t.Fatalf("Expected calls %v got %v", expected, p.calls)
+	}
+	for i := range expected {
+		if p.calls[i] != expected[i] {
+			t.Fatalf("Expected calls %v got %v", expected, p.calls)
+		}
+	}
+}
@@ -32,6 +32,43 @@ func migratePortMappings(config *runconfig.Config, hostConfig *runconfig.HostCon
 	return nil
 }
 
+// sortedPorts returns the ports of the set ordered by protocol then number
+func sortedPorts(ports nat.PortSet) []nat.Port {
+	sorted := make([]nat.Port, 0, len(ports))
+	for port := range ports {
+		sorted = append(sorted, port)
+	}
+	nat.Sort(sorted, func(ip, jp nat.Port) bool {
+		return ip.Proto() < jp.Proto() || (ip.Proto() == jp.Proto() && ip.Int() < jp.Int())
+	})
+	return sorted
+}
+
+// portRangeLength returns how many of the sorted ports, starting with the
+// first one, are consecutive ports each published once on the same host port
+// of the same host ip, these ports can be mapped together as a single range
+func portRangeLength(ports []nat.Port, bindings nat.PortMap) int {
+	isIdentity := func(port nat.Port) bool {
+		b := bindings[port]
+		return len(b) == 1 && b[0].HostPort == port.Port()
+	}
+
+	if len(ports) == 0 || !isIdentity(ports[0]) {
+		return 1
+	}
+
+	first := ports[0]
+	n := 1
+	for _, port := range ports[1:] {
+		if port.Proto() != first.Proto() || port.Int() != first.Int()+n ||
+			!isIdentity(port) || bindings[port][0].HostIp != bindings[first][0].HostIp {
+			break
+		}
+		n++
+	}
+	return n
+}
+
 func mergeLxcConfIntoOptions(hostConfig *runconfig.HostConfig, driverConfig map[string][]string) {
 	if hostConfig == nil {
 		return
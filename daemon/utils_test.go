@@ -3,6 +3,7 @@ package daemon
 import (
 	"testing"
 
+	"github.com/dotcloud/docker/nat"
 	"github.com/dotcloud/docker/runconfig"
 	"github.com/dotcloud/docker/utils"
 )
@@ -27,3 +28,31 @@ func TestMergeLxcConfig(t *testing.T) {
 		t.Fatalf("expected %s got %s", expected, cpuset)
 	}
 }
+
+func TestPortRangeLength(t *testing.T) {
+	ports, bindings, err := nat.ParsePortSpecs([]string{
+		"10000-10002:10000-10002/udp",
+		"10003:10003/tcp",
+		"10004:20004/udp",
+		"127.0.0.1:10005:10005/udp",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sorted := sortedPorts(ports)
+	if first := sorted[0]; first != "10003/tcp" {
+		t.Fatalf("expected first port 10003/tcp got %s", first)
+	}
+
+	for _, expected := range []int{1, 3, 1, 1} {
+		n := portRangeLength(sorted, bindings)
+		if n != expected {
+			t.Fatalf("expected range of length %d starting at %s got %d", expected, sorted[0], n)
+		}
+		sorted = sorted[n:]
+	}
+	if len(sorted) != 0 {
+		t.Fatalf("expected all ports to be grouped, %v left", sorted)
+	}
+}
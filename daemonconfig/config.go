@@ -7,8 +7,11 @@ import (
 )
 
 const (
-	defaultNetworkMtu    = 1500
-	DisableNetworkBridge = "none"
+	defaultNetworkMtu      = 1500
+	defaultDownloadRetries = 5
+	defaultMaxDownloads    = 3
+	defaultMaxUploads      = 5
+	DisableNetworkBridge   = "none"
 )
 
 // FIXME: separate runtime configuration from http api configuration
@@ -18,6 +21,10 @@ type Config struct {
 	AutoRestart                 bool
 	Dns                         []string
 	DnsSearch                   []string
+	Mirrors                     []string
+	InsecureRegistries          []string
+	TrustKeys                   []string
+	RequireSignature            []string
 	EnableIptables              bool
 	EnableIpForward             bool
 	DefaultIp                   net.IP
@@ -25,8 +32,16 @@ type Config struct {
 	BridgeIP                    string
 	InterContainerCommunication bool
 	GraphDriver                 string
+	GraphOptions                []string
 	ExecDriver                  string
 	Mtu                         int
+	PortRange                   string
+	DownloadRetries             int
+	LayerStore                  string
+	MaxConcurrentDownloads      int
+	MaxConcurrentUploads        int
+	EnableUserlandProxy         bool
+	NetworkPlugin               string
 	DisableNetwork              bool
 	EnableSelinuxSupport        bool
 	Context                     map[string][]string
@@ -47,20 +62,54 @@ func ConfigFromJob(job *engine.Job) *Config {
 		InterContainerCommunication: job.GetenvBool("InterContainerCommunication"),
 		GraphDriver:                 job.Getenv("GraphDriver"),
 		ExecDriver:                  job.Getenv("ExecDriver"),
+		PortRange:                   job.Getenv("PortRange"),
+		NetworkPlugin:               job.Getenv("NetworkPlugin"),
+		LayerStore:                  job.Getenv("LayerStore"),
 		EnableSelinuxSupport:        job.GetenvBool("EnableSelinuxSupport"),
 	}
+	// the userland proxy stays enabled unless explicitly disabled
+	config.EnableUserlandProxy = !job.EnvExists("EnableUserlandProxy") || job.GetenvBool("EnableUserlandProxy")
 	if dns := job.GetenvList("Dns"); dns != nil {
 		config.Dns = dns
 	}
 	if dnsSearch := job.GetenvList("DnsSearch"); dnsSearch != nil {
 		config.DnsSearch = dnsSearch
 	}
+	if mirrors := job.GetenvList("Mirrors"); mirrors != nil {
+		config.Mirrors = mirrors
+	}
+	if insecureRegistries := job.GetenvList("InsecureRegistries"); insecureRegistries != nil {
+		config.InsecureRegistries = insecureRegistries
+	}
+	if trustKeys := job.GetenvList("TrustKeys"); trustKeys != nil {
+		config.TrustKeys = trustKeys
+	}
+	if requireSignature := job.GetenvList("RequireSignature"); requireSignature != nil {
+		config.RequireSignature = requireSignature
+	}
+	if graphOpts := job.GetenvList("GraphOptions"); graphOpts != nil {
+		config.GraphOptions = graphOpts
+	}
 	if mtu := job.GetenvInt("Mtu"); mtu != 0 {
 		config.Mtu = mtu
 	} else {
 		config.Mtu = GetDefaultNetworkMtu()
 	}
 	config.DisableNetwork = config.BridgeIface == DisableNetworkBridge
+	config.DownloadRetries = defaultDownloadRetries
+	if job.EnvExists("DownloadRetries") {
+		config.DownloadRetries = job.GetenvInt("DownloadRetries")
+	}
+	if maxDownloads := job.GetenvInt("MaxConcurrentDownloads"); maxDownloads > 0 {
+		config.MaxConcurrentDownloads = maxDownloads
+	} else {
+		config.MaxConcurrentDownloads = defaultMaxDownloads
+	}
+	if maxUploads := job.GetenvInt("MaxConcurrentUploads"); maxUploads > 0 {
+		config.MaxConcurrentUploads = maxUploads
+	} else {
+		config.MaxConcurrentUploads = defaultMaxUploads
+	}
 
 	return config
 }
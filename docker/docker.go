@@ -16,6 +16,7 @@ import (
 	"github.com/dotcloud/docker/engine"
 	"github.com/dotcloud/docker/opts"
 	flag "github.com/dotcloud/docker/pkg/mflag"
+	"github.com/dotcloud/docker/registry"
 	"github.com/dotcloud/docker/sysinit"
 	"github.com/dotcloud/docker/utils"
 )
@@ -43,6 +44,11 @@ func main() {
 		flDebug     = flag.Bool([]string{"D", "-debug"}, false, "Enable debug mode")
 		flDns       = opts.NewListOpts(opts.ValidateIp4Address)
 		flDnsSearch = opts.NewListOpts(opts.ValidateDomain)
+		flMirrors   = opts.NewListOpts(registry.ValidateMirror)
+		flInsecure  = opts.NewListOpts(registry.ValidateInsecureRegistry)
+		flTrustKeys = opts.NewListOpts(nil)
+		flRequire   = opts.NewListOpts(nil)
+		flGraphOpts = opts.NewListOpts(nil)
 		flHosts     = opts.NewListOpts(api.ValidateHost)
 		flTls       = flag.Bool([]string{"-tls"}, false, "Use TLS; implied by tls-verify flags")
 		flTlsVerify = flag.Bool([]string{"-tlsverify"}, false, "Use TLS and verify the remote (daemon: verify client, client: verify daemon)")
@@ -67,11 +73,24 @@ func main() {
 	flag.Bool([]string{"#icc", "-icc"}, true, "Enable inter-container communication")
 	flag.String([]string{"s", "-storage-driver"}, "", "Force the docker runtime to use a specific storage driver")
 	flag.String([]string{"e", "-exec-driver"}, "native", "Force the docker runtime to use a specific exec driver")
+	flag.String([]string{"-migrate-storage"}, "", "Migrate the images and containers of this storage driver to the one given by -s, then quit")
 	flag.Int([]string{"#mtu", "-mtu"}, 0, "Set the containers network MTU\nif no value is provided: default to the default route MTU or 1500 if no default route is available")
+	flag.Bool([]string{"-userland-proxy"}, true, "Use a userland proxy for published ports\nif disabled: rely on iptables with hairpin NAT instead")
+	flag.String([]string{"-network-plugin"}, "", "Path to the unix socket of a network driver plugin to use in place of the bridge")
+	flag.String([]string{"-port-range"}, "", "Range of host ports to allocate dynamically for published container ports, in the form start-end\nif no value is provided: default to 49153-65535")
+	flag.Int([]string{"-download-retries"}, 5, "Number of times an interrupted layer download is resumed before the pull fails")
+	flag.String([]string{"-layer-store"}, "", "Path of a read-only store of image layers shared with other daemons, the layers it has are not downloaded by the pulls")
+	flag.Int([]string{"-max-concurrent-downloads"}, 3, "Maximum number of layers downloaded at once by the pulls")
+	flag.Int([]string{"-max-concurrent-uploads"}, 5, "Maximum number of layers uploaded at once by the pushes")
 	flag.Bool([]string{"-selinux-enabled"}, false, "Enable selinux support")
 
 	flag.Var(&flDns, []string{"#dns", "-dns"}, "Force docker to use specific DNS servers")
 	flag.Var(&flDnsSearch, []string{"-dns-search"}, "Force Docker to use specific DNS search domains")
+	flag.Var(&flMirrors, []string{"-registry-mirror"}, "Specify a preferred Docker registry mirror for pulls from the official index")
+	flag.Var(&flInsecure, []string{"-insecure-registry"}, "Allow plain HTTP and unverified HTTPS to the registries of this CIDR (e.g. 10.1.0.0/16) or host (e.g. myregistry:5000)")
+	flag.Var(&flTrustKeys, []string{"-trust-key"}, "Public key (PEM) of a signer whose images pulled from v2 registries are trusted")
+	flag.Var(&flRequire, []string{"-require-signature"}, "Refuse the images of the repositories under this namespace (e.g. myorg or myregistry:5000/team) unless signed by a trusted key")
+	flag.Var(&flGraphOpts, []string{"-storage-opt"}, "Set a storage driver option as key=value (e.g. dm.basesize=20G)")
 	flag.Var(&flHosts, []string{"H", "-host"}, "The socket(s) to bind to in daemon mode\nspecified using one or more tcp://host:port, unix:///path/to/socket, fd://* or fd://socketfd.")
 
 	flag.Parse()
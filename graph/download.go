@@ -0,0 +1,107 @@
+package graph
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	"github.com/dotcloud/docker/registry"
+	"github.com/dotcloud/docker/utils"
+)
+
+var (
+	// delay before resuming an interrupted download, doubled at each attempt
+	downloadBackoff    = 500 * time.Millisecond
+	maxDownloadBackoff = 30 * time.Second
+)
+
+// DownloadLayer downloads the layer of the image imgID into a file under the
+// graph root and returns it positioned at its start. A download interrupted
+// by an error is resumed from where it stopped, up to retries times. The
+// partial file is kept when the download fails, so that the next pull of the
+// image resumes it as well: it is up to the caller to remove the file once
+// the layer is registered.
+func (graph *Graph) DownloadLayer(r *registry.Registry, out io.Writer, imgID, endpoint string, token []string, size, retries int, sf *utils.StreamFormatter) (*os.File, error) {
+	dir := path.Join(graph.Root, "_downloads")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path.Join(dir, imgID), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	// Without the size of the layer a partial download of a previous pull
+	// can't be told from a complete one
+	if size <= 0 {
+		if err := f.Truncate(0); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := graph.downloadLayer(r, out, f, imgID, endpoint, token, size, sf)
+		if err == nil {
+			break
+		}
+		if attempt >= retries {
+			f.Close()
+			return nil, err
+		}
+		delay := downloadBackoff << uint(attempt)
+		if delay > maxDownloadBackoff {
+			delay = maxDownloadBackoff
+		}
+		utils.Errorf("Error downloading the layer of %s: %s", imgID, err)
+		out.Write(sf.FormatProgress(utils.TruncateID(imgID), fmt.Sprintf("Download interrupted, resuming in %s", delay), nil))
+		time.Sleep(delay)
+	}
+
+	if _, err := f.Seek(0, 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// downloadLayer appends the missing part of the layer to f
+func (graph *Graph) downloadLayer(r *registry.Registry, out io.Writer, f *os.File, imgID, endpoint string, token []string, size int, sf *utils.StreamFormatter) error {
+	offset, err := f.Seek(0, 2)
+	if err != nil {
+		return err
+	}
+	if size > 0 {
+		if offset == int64(size) {
+			return nil
+		} else if offset > int64(size) {
+			offset = 0
+		}
+	}
+
+	layer, start, err := r.GetRemoteImageLayer(imgID, endpoint, token, offset)
+	if err != nil {
+		return err
+	}
+	defer layer.Close()
+
+	// The registry may send the whole layer even though a part was asked
+	if err := f.Truncate(start); err != nil {
+		return err
+	}
+	if _, err := f.Seek(start, 0); err != nil {
+		return err
+	}
+
+	action := "Downloading"
+	if start > 0 {
+		action = "Resuming download"
+	}
+	total := size
+	if size > 0 {
+		total -= int(start)
+	}
+	_, err = io.Copy(f, utils.ProgressReader(layer, total, out, sf, false, utils.TruncateID(imgID), action))
+	return err
+}
@@ -0,0 +1,75 @@
+package graph
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dotcloud/docker/registry"
+	"github.com/dotcloud/docker/utils"
+)
+
+func TestDownloadLayerResume(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+	graph := store.graph
+
+	layer := strings.Repeat("0123456789", 1000)
+	var requests, ranged int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("Range") != "" {
+			ranged++
+		}
+		if requests == 1 {
+			// drop the connection in the middle of the layer
+			w.Header().Set("Content-Length", strconv.Itoa(len(layer)))
+			w.Write([]byte(layer[:len(layer)/2]))
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			conn.Close()
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(layer))
+	}))
+	defer ts.Close()
+
+	r, err := registry.NewRegistry(&registry.AuthConfig{}, utils.NewHTTPRequestFactory(), ts.URL+"/v1/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	downloadBackoff = time.Millisecond
+	f, err := graph.DownloadLayer(r, ioutil.Discard, testImageID, ts.URL+"/v1/", nil, len(layer), 1, utils.NewStreamFormatter(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if requests != 2 || ranged != 1 {
+		t.Fatalf("Expected the download to be resumed once, got %d requests, %d ranged", requests, ranged)
+	}
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != layer {
+		t.Fatalf("Expected the whole layer, got %d bytes", len(data))
+	}
+	if f.Name() != path.Join(graph.Root, "_downloads", testImageID) {
+		t.Fatalf("Unexpected download path %s", f.Name())
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -25,6 +26,11 @@ type Graph struct {
 	Root    string
 	idIndex *utils.TruncIndex
 	driver  graphdriver.Driver
+	// held by the registrations, the layer of an image
+	// is in the driver before the image is in the graph
+	registering sync.RWMutex
+	// nil without a layer store
+	layerStore *LayerStore
 }
 
 // NewGraph instantiates a new graph at the given root path in the filesystem.
@@ -158,6 +164,9 @@ func (graph *Graph) Create(layerData archive.ArchiveReader, containerID, contain
 // Register imports a pre-existing image into the graph.
 // FIXME: pass img as first argument
 func (graph *Graph) Register(jsonData []byte, layerData archive.ArchiveReader, img *image.Image) (err error) {
+	graph.registering.RLock()
+	defer graph.registering.RUnlock()
+
 	defer func() {
 		// If any error occurs, remove the new dir from the driver.
 		// Don't check for errors since the dir might not have been created.
@@ -237,6 +246,14 @@ func (graph *Graph) TempLayerArchive(id string, compression archive.Compression,
 	return archive.NewTempArchive(progress, tmp)
 }
 
+// LockRegistrations waits for the images being registered and keeps new
+// ones from being registered until unlock is called, so that every layer
+// of the driver belongs to an image of the graph or to a container.
+func (graph *Graph) LockRegistrations() (unlock func()) {
+	graph.registering.Lock()
+	return graph.registering.Unlock
+}
+
 // Mktemp creates a temporary sub-directory inside the graph's filesystem.
 func (graph *Graph) Mktemp(id string) (string, error) {
 	dir := path.Join(graph.Root, "_tmp", utils.GenerateRandomID())
@@ -414,6 +431,22 @@ func (graph *Graph) ImageRoot(id string) string {
 	return path.Join(graph.Root, id)
 }
 
+// SetBlobSum records the digest of the v2 blob the layer of id was
+// verified against when it was pulled or pushed
+func (graph *Graph) SetBlobSum(id, blobSum string) error {
+	return ioutil.WriteFile(path.Join(graph.ImageRoot(id), "blobsum"), []byte(blobSum), 0600)
+}
+
+// BlobSum returns the digest recorded by SetBlobSum for the layer of id,
+// or an empty string if its content was never verified against a blob
+func (graph *Graph) BlobSum(id string) string {
+	data, err := ioutil.ReadFile(path.Join(graph.ImageRoot(id), "blobsum"))
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
 func (graph *Graph) Driver() graphdriver.Driver {
 	return graph.driver
 }
@@ -0,0 +1,259 @@
+package graph
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"syscall"
+
+	"github.com/dotcloud/docker/archive"
+	"github.com/dotcloud/docker/image"
+	"github.com/dotcloud/docker/utils"
+)
+
+// A LayerStore is a directory of image layers shared by the daemons of a
+// host, which read it when they pull and add to it with AddToStore. Its entries are keyed by the
+// id of an image and the tarsum the registry has for the image:
+//
+//	<root>/<image id>/<tarsum>/json       the json of the image
+//	<root>/<image id>/<tarsum>/layer.tar  the uncompressed layer archive
+//	<root>/<image id>/<tarsum>/lock       locked shared by the readers
+//
+// The tools filling the store add an entry whole, by renaming a directory
+// of <root>/_tmp into place, and remove it after locking its lock file
+// exclusively, by renaming it out of the store. A reader holding the shared
+// lock of an entry thus always sees it complete, see Add and Remove.
+type LayerStore struct {
+	Root string
+}
+
+// NewLayerStore returns the layer store of the directory root
+func NewLayerStore(root string) (*LayerStore, error) {
+	fi, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("The layer store %s is not a directory", root)
+	}
+	return &LayerStore{Root: root}, nil
+}
+
+// validChecksum returns true if checksum can name an entry
+func validChecksum(checksum string) bool {
+	return checksum != "" && checksum != "." && checksum != ".." && path.Base(checksum) == checksum
+}
+
+func (s *LayerStore) entry(id, checksum string) string {
+	return path.Join(s.Root, id, checksum)
+}
+
+// Has returns true if the store has the layer of the image id of the
+// given tarsum
+func (s *LayerStore) Has(id, checksum string) bool {
+	if utils.ValidateID(id) != nil || !validChecksum(checksum) {
+		return false
+	}
+	_, err := os.Stat(path.Join(s.entry(id, checksum), "layer.tar"))
+	return err == nil
+}
+
+// A StoredLayer is an entry of a layer store open for reading, its entry
+// stays in the store until it is closed
+type StoredLayer struct {
+	JSON  []byte
+	Layer *os.File
+	lock  *os.File
+}
+
+// Open locks the entry of the image id of the given tarsum for reading
+func (s *LayerStore) Open(id, checksum string) (*StoredLayer, error) {
+	if !s.Has(id, checksum) {
+		return nil, fmt.Errorf("No such layer in the layer store: %s", id)
+	}
+	dir := s.entry(id, checksum)
+	lock, err := os.Open(path.Join(dir, "lock"))
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_SH); err != nil {
+		lock.Close()
+		return nil, err
+	}
+
+	// The entry may have been removed while waiting for the lock
+	stored := &StoredLayer{lock: lock}
+	if stored.JSON, err = ioutil.ReadFile(path.Join(dir, "json")); err == nil {
+		stored.Layer, err = os.Open(path.Join(dir, "layer.tar"))
+	}
+	if err != nil {
+		stored.Close()
+		return nil, err
+	}
+	return stored, nil
+}
+
+// Close releases the entry
+func (stored *StoredLayer) Close() error {
+	if stored.Layer != nil {
+		stored.Layer.Close()
+	}
+	return stored.lock.Close()
+}
+
+// Add adds the layer of the image id of the given tarsum to the store,
+// for the tools filling it. The entry of another writer is kept.
+func (s *LayerStore) Add(id, checksum string, jsonData []byte, layer io.Reader) error {
+	if err := utils.ValidateID(id); err != nil {
+		return err
+	}
+	if !validChecksum(checksum) {
+		return fmt.Errorf("Invalid layer checksum %q", checksum)
+	}
+	tmp, err := s.mktemp()
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	if err := ioutil.WriteFile(path.Join(tmp, "json"), jsonData, 0644); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path.Join(tmp, "layer.tar"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, layer)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path.Join(tmp, "lock"), nil, 0644); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(path.Join(s.Root, id), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.entry(id, checksum)); err != nil && !isNotEmpty(err) && !os.IsExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Remove removes the layer of the image id of the given tarsum from the
+// store, once its readers are done with it
+func (s *LayerStore) Remove(id, checksum string) error {
+	dir := s.entry(id, checksum)
+	lock, err := os.Open(path.Join(dir, "lock"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	tmp, err := s.mktemp()
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	return os.Rename(dir, path.Join(tmp, "entry"))
+}
+
+func (s *LayerStore) mktemp() (string, error) {
+	dir := path.Join(s.Root, "_tmp")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return ioutil.TempDir(dir, "")
+}
+
+// SetLayerStore sets the store the layers are copied from rather than
+// downloaded, when it has them
+func (graph *Graph) SetLayerStore(s *LayerStore) {
+	graph.layerStore = s
+}
+
+// HasStoredLayer returns true if the layer store of the graph has the
+// layer of the image id of the given tarsum
+func (graph *Graph) HasStoredLayer(id, checksum string) bool {
+	return graph.layerStore != nil && graph.layerStore.Has(id, checksum)
+}
+
+// RegisterFromStore registers the image id with its layer of the layer
+// store, once the tarsum of the layer is verified
+func (graph *Graph) RegisterFromStore(id, checksum string) error {
+	if graph.layerStore == nil {
+		return fmt.Errorf("No layer store")
+	}
+	stored, err := graph.layerStore.Open(id, checksum)
+	if err != nil {
+		return err
+	}
+	defer stored.Close()
+
+	img, err := image.NewImgJSON(stored.JSON)
+	if err != nil {
+		return err
+	}
+	if img.ID != id {
+		return fmt.Errorf("The layer store has the image %s in place of %s", img.ID, id)
+	}
+
+	tarSum := &utils.TarSum{Reader: stored.Layer, DisableCompression: true}
+	if _, err := io.Copy(ioutil.Discard, tarSum); err != nil {
+		return err
+	}
+	if sum := tarSum.Sum(stored.JSON); sum != checksum {
+		return fmt.Errorf("The layer of %s in the layer store has the checksum %s, expected %s", id, sum, checksum)
+	}
+	if _, err := stored.Layer.Seek(0, 0); err != nil {
+		return err
+	}
+	return graph.Register(stored.JSON, stored.Layer, img)
+}
+
+// AddToStore adds the layer of the image id to the layer store s, keyed by
+// the tarsum a push computes for it, and returns the tarsum
+func (graph *Graph) AddToStore(s *LayerStore, id string) (string, error) {
+	img, err := graph.Get(id)
+	if err != nil {
+		return "", err
+	}
+	jsonData, err := ioutil.ReadFile(path.Join(graph.Root, img.ID, "json"))
+	if err != nil {
+		return "", err
+	}
+	layer, err := img.TarLayer()
+	if err != nil {
+		return "", err
+	}
+	defer layer.Close()
+
+	// The entry is named after the tarsum of the whole layer
+	tmp, err := graph.Mktemp("")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+	tarSum := &utils.TarSum{Reader: layer, DisableCompression: true}
+	layerData, err := archive.NewTempArchive(ioutil.NopCloser(tarSum), tmp)
+	if err != nil {
+		return "", err
+	}
+	defer layerData.Close()
+
+	checksum := tarSum.Sum(jsonData)
+	if err := s.Add(img.ID, checksum, jsonData, layerData); err != nil {
+		return "", err
+	}
+	return checksum, nil
+}
@@ -0,0 +1,200 @@
+package graph
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/dotcloud/docker/image"
+	"github.com/dotcloud/docker/utils"
+	"github.com/dotcloud/docker/vendor/src/code.google.com/p/go/src/pkg/archive/tar"
+)
+
+const (
+	storedImageID = "2ce2e90b0bc7224de3db1f0d646fe8e2c4dd37f1793928287f6074bc451a57ea"
+	storedContent = "Hello layer store!\n"
+)
+
+// storedLayer returns the json and the layer of an image child of the test
+// image, with their tarsum
+func storedLayer(t *testing.T) ([]byte, []byte, string) {
+	jsonData := []byte(`{"id":"` + storedImageID + `","parent":"` + testImageID + `"}`)
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	if err := tw.WriteHeader(&tar.Header{Name: "/etc/stored", Size: int64(len(storedContent))}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Write([]byte(storedContent))
+	tw.Close()
+	layerData := buf.Bytes()
+
+	tarSum := &utils.TarSum{Reader: bytes.NewReader(layerData), DisableCompression: true}
+	if _, err := io.Copy(ioutil.Discard, tarSum); err != nil {
+		t.Fatal(err)
+	}
+	return jsonData, layerData, tarSum.Sum(jsonData)
+}
+
+func TestRegisterFromStore(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(path.Join(tmp, "graph"), t)
+	defer store.graph.driver.Cleanup()
+	graph := store.graph
+
+	if err := os.MkdirAll(path.Join(tmp, "layers"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	layerStore, err := NewLayerStore(path.Join(tmp, "layers"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	graph.SetLayerStore(layerStore)
+
+	jsonData, layerData, checksum := storedLayer(t)
+	if graph.HasStoredLayer(storedImageID, checksum) {
+		t.Fatal("Expected an empty layer store")
+	}
+	if err := layerStore.Add(storedImageID, checksum, jsonData, bytes.NewReader(layerData)); err != nil {
+		t.Fatal(err)
+	}
+	// The entry of another writer is kept
+	if err := layerStore.Add(storedImageID, checksum, jsonData, bytes.NewReader(layerData)); err != nil {
+		t.Fatal(err)
+	}
+	if !graph.HasStoredLayer(storedImageID, checksum) {
+		t.Fatal("Expected the layer to be in the layer store")
+	}
+	if graph.HasStoredLayer(storedImageID, "tarsum+sha256:0000") {
+		t.Fatal("Expected the layer of another checksum not to be in the layer store")
+	}
+
+	// The wrong checksum of an entry is refused
+	if err := layerStore.Add(storedImageID, "tarsum+sha256:0000", jsonData, bytes.NewReader(layerData)); err != nil {
+		t.Fatal(err)
+	}
+	if err := graph.RegisterFromStore(storedImageID, "tarsum+sha256:0000"); err == nil {
+		t.Fatal("Expected a layer of the wrong checksum to be refused")
+	}
+	if graph.Exists(storedImageID) {
+		t.Fatal("Expected a layer of the wrong checksum not to be registered")
+	}
+
+	if err := graph.RegisterFromStore(storedImageID, checksum); err != nil {
+		t.Fatal(err)
+	}
+	img, err := graph.Get(storedImageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Parent != testImageID || img.Size != int64(len(storedContent)) {
+		t.Fatalf("Expected the image to be registered with its layer, got %+v", img)
+	}
+}
+
+func TestLayerStoreRemoveWaitsForReaders(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	if err := os.MkdirAll(tmp, 0755); err != nil {
+		t.Fatal(err)
+	}
+	layerStore, err := NewLayerStore(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonData, layerData, checksum := storedLayer(t)
+	if err := layerStore.Add(storedImageID, checksum, jsonData, bytes.NewReader(layerData)); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := layerStore.Open(storedImageID, checksum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed := make(chan error)
+	go func() {
+		removed <- layerStore.Remove(storedImageID, checksum)
+	}()
+	select {
+	case err := <-removed:
+		t.Fatalf("Expected the entry to be kept while read, got %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if data, err := ioutil.ReadAll(stored.Layer); err != nil || !bytes.Equal(data, layerData) {
+		t.Fatalf("Expected the layer to be readable, got %v", err)
+	}
+	stored.Close()
+
+	if err := <-removed; err != nil {
+		t.Fatal(err)
+	}
+	if layerStore.Has(storedImageID, checksum) {
+		t.Fatal("Expected the entry to be removed")
+	}
+	if _, err := layerStore.Open(storedImageID, checksum); err == nil {
+		t.Fatal("Expected a removed entry not to open")
+	}
+}
+
+func TestAddToStore(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(path.Join(tmp, "graph"), t)
+	defer store.graph.driver.Cleanup()
+	graph := store.graph
+
+	if err := os.MkdirAll(path.Join(tmp, "layers"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	layerStore, err := NewLayerStore(path.Join(tmp, "layers"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	graph.SetLayerStore(layerStore)
+
+	jsonData, layerData, _ := storedLayer(t)
+	img, err := image.NewImgJSON(jsonData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := graph.Register(jsonData, bytes.NewReader(layerData), img); err != nil {
+		t.Fatal(err)
+	}
+
+	checksum, err := graph.AddToStore(layerStore, storedImageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !graph.HasStoredLayer(storedImageID, checksum) {
+		t.Fatal("Expected the layer to be in the layer store")
+	}
+
+	// The graph of another daemon registers the image from the entry
+	other := mkTestTagStore(path.Join(tmp, "other"), t).graph
+	defer other.driver.Cleanup()
+	other.SetLayerStore(layerStore)
+	if err := other.RegisterFromStore(storedImageID, checksum); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := other.driver.Get(storedImageID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.driver.Put(storedImageID)
+	if data, err := ioutil.ReadFile(path.Join(dir, "etc", "stored")); err != nil || string(data) != storedContent {
+		t.Fatalf("Expected the layer to be registered, got %q (%v)", data, err)
+	}
+}
@@ -0,0 +1,165 @@
+package graph
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/dotcloud/docker/archive"
+	"github.com/dotcloud/docker/image"
+	"github.com/dotcloud/docker/registry"
+	"github.com/dotcloud/docker/trust"
+	"github.com/dotcloud/docker/utils"
+)
+
+// PullV2Repository pulls the tags of a repository, or only askedTag which
+// may also be a digest, from a registry speaking the v2 protocol
+func (store *TagStore) PullV2Repository(r *registry.Registry, out io.Writer, localName, remoteName, askedTag, endpoint string, sf *utils.StreamFormatter) error {
+	out.Write(sf.FormatStatus("", "Pulling repository %s", localName))
+
+	var tags []string
+	if askedTag == "" {
+		var err error
+		utils.Debugf("Retrieving the tag list")
+		if tags, err = r.GetV2Tags(endpoint, remoteName); err != nil {
+			return err
+		}
+	} else {
+		tags = []string{askedTag}
+	}
+
+	for _, tag := range tags {
+		if err := store.pullV2Tag(r, out, localName, remoteName, tag, endpoint, sf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (store *TagStore) pullV2Tag(r *registry.Registry, out io.Writer, localName, remoteName, tag, endpoint string, sf *utils.StreamFormatter) error {
+	manifest, digest, err := r.GetV2Manifest(endpoint, remoteName, tag)
+	if err != nil {
+		return err
+	}
+	verification, err := store.verify(localName, manifest)
+	if err != nil {
+		return err
+	}
+
+	// The manifest lists the top-most layer first, register from the base
+	var imgID string
+	for i := len(manifest.FSLayers) - 1; i >= 0; i-- {
+		imgJSON := []byte(manifest.History[i].V1Compatibility)
+		img, err := image.NewImgJSON(imgJSON)
+		if err != nil {
+			return fmt.Errorf("Failed to parse json: %s", err)
+		}
+		if img.Parent != imgID {
+			return fmt.Errorf("Invalid manifest for %s:%s, the parent of %s is %s, not %s", remoteName, tag, utils.TruncateID(img.ID), img.Parent, imgID)
+		}
+		verified, err := store.pullV2Layer(r, out, remoteName, manifest.FSLayers[i].BlobSum, endpoint, imgJSON, img, sf)
+		if err != nil {
+			return err
+		}
+		// The signatures only vouch for a local layer whose content
+		// was checked against the digest of the manifest
+		if !verified && verification.Trusted {
+			if store.trust.RequiresTrust(localName) {
+				return fmt.Errorf("The local layer %s of %s:%s was not verified against the digest %s, remove the image to pull it again", utils.TruncateID(img.ID), localName, tag, manifest.FSLayers[i].BlobSum)
+			}
+			verification.Trusted = false
+		}
+		imgID = img.ID
+	}
+	out.Write(sf.FormatStatus("", "%s: %s", tag, verification))
+
+	if !registry.IsDigest(tag) {
+		if err := store.Set(localName, tag, imgID, true); err != nil {
+			return err
+		}
+	}
+	if err := store.SetDigest(localName, digest, imgID); err != nil {
+		return err
+	}
+	if err := store.SetVerification(imgID, verification); err != nil {
+		return err
+	}
+	out.Write(sf.FormatStatus("", "Digest: %s", digest))
+	return nil
+}
+
+// verify checks the signatures of a manifest of the repository localName,
+// the trust policy of the daemon may refuse the image
+func (store *TagStore) verify(localName string, manifest *registry.ManifestData) (*trust.Verification, error) {
+	if store.trust == nil {
+		v := &trust.Verification{}
+		if len(manifest.Signatures) > 0 {
+			v.Signed, v.KeyID = true, manifest.Signatures[0].KeyID
+		}
+		return v, nil
+	}
+	payload, err := manifest.Payload()
+	if err != nil {
+		return nil, err
+	}
+	v, err := store.trust.Verify(payload, manifest.Signatures)
+	if err != nil {
+		return nil, err
+	}
+	if !v.Trusted && store.trust.RequiresTrust(localName) {
+		return nil, fmt.Errorf("The images of %s must be signed by a trusted key, this one is %s", localName, v)
+	}
+	return v, nil
+}
+
+// pullV2Layer registers the layer of img, verifying its content against
+// blobSum. A layer which already exists is kept, verified is false if its
+// content was never checked against blobSum.
+func (store *TagStore) pullV2Layer(r *registry.Registry, out io.Writer, remoteName, blobSum, endpoint string, imgJSON []byte, img *image.Image, sf *utils.StreamFormatter) (verified bool, err error) {
+	if store.graph.Exists(img.ID) {
+		out.Write(sf.FormatProgress(utils.TruncateID(img.ID), "Already exists", nil))
+		return store.graph.BlobSum(img.ID) == blobSum, nil
+	}
+	if !registry.IsDigest(blobSum) {
+		return false, fmt.Errorf("Invalid digest %s for layer %s", blobSum, utils.TruncateID(img.ID))
+	}
+
+	out.Write(sf.FormatProgress(utils.TruncateID(img.ID), "Pulling fs layer", nil))
+	layer, size, err := r.GetV2Blob(endpoint, remoteName, blobSum)
+	if err != nil {
+		out.Write(sf.FormatProgress(utils.TruncateID(img.ID), "Error pulling dependent layers", nil))
+		return false, err
+	}
+	defer layer.Close()
+
+	// The blob is only registered once its digest is verified, until then
+	// it is kept in a temporary file
+	tmp, err := store.graph.Mktemp("")
+	if err != nil {
+		return false, err
+	}
+	defer os.RemoveAll(tmp)
+
+	verifier := &utils.CheckSum{Reader: layer, Hash: sha256.New()}
+	layerData, err := archive.NewTempArchive(utils.ProgressReader(ioutil.NopCloser(verifier), size, out, sf, false, utils.TruncateID(img.ID), "Downloading"), tmp)
+	if err != nil {
+		out.Write(sf.FormatProgress(utils.TruncateID(img.ID), "Error downloading dependent layers", nil))
+		return false, err
+	}
+	defer layerData.Close()
+	if sum := "sha256:" + verifier.Sum(); sum != blobSum {
+		return false, fmt.Errorf("Layer %s failed verification, expected digest %s got %s", utils.TruncateID(img.ID), blobSum, sum)
+	}
+
+	if err := store.graph.Register(imgJSON, layerData, img); err != nil {
+		out.Write(sf.FormatProgress(utils.TruncateID(img.ID), "Error downloading dependent layers", nil))
+		return false, err
+	}
+	if err := store.graph.SetBlobSum(img.ID, blobSum); err != nil {
+		return false, err
+	}
+	out.Write(sf.FormatProgress(utils.TruncateID(img.ID), "Download complete", nil))
+	return true, nil
+}
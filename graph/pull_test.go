@@ -0,0 +1,206 @@
+package graph
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/dotcloud/docker/image"
+	"github.com/dotcloud/docker/registry"
+	"github.com/dotcloud/docker/trust"
+	"github.com/dotcloud/docker/utils"
+)
+
+func TestVerifyManifest(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+
+	signer, err := trust.NewStore(path.Join(tmp, "signer", "key.pem"), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trustStore, err := trust.NewStore(path.Join(tmp, "trust", "key.pem"), []string{path.Join(tmp, "signer", "key.pem.pub")}, []string{"myorg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SetTrustStore(trustStore)
+
+	manifest := &registry.ManifestData{
+		SchemaVersion: 1,
+		Name:          "myorg/app",
+		Tag:           "latest",
+		FSLayers:      []*registry.FSLayer{{BlobSum: "sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"}},
+		History:       []*registry.ManifestHistory{{V1Compatibility: `{"id":"foo"}`}},
+	}
+	if _, err := store.verify("myorg/app", manifest); err == nil {
+		t.Fatal("Expected an unsigned image of myorg to be refused")
+	}
+	if v, err := store.verify("other/app", manifest); err != nil || v.Signed {
+		t.Fatalf("Expected an unsigned image of other to be accepted, got %v, %v", v, err)
+	}
+
+	payload, err := manifest.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest.Signatures = []*trust.Signature{sig}
+	v, err := store.verify("myorg/app", manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.Trusted {
+		t.Fatalf("Expected the image to be trusted, got %s", v)
+	}
+
+	manifest.Tag = "evil"
+	if _, err := store.verify("other/app", manifest); err == nil {
+		t.Fatal("Expected an altered manifest to be refused")
+	}
+}
+
+func TestPullV2LayerVerifiesBeforeRegistering(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+
+	jsonData, layerData, _ := storedLayer(t)
+	hash := sha256.Sum256(layerData)
+	blobSum := "sha256:" + hex.EncodeToString(hash[:])
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(layerData)
+	}))
+	defer ts.Close()
+	r, err := registry.NewRegistry(&registry.AuthConfig{}, registry.HTTPRequestFactory(nil), ts.URL+"/v1/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := image.NewImgJSON(jsonData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf := utils.NewStreamFormatter(false)
+
+	wrongSum := "sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+	if _, err := store.pullV2Layer(r, ioutil.Discard, "foo", wrongSum, ts.URL+"/v1/", jsonData, img, sf); err == nil {
+		t.Fatal("Expected a layer of the wrong digest to be refused")
+	}
+	if store.graph.Exists(storedImageID) {
+		t.Fatal("Expected a layer of the wrong digest not to be registered")
+	}
+
+	if verified, err := store.pullV2Layer(r, ioutil.Discard, "foo", blobSum, ts.URL+"/v1/", jsonData, img, sf); err != nil || !verified {
+		t.Fatalf("Expected the layer to be verified, got %v, %v", verified, err)
+	}
+	if !store.graph.Exists(storedImageID) {
+		t.Fatal("Expected the layer to be registered")
+	}
+	if data, err := ioutil.ReadDir(path.Join(store.graph.Root, "_tmp")); err == nil && len(data) != 0 {
+		t.Fatalf("Expected the temporary files to be removed, got %d", len(data))
+	}
+}
+
+func TestPullV2TagVerifiesExistingLayers(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+
+	signer, err := trust.NewStore(path.Join(tmp, "signer", "key.pem"), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trustStore, err := trust.NewStore(path.Join(tmp, "trust", "key.pem"), []string{path.Join(tmp, "signer", "key.pem.pub")}, []string{"myorg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SetTrustStore(trustStore)
+
+	// The base layer already exists, it was not pulled from a v2 registry
+	baseJSON, err := ioutil.ReadFile(path.Join(store.graph.Root, testImageID, "json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseSum := "sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+	jsonData, layerData, _ := storedLayer(t)
+	hash := sha256.Sum256(layerData)
+	blobSum := "sha256:" + hex.EncodeToString(hash[:])
+	manifest := &registry.ManifestData{
+		SchemaVersion: 1,
+		Name:          "myorg/app",
+		Tag:           "latest",
+		FSLayers:      []*registry.FSLayer{{BlobSum: blobSum}, {BlobSum: baseSum}},
+		History:       []*registry.ManifestHistory{{V1Compatibility: string(jsonData)}, {V1Compatibility: string(baseJSON)}},
+	}
+	payload, err := manifest.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest.Signatures = []*trust.Signature{sig}
+	manifestData, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.Contains(req.URL.Path, "/manifests/") {
+			w.Write(manifestData)
+			return
+		}
+		w.Write(layerData)
+	}))
+	defer ts.Close()
+	r, err := registry.NewRegistry(&registry.AuthConfig{}, registry.HTTPRequestFactory(nil), ts.URL+"/v1/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf := utils.NewStreamFormatter(false)
+
+	if err := store.pullV2Tag(r, ioutil.Discard, "myorg/app", "myorg/app", "latest", ts.URL+"/v1/", sf); err == nil {
+		t.Fatal("Expected an unverified local layer to be refused for a repository requiring trust")
+	}
+	if err := store.pullV2Tag(r, ioutil.Discard, "other/app", "myorg/app", "latest", ts.URL+"/v1/", sf); err != nil {
+		t.Fatal(err)
+	}
+	if v := store.Verification(storedImageID); v == nil || v.Trusted {
+		t.Fatalf("Expected an image with an unverified layer not to be trusted, got %v", v)
+	}
+	if sum := store.graph.BlobSum(storedImageID); sum != blobSum {
+		t.Fatalf("Expected the digest %s to be recorded for the pulled layer, got %s", blobSum, sum)
+	}
+
+	if err := store.graph.SetBlobSum(testImageID, baseSum); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.pullV2Tag(r, ioutil.Discard, "myorg/app", "myorg/app", "latest", ts.URL+"/v1/", sf); err != nil {
+		t.Fatal(err)
+	}
+	if v := store.Verification(storedImageID); v == nil || !v.Trusted {
+		t.Fatalf("Expected the verified image to be trusted, got %v", v)
+	}
+}
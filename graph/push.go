@@ -0,0 +1,148 @@
+package graph
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/dotcloud/docker/archive"
+	"github.com/dotcloud/docker/registry"
+	"github.com/dotcloud/docker/utils"
+)
+
+// PushV2Repository pushes the tags of the repository localName, or only tag,
+// as manifests to a registry speaking the v2 protocol
+func (store *TagStore) PushV2Repository(r *registry.Registry, out io.Writer, localName, remoteName, tag, endpoint string, sf *utils.StreamFormatter) error {
+	out = utils.NewWriteFlusher(out)
+	localRepo, err := store.Get(localName)
+	if err != nil {
+		return err
+	} else if localRepo == nil {
+		return fmt.Errorf("No such repository: %s", localName)
+	}
+
+	var tags []string
+	if tag != "" {
+		if _, exists := localRepo[tag]; !exists {
+			return fmt.Errorf("Tag %s does not exist for %s", tag, localName)
+		}
+		tags = []string{tag}
+	} else {
+		for t := range localRepo {
+			tags = append(tags, t)
+		}
+		sort.Strings(tags)
+	}
+
+	out.Write(sf.FormatStatus("", "Pushing repository %s (%d tags)", localName, len(tags)))
+	// layers shared by several tags are only pushed once
+	blobSums := make(map[string]string)
+	for _, t := range tags {
+		if err := store.pushV2Tag(r, out, localName, remoteName, t, localRepo[t], endpoint, blobSums, sf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (store *TagStore) pushV2Tag(r *registry.Registry, out io.Writer, localName, remoteName, tag, imgID, endpoint string, blobSums map[string]string, sf *utils.StreamFormatter) error {
+	manifest := &registry.ManifestData{
+		SchemaVersion: 1,
+		Name:          remoteName,
+		Tag:           tag,
+	}
+	for img, err := store.graph.Get(imgID); img != nil; img, err = img.GetParent() {
+		if err != nil {
+			return err
+		}
+		if manifest.Architecture == "" {
+			manifest.Architecture = img.Architecture
+		}
+		jsonRaw, err := ioutil.ReadFile(path.Join(store.graph.Root, img.ID, "json"))
+		if err != nil {
+			return fmt.Errorf("Cannot retrieve the path for {%s}: %s", img.ID, err)
+		}
+		blobSum, exists := blobSums[img.ID]
+		if !exists {
+			if blobSum, err = store.pushV2Layer(r, out, remoteName, img.ID, endpoint, sf); err != nil {
+				return err
+			}
+			blobSums[img.ID] = blobSum
+		}
+		manifest.FSLayers = append(manifest.FSLayers, &registry.FSLayer{BlobSum: blobSum})
+		manifest.History = append(manifest.History, &registry.ManifestHistory{V1Compatibility: string(jsonRaw)})
+	}
+
+	if store.trust != nil {
+		payload, err := manifest.Payload()
+		if err != nil {
+			return err
+		}
+		sig, err := store.trust.Sign(payload)
+		if err != nil {
+			return err
+		}
+		manifest.Signatures = append(manifest.Signatures, sig)
+		out.Write(sf.FormatStatus("", "%s: signed with key %s", tag, utils.TruncateID(sig.KeyID)))
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "   ")
+	if err != nil {
+		return err
+	}
+	out.Write(sf.FormatStatus("", "Pushing tag for rev [%s] on {%s}", utils.TruncateID(imgID), remoteName+":"+tag))
+	digest, err := r.PutV2Manifest(endpoint, remoteName, tag, data)
+	if err != nil {
+		return err
+	}
+	if err := store.SetDigest(localName, digest, imgID); err != nil {
+		return err
+	}
+	out.Write(sf.FormatStatus("", "%s: digest: %s", tag, digest))
+	return nil
+}
+
+// pushV2Layer uploads the layer of the image imgID unless the registry
+// already has it, and returns its digest
+func (store *TagStore) pushV2Layer(r *registry.Registry, out io.Writer, remoteName, imgID, endpoint string, sf *utils.StreamFormatter) (string, error) {
+	layerData, err := store.graph.TempLayerArchive(imgID, archive.Uncompressed, sf, out)
+	if err != nil {
+		return "", fmt.Errorf("Failed to generate layer archive: %s", err)
+	}
+	defer os.RemoveAll(layerData.Name())
+
+	// Hash the file itself, reading the archive to the end removes it
+	h := sha256.New()
+	if _, err := io.Copy(h, layerData.File); err != nil {
+		return "", err
+	}
+	if _, err := layerData.Seek(0, 0); err != nil {
+		return "", err
+	}
+	blobSum := "sha256:" + hex.EncodeToString(h.Sum(nil))
+	if err := store.graph.SetBlobSum(imgID, blobSum); err != nil {
+		return "", err
+	}
+
+	exists, err := r.HeadV2Blob(endpoint, remoteName, blobSum)
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		out.Write(sf.FormatProgress(utils.TruncateID(imgID), "Image already pushed, skipping", nil))
+		return blobSum, nil
+	}
+
+	out.Write(sf.FormatProgress(utils.TruncateID(imgID), "Pushing", nil))
+	if err := r.PutV2Blob(endpoint, remoteName, blobSum, utils.ProgressReader(layerData, int(layerData.Size), out, sf, false, utils.TruncateID(imgID), "Pushing")); err != nil {
+		return "", err
+	}
+	out.Write(sf.FormatProgress(utils.TruncateID(imgID), "Image successfully pushed", nil))
+	return blobSum, nil
+}
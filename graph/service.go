@@ -7,6 +7,7 @@ import (
 
 	"github.com/dotcloud/docker/engine"
 	"github.com/dotcloud/docker/image"
+	"github.com/dotcloud/docker/trust"
 	"github.com/dotcloud/docker/utils"
 )
 
@@ -135,8 +136,11 @@ func (s *TagStore) CmdLookup(job *engine.Job) engine.Status {
 		return job.Errorf("usage: %s NAME", job.Name)
 	}
 	name := job.Args[0]
-	if image, err := s.LookupImage(name); err == nil && image != nil {
-		b, err := json.Marshal(image)
+	if img, err := s.LookupImage(name); err == nil && img != nil {
+		b, err := json.Marshal(&struct {
+			*image.Image
+			Trust *trust.Verification `json:",omitempty"`
+		}{img, s.Verification(img.ID)})
 		if err != nil {
 			return job.Error(err)
 		}
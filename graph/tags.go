@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/dotcloud/docker/image"
+	"github.com/dotcloud/docker/trust"
 	"github.com/dotcloud/docker/utils"
 	"io/ioutil"
 	"os"
@@ -18,6 +19,13 @@ type TagStore struct {
 	path         string
 	graph        *Graph
 	Repositories map[string]Repository
+	// Digests maps the manifest digests of the images pulled from
+	// or pushed to a v2 registry to their image ids, by repository
+	Digests map[string]Repository `json:",omitempty"`
+	// Verifications holds the result of the verification of the
+	// signatures of the images pulled from a v2 registry, by image id
+	Verifications map[string]*trust.Verification `json:",omitempty"`
+	trust         *trust.Store
 }
 
 type Repository map[string]string
@@ -28,9 +36,11 @@ func NewTagStore(path string, graph *Graph) (*TagStore, error) {
 		return nil, err
 	}
 	store := &TagStore{
-		path:         abspath,
-		graph:        graph,
-		Repositories: make(map[string]Repository),
+		path:          abspath,
+		graph:         graph,
+		Repositories:  make(map[string]Repository),
+		Digests:       make(map[string]Repository),
+		Verifications: make(map[string]*trust.Verification),
 	}
 	// Load the json file if it exists, otherwise create it.
 	if err := store.Reload(); os.IsNotExist(err) {
@@ -63,6 +73,12 @@ func (store *TagStore) Reload() error {
 	if err := json.Unmarshal(jsonData, store); err != nil {
 		return err
 	}
+	if store.Digests == nil {
+		store.Digests = make(map[string]Repository)
+	}
+	if store.Verifications == nil {
+		store.Verifications = make(map[string]*trust.Verification)
+	}
 	return nil
 }
 
@@ -110,6 +126,9 @@ func (store *TagStore) ImageName(id string) string {
 }
 
 func (store *TagStore) DeleteAll(id string) error {
+	if err := store.deleteReferences(id); err != nil {
+		return err
+	}
 	names, exists := store.ByID()[id]
 	if !exists || len(names) == 0 {
 		return nil
@@ -147,6 +166,7 @@ func (store *TagStore) Delete(repoName, tag string) (bool, error) {
 			}
 		} else {
 			delete(store.Repositories, repoName)
+			delete(store.Digests, repoName)
 			deleted = true
 		}
 	} else {
@@ -186,6 +206,76 @@ func (store *TagStore) Set(repoName, tag, imageName string, force bool) error {
 	return store.Save()
 }
 
+// SetDigest records that the manifest with the given digest
+// in repoName refers to imageName
+func (store *TagStore) SetDigest(repoName, digest, imageName string) error {
+	img, err := store.LookupImage(imageName)
+	if err != nil {
+		return err
+	}
+	if err := validateRepoName(repoName); err != nil {
+		return err
+	}
+	if err := store.Reload(); err != nil {
+		return err
+	}
+	repo, exists := store.Digests[repoName]
+	if !exists {
+		repo = make(map[string]string)
+		store.Digests[repoName] = repo
+	}
+	repo[digest] = img.ID
+	return store.Save()
+}
+
+// deleteReferences forgets the digests referring to the image id
+// and the verification of its signatures
+func (store *TagStore) deleteReferences(id string) error {
+	if err := store.Reload(); err != nil {
+		return err
+	}
+	delete(store.Verifications, id)
+	for repoName, repo := range store.Digests {
+		for digest, revision := range repo {
+			if revision == id {
+				delete(repo, digest)
+			}
+		}
+		if len(repo) == 0 {
+			delete(store.Digests, repoName)
+		}
+	}
+	return store.Save()
+}
+
+// SetTrustStore sets the store signing the images pushed to, and verifying
+// the images pulled from, v2 registries
+func (store *TagStore) SetTrustStore(t *trust.Store) {
+	store.trust = t
+}
+
+// RequiresTrust returns true if the images of the repository
+// must be signed by a trusted key
+func (store *TagStore) RequiresTrust(repoName string) bool {
+	return store.trust != nil && store.trust.RequiresTrust(repoName)
+}
+
+// SetVerification records the result of the verification
+// of the signatures of the image id
+func (store *TagStore) SetVerification(id string, v *trust.Verification) error {
+	if err := store.Reload(); err != nil {
+		return err
+	}
+	store.Verifications[id] = v
+	return store.Save()
+}
+
+// Verification returns the result of the verification of the signatures
+// of the image id, or nil if it was not pulled from a v2 registry
+func (store *TagStore) Verification(id string) *trust.Verification {
+	return store.Verifications[id]
+}
+
 func (store *TagStore) Get(repoName string) (Repository, error) {
 	if err := store.Reload(); err != nil {
 		return nil, err
@@ -197,6 +287,15 @@ func (store *TagStore) Get(repoName string) (Repository, error) {
 }
 
 func (store *TagStore) GetImage(repoName, tagOrID string) (*image.Image, error) {
+	if strings.Contains(tagOrID, ":") {
+		if err := store.Reload(); err != nil {
+			return nil, err
+		}
+		if revision, exists := store.Digests[repoName][tagOrID]; exists {
+			return store.graph.Get(revision)
+		}
+		return nil, nil
+	}
 	repo, err := store.Get(repoName)
 	if err != nil {
 		return nil, err
@@ -36,7 +36,7 @@ func fakeTar() (io.Reader, error) {
 }
 
 func mkTestTagStore(root string, t *testing.T) *TagStore {
-	driver, err := graphdriver.New(root)
+	driver, err := graphdriver.New(root, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -108,3 +108,30 @@ func TestLookupImage(t *testing.T) {
 		t.Errorf("Expected 1 image, none found")
 	}
 }
+
+func TestLookupImageByDigest(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+
+	digest := "sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+	if err := store.SetDigest(testImageName, digest, testImageID); err != nil {
+		t.Fatal(err)
+	}
+	if img, err := store.LookupImage(testImageName + "@" + digest); err != nil {
+		t.Fatal(err)
+	} else if img == nil || img.ID != testImageID {
+		t.Errorf("Expected image %s, got %v", testImageID, img)
+	}
+
+	if err := store.DeleteAll(testImageID); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.Digests) != 0 {
+		t.Errorf("Expected no digests left, got %v", store.Digests)
+	}
+}
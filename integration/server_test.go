@@ -2,6 +2,7 @@ package docker
 
 import (
 	"bytes"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -586,3 +587,147 @@ func TestDeleteTagWithExistingContainers(t *testing.T) {
 		t.Fatalf("Expected %s got %s", unitTestImageID, untag)
 	}
 }
+
+func TestPrune(t *testing.T) {
+	eng := NewTestEngine(t)
+	daemon := mkDaemonFromEngine(eng, t)
+	defer daemon.Nuke()
+
+	config, hostConfig, _, err := runconfig.Parse([]string{"-v", "/data", unitTestImageID, "echo", "test"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	containerID := createTestContainer(eng, config, t)
+	job := eng.Job("start", containerID)
+	if err := job.ImportEnv(hostConfig); err != nil {
+		t.Fatal(err)
+	}
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := eng.Job("wait", containerID).Run(); err != nil {
+		t.Fatal(err)
+	}
+	volumeID := filepath.Base(daemon.Get(containerID).Volumes["/data"])
+
+	// An untagged image
+	job = eng.Job("commit", containerID)
+	var outputBuffer = bytes.NewBuffer(nil)
+	job.Stdout.Add(outputBuffer)
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	imageID := engine.Tail(outputBuffer, 1)
+
+	prune := func(dryRun bool, until string) map[string]string {
+		job := eng.Job("prune")
+		job.SetenvBool("DryRun", dryRun)
+		job.Setenv("Until", until)
+		outs, err := job.Stdout.AddListTable()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := job.Run(); err != nil {
+			t.Fatal(err)
+		}
+		pruned := make(map[string]string)
+		for _, out := range outs.Data {
+			pruned[out.Get("ID")] = out.Get("Type")
+		}
+		return pruned
+	}
+
+	pruned := prune(true, "0")
+	if pruned[containerID] != "container" || pruned[imageID] != "image" {
+		t.Fatalf("Expected the container and the image to be prunable, got %v", pruned)
+	}
+	if _, exists := pruned[unitTestImageID]; exists {
+		t.Fatalf("Expected the tagged image to be kept, got %v", pruned)
+	}
+	if err := eng.Job("inspect", imageID, "image").Run(); err != nil {
+		t.Fatalf("Expected the dry run to keep the image: %s", err)
+	}
+
+	// The container stopped just now, less than 24h ago
+	pruned = prune(false, "")
+	if _, exists := pruned[containerID]; exists {
+		t.Fatalf("Expected the container to be kept, got %v", pruned)
+	}
+	if pruned[imageID] != "image" {
+		t.Fatalf("Expected the image to be pruned, got %v", pruned)
+	}
+	if err := eng.Job("inspect", imageID, "image").Run(); err == nil {
+		t.Fatal("Expected the image to be removed")
+	}
+
+	if pruned = prune(false, "0"); pruned[containerID] != "container" {
+		t.Fatalf("Expected the container to be pruned, got %v", pruned)
+	}
+	if err := eng.Job("inspect", containerID, "container").Run(); err == nil {
+		t.Fatal("Expected the container to be removed")
+	}
+	// Its volume is kept until the next prune
+	if _, exists := pruned[volumeID]; exists {
+		t.Fatalf("Expected the volume of the container to be kept, got %v", pruned)
+	}
+	if pruned = prune(false, "0"); pruned[volumeID] != "volume" {
+		t.Fatalf("Expected the volume %s to be pruned, got %v", volumeID, pruned)
+	}
+}
+
+func TestDiskUsage(t *testing.T) {
+	eng := NewTestEngine(t)
+	defer mkDaemonFromEngine(eng, t).Nuke()
+
+	config, _, _, err := runconfig.Parse([]string{unitTestImageID, "echo", "test"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	containerID := createTestContainer(eng, config, t)
+
+	job := eng.Job("df")
+	df, err := job.Stdout.AddEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	images := engine.NewTable("", 0)
+	if _, err := images.ReadListFrom([]byte(df.Get("Images"))); err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, img := range images.Data {
+		if img.Get("Id") != unitTestImageID {
+			continue
+		}
+		found = true
+		if img.GetInt64("VirtualSize") != img.GetInt64("SharedSize")+img.GetInt64("UniqueSize") {
+			t.Fatalf("Expected the shared and unique sizes to add up to the virtual size, got %v", img)
+		}
+		if img.GetInt("Containers") != 1 {
+			t.Fatalf("Expected the image to be used by 1 container, got %d", img.GetInt("Containers"))
+		}
+	}
+	if !found {
+		t.Fatalf("Expected the test image in the report, got %v", images.Data)
+	}
+
+	containers := engine.NewTable("", 0)
+	if _, err := containers.ReadListFrom([]byte(df.Get("Containers"))); err != nil {
+		t.Fatal(err)
+	}
+	if containers.Len() != 1 || containers.Data[0].Get("Id") != containerID {
+		t.Fatalf("Expected the container in the report, got %v", containers.Data)
+	}
+	// The container is not running but was created less than 24h ago,
+	// a prune keeps it
+	if df.GetInt("ContainersActive") != 0 || df.GetInt64("ContainersReclaimable") != 0 {
+		t.Fatalf("Expected the recent container not to be reclaimable, got %v", df)
+	}
+	if df.GetInt64("LayersSize") < images.Data[0].GetInt64("VirtualSize") {
+		t.Fatalf("Expected the layers to be at least the size of an image, got %v", df)
+	}
+}
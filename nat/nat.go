@@ -39,6 +39,27 @@ func ParsePort(rawPort string) (int, error) {
 	return int(port), nil
 }
 
+// ParsePortRange parses a single port or a range of ports in the format
+// start-end and returns the first and last port of the range
+func ParsePortRange(rawPort string) (int, int, error) {
+	parts := strings.SplitN(rawPort, "-", 2)
+	start, err := ParsePort(parts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(parts) == 1 {
+		return start, start, nil
+	}
+	end, err := ParsePort(parts[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	if end < start {
+		return 0, 0, fmt.Errorf("Invalid range specified for the port: %s", rawPort)
+	}
+	return start, end, nil
+}
+
 func (p Port) Proto() string {
 	parts := strings.Split(string(p), "/")
 	if len(parts) == 1 {
@@ -73,7 +94,8 @@ func SplitProtoPort(rawPort string) (string, string) {
 }
 
 // We will receive port specs in the format of ip:public:private/proto and these need to be
-// parsed in the internal types
+// parsed in the internal types. Both public and private may be a range of ports in the
+// format start-end, in which case one binding is created for each port of the range
 func ParsePortSpecs(ports []string) (map[Port]struct{}, map[Port][]PortBinding, error) {
 	var (
 		exposedPorts = make(map[Port]struct{}, len(ports))
@@ -107,27 +129,47 @@ func ParsePortSpecs(ports []string) (map[Port]struct{}, map[Port][]PortBinding,
 		if containerPort == "" {
 			return nil, nil, fmt.Errorf("No port specified: %s<empty>", rawPort)
 		}
-		if _, err := strconv.ParseUint(containerPort, 10, 16); err != nil {
+		startPort, endPort, err := ParsePortRange(containerPort)
+		if err != nil {
 			return nil, nil, fmt.Errorf("Invalid containerPort: %s", containerPort)
 		}
-		if _, err := strconv.ParseUint(hostPort, 10, 16); hostPort != "" && err != nil {
-			return nil, nil, fmt.Errorf("Invalid hostPort: %s", hostPort)
-		}
 
-		port := NewPort(proto, containerPort)
-		if _, exists := exposedPorts[port]; !exists {
-			exposedPorts[port] = struct{}{}
+		var startHostPort, endHostPort int
+		if hostPort != "" {
+			startHostPort, endHostPort, err = ParsePortRange(hostPort)
+			if err != nil {
+				return nil, nil, fmt.Errorf("Invalid hostPort: %s", hostPort)
+			}
+			if endPort-startPort != endHostPort-startHostPort {
+				return nil, nil, fmt.Errorf("Invalid ranges specified for container and host Ports: %s and %s", containerPort, hostPort)
+			}
 		}
 
-		binding := PortBinding{
-			HostIp:   rawIp,
-			HostPort: hostPort,
-		}
-		bslice, exists := bindings[port]
-		if !exists {
-			bslice = []PortBinding{}
+		for i := 0; i <= endPort-startPort; i++ {
+			// single ports are kept as they were written
+			containerPortNumber, hostPortNumber := containerPort, hostPort
+			if startPort != endPort {
+				containerPortNumber = strconv.Itoa(startPort + i)
+				if hostPort != "" {
+					hostPortNumber = strconv.Itoa(startHostPort + i)
+				}
+			}
+
+			port := NewPort(proto, containerPortNumber)
+			if _, exists := exposedPorts[port]; !exists {
+				exposedPorts[port] = struct{}{}
+			}
+
+			binding := PortBinding{
+				HostIp:   rawIp,
+				HostPort: hostPortNumber,
+			}
+			bslice, exists := bindings[port]
+			if !exists {
+				bslice = []PortBinding{}
+			}
+			bindings[port] = append(bslice, binding)
 		}
-		bindings[port] = append(bslice, binding)
 	}
 	return exposedPorts, bindings, nil
 }
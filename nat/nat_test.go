@@ -0,0 +1,65 @@
+package nat
+
+import (
+	"testing"
+)
+
+func TestParsePortRange(t *testing.T) {
+	start, end, err := ParsePortRange("8000-8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if start != 8000 || end != 8080 {
+		t.Fatalf("Expected range 8000-8080, got %d-%d", start, end)
+	}
+
+	start, end, err = ParsePortRange("22")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if start != 22 || end != 22 {
+		t.Fatalf("Expected range 22-22, got %d-%d", start, end)
+	}
+
+	for _, invalid := range []string{"", "8080-8000", "a-b", "1-70000", "-8080"} {
+		if _, _, err := ParsePortRange(invalid); err == nil {
+			t.Fatalf("Expected an error parsing %q", invalid)
+		}
+	}
+}
+
+func TestParsePortSpecsWithRange(t *testing.T) {
+	exposed, bindings, err := ParsePortSpecs([]string{"10000-10002:20000-20002/udp", "127.0.0.1::7000-7001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exposed) != 5 {
+		t.Fatalf("Expected 5 exposed ports, got %d", len(exposed))
+	}
+
+	for i, p := range []string{"20000/udp", "20001/udp", "20002/udp"} {
+		b, exists := bindings[Port(p)]
+		if !exists {
+			t.Fatalf("Expected a binding for %s", p)
+		}
+		if len(b) != 1 || b[0].HostPort != []string{"10000", "10001", "10002"}[i] {
+			t.Fatalf("Unexpected binding for %s: %v", p, b)
+		}
+	}
+
+	for _, p := range []string{"7000/tcp", "7001/tcp"} {
+		b := bindings[Port(p)]
+		if len(b) != 1 || b[0].HostIp != "127.0.0.1" || b[0].HostPort != "" {
+			t.Fatalf("Unexpected binding for %s: %v", p, b)
+		}
+	}
+}
+
+func TestParsePortSpecsInvalidRange(t *testing.T) {
+	if _, _, err := ParsePortSpecs([]string{"10000-10002:20000-20001"}); err == nil {
+		t.Fatal("Expected an error for ranges of different lengths")
+	}
+	if _, _, err := ParsePortSpecs([]string{"10000:20000-20001"}); err == nil {
+		t.Fatal("Expected an error for a single host port mapped to a range")
+	}
+}
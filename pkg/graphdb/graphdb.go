@@ -276,6 +276,19 @@ func (db *Database) Children(name string, depth int) ([]WalkMeta, error) {
 	return db.children(e, name, depth, nil)
 }
 
+// Return the ids of the entities which have the specified entity as
+// a direct child, the root entity is not included
+func (db *Database) Parents(name string) ([]string, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	e, err := db.get(name)
+	if err != nil {
+		return nil, err
+	}
+	return db.parents(e)
+}
+
 // Return the refrence count for a specified id
 func (db *Database) Refs(id string) int {
 	db.mux.RLock()
@@ -461,6 +474,31 @@ func (db *Database) children(e *Entity, name string, depth int, entities []WalkM
 	return entities, nil
 }
 
+func (db *Database) parents(e *Entity) (parents []string, err error) {
+	if e == nil {
+		return parents, nil
+	}
+
+	rows, err := db.conn.Query("SELECT parent_id FROM edge where entity_id = ?;", e.id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var parentId string
+		if err := rows.Scan(&parentId); err != nil {
+			return nil, err
+		}
+		if parentId == "0" {
+			continue
+		}
+		parents = append(parents, parentId)
+	}
+
+	return parents, nil
+}
+
 // Return the entity based on the parent path and name
 func (db *Database) child(parent *Entity, name string) *Entity {
 	var id string
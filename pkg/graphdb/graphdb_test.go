@@ -468,6 +468,38 @@ func TestRefPaths(t *testing.T) {
 	}
 }
 
+func TestParents(t *testing.T) {
+	db, dbpath := newTestDb(t)
+	defer destroyTestDb(dbpath)
+
+	db.Set("/webapp", "1")
+	db.Set("/worker", "3")
+	db.Set("/db", "2")
+	db.Set("/webapp/db", "2")
+	db.Set("/worker/database", "2")
+
+	parents, err := db.Parents("/db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parents) != 2 {
+		t.Fatalf("Expected 2 parents, got %d", len(parents))
+	}
+	for _, p := range parents {
+		if p != "1" && p != "3" {
+			t.Fatalf("Expected parents to be 1 and 3, got %v", parents)
+		}
+	}
+
+	parents, err = db.Parents("/webapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parents) != 0 {
+		t.Fatalf("Expected no parents, got %v", parents)
+	}
+}
+
 func TestExistsTrue(t *testing.T) {
 	db, dbpath := newTestDb(t)
 	defer destroyTestDb(dbpath)
@@ -25,23 +25,33 @@ var (
 type Chain struct {
 	Name   string
 	Bridge string
+	// HairpinMode forwards the ports without a userland proxy, traffic
+	// from the loopback and from a container to its own published ports
+	// is handled by the rules instead
+	HairpinMode bool
 }
 
-func NewChain(name, bridge string) (*Chain, error) {
+func NewChain(name, bridge string, hairpinMode bool) (*Chain, error) {
 	if output, err := Raw("-t", "nat", "-N", name); err != nil {
 		return nil, err
 	} else if len(output) != 0 {
 		return nil, fmt.Errorf("Error creating new iptables chain: %s", output)
 	}
 	chain := &Chain{
-		Name:   name,
-		Bridge: bridge,
+		Name:        name,
+		Bridge:      bridge,
+		HairpinMode: hairpinMode,
 	}
 
 	if err := chain.Prerouting(Add, "-m", "addrtype", "--dst-type", "LOCAL"); err != nil {
 		return nil, fmt.Errorf("Failed to inject docker in PREROUTING chain: %s", err)
 	}
-	if err := chain.Output(Add, "-m", "addrtype", "--dst-type", "LOCAL", "!", "--dst", "127.0.0.0/8"); err != nil {
+	outputArgs := []string{"-m", "addrtype", "--dst-type", "LOCAL"}
+	if !hairpinMode {
+		// without hairpin mode the userland proxy handles the loopback
+		outputArgs = append(outputArgs, "!", "--dst", "127.0.0.0/8")
+	}
+	if err := chain.Output(Add, outputArgs...); err != nil {
 		return nil, fmt.Errorf("Failed to inject docker in OUTPUT chain: %s", err)
 	}
 	return chain, nil
@@ -55,6 +65,20 @@ func RemoveExistingChain(name string) error {
 }
 
 func (c *Chain) Forward(action Action, ip net.IP, port int, proto, dest_addr string, dest_port int) error {
+	return c.forward(action, ip, strconv.Itoa(port), proto, dest_addr, strconv.Itoa(dest_port))
+}
+
+// ForwardRange forwards a range of ports on ip to the same range of
+// ports on dest_addr with a single set of rules
+func (c *Chain) ForwardRange(action Action, ip net.IP, startPort, endPort int, proto, dest_addr string) error {
+	if startPort == endPort {
+		return c.Forward(action, ip, startPort, proto, dest_addr, startPort)
+	}
+	ports := fmt.Sprintf("%d:%d", startPort, endPort)
+	return c.forward(action, ip, ports, proto, dest_addr, ports)
+}
+
+func (c *Chain) forward(action Action, ip net.IP, ports, proto, dest_addr, dest_ports string) error {
 	daddr := ip.String()
 	if ip.IsUnspecified() {
 		// iptables interprets "0.0.0.0" as "0.0.0.0/32", whereas we
@@ -62,13 +86,14 @@ func (c *Chain) Forward(action Action, ip net.IP, port int, proto, dest_addr str
 		// value" by both iptables and ip6tables.
 		daddr = "0/0"
 	}
-	if output, err := Raw("-t", "nat", fmt.Sprint(action), c.Name,
-		"-p", proto,
-		"-d", daddr,
-		"--dport", strconv.Itoa(port),
-		"!", "-i", c.Bridge,
-		"-j", "DNAT",
-		"--to-destination", net.JoinHostPort(dest_addr, strconv.Itoa(dest_port))); err != nil {
+
+	// Without a port DNAT keeps the original destination port, which
+	// is how a range is forwarded to the same range in the container
+	destination := dest_addr
+	if !strings.Contains(dest_ports, ":") {
+		destination = net.JoinHostPort(dest_addr, dest_ports)
+	}
+	if output, err := Raw(c.dnatArgs(action, daddr, ports, proto, destination)...); err != nil {
 		return err
 	} else if len(output) != 0 {
 		return fmt.Errorf("Error iptables forward: %s", output)
@@ -83,16 +108,45 @@ func (c *Chain) Forward(action Action, ip net.IP, port int, proto, dest_addr str
 		"-o", c.Bridge,
 		"-p", proto,
 		"-d", dest_addr,
-		"--dport", strconv.Itoa(dest_port),
+		"--dport", dest_ports,
 		"-j", "ACCEPT"); err != nil {
 		return err
 	} else if len(output) != 0 {
 		return fmt.Errorf("Error iptables forward: %s", output)
 	}
 
+	if c.HairpinMode {
+		// A container reaching its own published port gets the packets
+		// back from the host, not from itself
+		if output, err := Raw("-t", "nat", fmt.Sprint(action), "POSTROUTING",
+			"-p", proto,
+			"-s", dest_addr,
+			"-d", dest_addr,
+			"--dport", dest_ports,
+			"-j", "MASQUERADE"); err != nil {
+			return err
+		} else if len(output) != 0 {
+			return fmt.Errorf("Error iptables forward: %s", output)
+		}
+	}
+
 	return nil
 }
 
+// dnatArgs returns the arguments of the rule forwarding the ports of daddr
+// to destination. Without hairpin mode the packets of the containers are
+// left to the userland proxy, in hairpin mode they are forwarded too.
+func (c *Chain) dnatArgs(action Action, daddr, ports, proto, destination string) []string {
+	args := []string{"-t", "nat", fmt.Sprint(action), c.Name,
+		"-p", proto,
+		"-d", daddr,
+		"--dport", ports}
+	if !c.HairpinMode {
+		args = append(args, "!", "-i", c.Bridge)
+	}
+	return append(args, "-j", "DNAT", "--to-destination", destination)
+}
+
 func (c *Chain) Prerouting(action Action, args ...string) error {
 	a := append(nat, fmt.Sprint(action), "PREROUTING")
 	if len(args) > 0 {
@@ -123,7 +177,7 @@ func (c *Chain) Remove() error {
 	// Ignore errors - This could mean the chains were never set up
 	c.Prerouting(Delete, "-m", "addrtype", "--dst-type", "LOCAL")
 	c.Output(Delete, "-m", "addrtype", "--dst-type", "LOCAL", "!", "--dst", "127.0.0.0/8")
-	c.Output(Delete, "-m", "addrtype", "--dst-type", "LOCAL") // Created in versions <= 0.1.6
+	c.Output(Delete, "-m", "addrtype", "--dst-type", "LOCAL") // Created in versions <= 0.1.6 and in hairpin mode
 
 	c.Prerouting(Delete)
 	c.Output(Delete)
@@ -0,0 +1,23 @@
+package iptables
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDnatArgs(t *testing.T) {
+	chain := &Chain{Name: "DOCKER", Bridge: "docker0"}
+	expected := []string{"-t", "nat", "-A", "DOCKER", "-p", "tcp", "-d", "0/0", "--dport", "80",
+		"!", "-i", "docker0", "-j", "DNAT", "--to-destination", "172.17.0.2:8080"}
+	if args := chain.dnatArgs(Add, "0/0", "80", "tcp", "172.17.0.2:8080"); !reflect.DeepEqual(args, expected) {
+		t.Fatalf("Expected %v, got %v", expected, args)
+	}
+
+	// The containers reaching a published port of the host are forwarded too
+	chain.HairpinMode = true
+	expected = []string{"-t", "nat", "-A", "DOCKER", "-p", "tcp", "-d", "0/0", "--dport", "80",
+		"-j", "DNAT", "--to-destination", "172.17.0.2:8080"}
+	if args := chain.dnatArgs(Add, "0/0", "80", "tcp", "172.17.0.2:8080"); !reflect.DeepEqual(args, expected) {
+		t.Fatalf("Expected %v, got %v", expected, args)
+	}
+}
@@ -0,0 +1,54 @@
+package network
+
+import (
+	"fmt"
+	"github.com/dotcloud/docker/pkg/libcontainer"
+	"github.com/dotcloud/docker/pkg/libcontainer/utils"
+)
+
+// IpVlan is a network strategy that creates an ipvlan device on top
+// of the "parent" interface of the host and places it inside the
+// container's namespace, the device shares the MAC address of the parent
+type IpVlan struct {
+}
+
+func (i *IpVlan) Create(n *libcontainer.Network, nspid int, context libcontainer.Context) error {
+	var (
+		parent string
+		prefix string
+		exists bool
+	)
+	if parent, exists = n.Context["parent"]; !exists {
+		return fmt.Errorf("parent does not exist in network context")
+	}
+	if prefix, exists = n.Context["prefix"]; !exists {
+		return fmt.Errorf("ipvlan prefix does not exist in network context")
+	}
+	mode := n.Context["mode"]
+	if mode == "" {
+		mode = "l2"
+	}
+	name, err := utils.GenerateRandomName(prefix, 4)
+	if err != nil {
+		return err
+	}
+	if err := CreateIpVlan(name, parent, mode); err != nil {
+		return err
+	}
+	context["ipvlan-child"] = name
+	if err := SetMtu(name, n.Mtu); err != nil {
+		return err
+	}
+	if err := SetInterfaceInNamespacePid(name, nspid); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (i *IpVlan) Initialize(config *libcontainer.Network, context libcontainer.Context) error {
+	child, exists := context["ipvlan-child"]
+	if !exists {
+		return fmt.Errorf("ipvlan child does not exist in network context")
+	}
+	return initializeChild(child, config)
+}
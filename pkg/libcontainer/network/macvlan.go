@@ -0,0 +1,78 @@
+package network
+
+import (
+	"fmt"
+	"github.com/dotcloud/docker/pkg/libcontainer"
+	"github.com/dotcloud/docker/pkg/libcontainer/utils"
+)
+
+// MacVlan is a network strategy that creates a macvlan device on top
+// of the "parent" interface of the host and places it inside the
+// container's namespace, the device gets its own MAC address on the
+// parent's L2 segment
+type MacVlan struct {
+}
+
+func (m *MacVlan) Create(n *libcontainer.Network, nspid int, context libcontainer.Context) error {
+	var (
+		parent string
+		prefix string
+		exists bool
+	)
+	if parent, exists = n.Context["parent"]; !exists {
+		return fmt.Errorf("parent does not exist in network context")
+	}
+	if prefix, exists = n.Context["prefix"]; !exists {
+		return fmt.Errorf("macvlan prefix does not exist in network context")
+	}
+	mode := n.Context["mode"]
+	if mode == "" {
+		mode = "bridge"
+	}
+	name, err := utils.GenerateRandomName(prefix, 4)
+	if err != nil {
+		return err
+	}
+	if err := CreateMacVlan(name, parent, mode); err != nil {
+		return err
+	}
+	context["macvlan-child"] = name
+	if err := SetMtu(name, n.Mtu); err != nil {
+		return err
+	}
+	if err := SetInterfaceInNamespacePid(name, nspid); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *MacVlan) Initialize(config *libcontainer.Network, context libcontainer.Context) error {
+	child, exists := context["macvlan-child"]
+	if !exists {
+		return fmt.Errorf("macvlan child does not exist in network context")
+	}
+	return initializeChild(child, config)
+}
+
+// initializeChild renames the child interface to eth0 inside the
+// container's namespace, sets its address and brings it up
+func initializeChild(child string, config *libcontainer.Network) error {
+	if err := ChangeInterfaceName(child, "eth0"); err != nil {
+		return fmt.Errorf("change %s to eth0 %s", child, err)
+	}
+	if err := SetInterfaceIp("eth0", config.Address); err != nil {
+		return fmt.Errorf("set eth0 ip %s", err)
+	}
+	if err := SetMtu("eth0", config.Mtu); err != nil {
+		return fmt.Errorf("set eth0 mtu to %d %s", config.Mtu, err)
+	}
+	if err := InterfaceUp("eth0"); err != nil {
+		return fmt.Errorf("eth0 up %s", err)
+	}
+	if config.Gateway != "" {
+		if err := SetDefaultGateway(config.Gateway); err != nil {
+			return fmt.Errorf("set gateway to %s %s", config.Gateway, err)
+		}
+	}
+	return nil
+}
@@ -33,6 +33,14 @@ func CreateVethPair(name1, name2 string) error {
 	return netlink.NetworkCreateVethPair(name1, name2)
 }
 
+func CreateMacVlan(name, parent, mode string) error {
+	return netlink.NetworkLinkAddMacVlan(parent, name, mode)
+}
+
+func CreateIpVlan(name, parent, mode string) error {
+	return netlink.NetworkLinkAddIpVlan(parent, name, mode)
+}
+
 func SetInterfaceInNamespacePid(name string, nsPid int) error {
 	iface, err := net.InterfaceByName(name)
 	if err != nil {
@@ -53,6 +61,14 @@ func SetInterfaceMaster(name, master string) error {
 	return netlink.AddToBridge(iface, masterIface)
 }
 
+func SetHairpinMode(name string, enabled bool) error {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return err
+	}
+	return netlink.NetworkSetHairpinMode(iface, enabled)
+}
+
 func SetDefaultGateway(ip string) error {
 	return netlink.AddDefaultGw(net.ParseIP(ip))
 }
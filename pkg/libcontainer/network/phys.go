@@ -0,0 +1,54 @@
+package network
+
+import (
+	"fmt"
+	"github.com/dotcloud/docker/pkg/libcontainer"
+)
+
+// Phys is a network strategy that moves an existing interface of the
+// host, named by the "link" context value, into the container's namespace
+type Phys struct {
+}
+
+func (p *Phys) Create(n *libcontainer.Network, nspid int, context libcontainer.Context) error {
+	link, exists := n.Context["link"]
+	if !exists {
+		return fmt.Errorf("link does not exist in network context")
+	}
+	if err := InterfaceDown(link); err != nil {
+		return err
+	}
+	if err := SetInterfaceInNamespacePid(link, nspid); err != nil {
+		return err
+	}
+	context["phys-child"] = link
+	return nil
+}
+
+func (p *Phys) Initialize(config *libcontainer.Network, context libcontainer.Context) error {
+	var (
+		physChild string
+		exists    bool
+	)
+	if physChild, exists = context["phys-child"]; !exists {
+		return fmt.Errorf("physChild does not exist in network context")
+	}
+	if err := ChangeInterfaceName(physChild, "eth0"); err != nil {
+		return fmt.Errorf("change %s to eth0 %s", physChild, err)
+	}
+	if err := SetInterfaceIp("eth0", config.Address); err != nil {
+		return fmt.Errorf("set eth0 ip %s", err)
+	}
+	if err := SetMtu("eth0", config.Mtu); err != nil {
+		return fmt.Errorf("set eth0 mtu to %d %s", config.Mtu, err)
+	}
+	if err := InterfaceUp("eth0"); err != nil {
+		return fmt.Errorf("eth0 up %s", err)
+	}
+	if config.Gateway != "" {
+		if err := SetDefaultGateway(config.Gateway); err != nil {
+			return fmt.Errorf("set gateway to %s %s", config.Gateway, err)
+		}
+	}
+	return nil
+}
@@ -14,6 +14,9 @@ var strategies = map[string]NetworkStrategy{
 	"veth":     &Veth{},
 	"loopback": &Loopback{},
 	"netns":    &NetNS{},
+	"phys":     &Phys{},
+	"macvlan":  &MacVlan{},
+	"ipvlan":   &IpVlan{},
 }
 
 // NetworkStrategy represents a specific network configuration for
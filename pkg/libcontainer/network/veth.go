@@ -9,6 +9,8 @@ import (
 // Veth is a network strategy that uses a bridge and creates
 // a veth pair, one that stays outside on the host and the other
 // is placed inside the container's namespace
+// Hairpin mode is enabled on the host side of the pair when the
+// "hairpin" context value is "true"
 type Veth struct {
 }
 
@@ -36,6 +38,11 @@ func (v *Veth) Create(n *libcontainer.Network, nspid int, context libcontainer.C
 	if err := SetMtu(name1, n.Mtu); err != nil {
 		return err
 	}
+	if n.Context["hairpin"] == "true" {
+		if err := SetHairpinMode(name1, true); err != nil {
+			return err
+		}
+	}
 	if err := InterfaceUp(name1); err != nil {
 		return err
 	}
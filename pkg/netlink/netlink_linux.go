@@ -12,14 +12,17 @@ import (
 )
 
 const (
-	IFNAMSIZ       = 16
-	DEFAULT_CHANGE = 0xFFFFFFFF
-	IFLA_INFO_KIND = 1
-	IFLA_INFO_DATA = 2
-	VETH_INFO_PEER = 1
-	IFLA_NET_NS_FD = 28
-	SIOC_BRADDBR   = 0x89a0
-	SIOC_BRADDIF   = 0x89a2
+	IFNAMSIZ          = 16
+	DEFAULT_CHANGE    = 0xFFFFFFFF
+	IFLA_INFO_KIND    = 1
+	IFLA_INFO_DATA    = 2
+	VETH_INFO_PEER    = 1
+	IFLA_NET_NS_FD    = 28
+	IFLA_BRPORT_MODE  = 4
+	IFLA_MACVLAN_MODE = 1
+	IFLA_IPVLAN_MODE  = 1
+	SIOC_BRADDBR      = 0x89a0
+	SIOC_BRADDIF      = 0x89a2
 )
 
 var nextSeqNr int
@@ -510,6 +513,41 @@ func NetworkSetMaster(iface, master *net.Interface) error {
 	return s.HandleAck(wb.Seq)
 }
 
+// same as bridge link set dev $name hairpin on|off
+// Hairpin mode lets a bridge port send a frame back out of the port it
+// was received on, iface has to be attached to a bridge
+func NetworkSetHairpinMode(iface *net.Interface, enabled bool) error {
+	s, err := getNetlinkSocket()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	wb := newNetlinkRequest(syscall.RTM_SETLINK, syscall.NLM_F_ACK)
+
+	msg := newIfInfomsg(syscall.AF_BRIDGE)
+	msg.Type = syscall.RTM_SETLINK
+	msg.Flags = syscall.NLM_F_REQUEST
+	msg.Index = int32(iface.Index)
+	msg.Change = DEFAULT_CHANGE
+	wb.AddData(msg)
+
+	mode := []byte{0}
+	if enabled {
+		mode[0] = 1
+	}
+
+	protinfo := newRtAttr(syscall.IFLA_PROTINFO|syscall.NLA_F_NESTED, nil)
+	newRtAttrChild(protinfo, IFLA_BRPORT_MODE, mode)
+	wb.AddData(protinfo)
+
+	if err := s.Send(wb); err != nil {
+		return err
+	}
+
+	return s.HandleAck(wb.Seq)
+}
+
 func NetworkSetNsPid(iface *net.Interface, nspid int) error {
 	s, err := getNetlinkSocket()
 	if err != nil {
@@ -822,6 +860,79 @@ func NetworkCreateVethPair(name1, name2 string) error {
 	return s.HandleAck(wb.Seq)
 }
 
+var macVlanModes = map[string]uint32{
+	"private":  1,
+	"vepa":     2,
+	"bridge":   4,
+	"passthru": 8,
+}
+
+var ipVlanModes = map[string]uint16{
+	"l2": 0,
+	"l3": 1,
+}
+
+// Add a macvlan device named macVlanDev on top of masterDev. This is
+// identical to running: ip link add link $masterDev $macVlanDev type macvlan mode $mode
+func NetworkLinkAddMacVlan(masterDev, macVlanDev string, mode string) error {
+	m, exists := macVlanModes[mode]
+	if !exists {
+		return fmt.Errorf("invalid macvlan mode: %s", mode)
+	}
+	b := make([]byte, 4)
+	nativeEndian().PutUint32(b, m)
+	return networkLinkAddSubDev("macvlan", masterDev, macVlanDev, IFLA_MACVLAN_MODE, b)
+}
+
+// Add an ipvlan device named ipVlanDev on top of masterDev. This is
+// identical to running: ip link add link $masterDev $ipVlanDev type ipvlan mode $mode
+func NetworkLinkAddIpVlan(masterDev, ipVlanDev string, mode string) error {
+	m, exists := ipVlanModes[mode]
+	if !exists {
+		return fmt.Errorf("invalid ipvlan mode: %s", mode)
+	}
+	b := make([]byte, 2)
+	nativeEndian().PutUint16(b, m)
+	return networkLinkAddSubDev("ipvlan", masterDev, ipVlanDev, IFLA_IPVLAN_MODE, b)
+}
+
+func networkLinkAddSubDev(linkType, masterDev, name string, modeType int, mode []byte) error {
+	masterIface, err := net.InterfaceByName(masterDev)
+	if err != nil {
+		return err
+	}
+
+	s, err := getNetlinkSocket()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	wb := newNetlinkRequest(syscall.RTM_NEWLINK, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL|syscall.NLM_F_ACK)
+
+	msg := newIfInfomsg(syscall.AF_UNSPEC)
+	wb.AddData(msg)
+
+	b := make([]byte, 4)
+	nativeEndian().PutUint32(b, uint32(masterIface.Index))
+	wb.AddData(newRtAttr(syscall.IFLA_LINK, b))
+
+	nameData := newRtAttr(syscall.IFLA_IFNAME, zeroTerminated(name))
+	wb.AddData(nameData)
+
+	nest1 := newRtAttr(syscall.IFLA_LINKINFO, nil)
+	newRtAttrChild(nest1, IFLA_INFO_KIND, nonZeroTerminated(linkType))
+	nest2 := newRtAttrChild(nest1, IFLA_INFO_DATA, nil)
+	newRtAttrChild(nest2, modeType, mode)
+
+	wb.AddData(nest1)
+
+	if err := s.Send(wb); err != nil {
+		return err
+	}
+	return s.HandleAck(wb.Seq)
+}
+
 // Create the actual bridge device.  This is more backward-compatible than
 // netlink.NetworkLinkAdd and works on RHEL 6.
 func CreateBridge(name string, setMacAddr bool) error {
@@ -40,6 +40,14 @@ func NetworkCreateVethPair(name1, name2 string) error {
 	return ErrNotImplemented
 }
 
+func NetworkLinkAddMacVlan(masterDev, macVlanDev string, mode string) error {
+	return ErrNotImplemented
+}
+
+func NetworkLinkAddIpVlan(masterDev, ipVlanDev string, mode string) error {
+	return ErrNotImplemented
+}
+
 func NetworkChangeName(iface *net.Interface, newName string) error {
 	return ErrNotImplemented
 }
@@ -56,6 +64,10 @@ func NetworkSetMaster(iface, master *net.Interface) error {
 	return ErrNotImplemented
 }
 
+func NetworkSetHairpinMode(iface *net.Interface, enabled bool) error {
+	return ErrNotImplemented
+}
+
 func NetworkLinkDown(iface *net.Interface) error {
 	return ErrNotImplemented
 }
@@ -1,9 +1,12 @@
 package etchosts
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"strings"
 )
 
 var defaultContent = map[string]string{
@@ -41,3 +44,54 @@ func Build(path, IP, hostname, domainname string, extraContent *map[string]strin
 
 	return ioutil.WriteFile(path, content.Bytes(), 0644)
 }
+
+// Update sets the IP of the entry for hostname in the hosts file at path,
+// adding the entry if it does not exist. Other entries, including the ones
+// added from inside the container, are kept as is.
+// The file is bind mounted into the container so it is rewritten in place
+// rather than renamed over: the new content is written with a single call
+// over the old one and the file is only then cut to its new length, so it
+// is never empty. The update is not atomic, a reader of the container may
+// see a partially written file, or a shorter new content followed by the
+// end of the old one until the file is cut.
+func Update(path, IP, hostname string) error {
+	old, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var (
+		content = bytes.NewBuffer(nil)
+		found   bool
+		scanner = bufio.NewScanner(bytes.NewReader(old))
+	)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if fields := strings.Fields(line); len(fields) > 1 && !strings.HasPrefix(fields[0], "#") {
+			for _, h := range fields[1:] {
+				if h == hostname {
+					line = fmt.Sprintf("%s\t%s", IP, strings.Join(fields[1:], " "))
+					found = true
+					break
+				}
+			}
+		}
+		content.WriteString(line + "\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if !found {
+		content.WriteString(fmt.Sprintf("%s\t%s\n", IP, hostname))
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.WriteAt(content.Bytes(), 0); err != nil {
+		return err
+	}
+	return f.Truncate(int64(content.Len()))
+}
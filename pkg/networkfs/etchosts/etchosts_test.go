@@ -72,3 +72,53 @@ func TestBuildNoIP(t *testing.T) {
 		t.Fatalf("Expected to find '%s' got '%s'", expected, content)
 	}
 }
+
+func TestUpdate(t *testing.T) {
+	file, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+
+	extraContent := map[string]string{"db": "172.17.0.2"}
+	if err := Build(file.Name(), "10.11.12.13", "testhostname", "", &extraContent); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Update(file.Name(), "172.17.0.5", "db"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Update(file.Name(), "172.17.0.6", "cache"); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := "172.17.0.5\tdb\n"; !bytes.Contains(content, []byte(expected)) {
+		t.Fatalf("Expected to find '%s' got '%s'", expected, content)
+	}
+	if expected := "172.17.0.6\tcache\n"; !bytes.Contains(content, []byte(expected)) {
+		t.Fatalf("Expected to find '%s' got '%s'", expected, content)
+	}
+	if expected := "10.11.12.13\ttesthostname\n"; !bytes.Contains(content, []byte(expected)) {
+		t.Fatalf("Expected to find '%s' got '%s'", expected, content)
+	}
+	if unexpected := "172.17.0.2"; bytes.Contains(content, []byte(unexpected)) {
+		t.Fatalf("Expected '%s' to be replaced, got '%s'", unexpected, content)
+	}
+
+	// A shorter content leaves nothing of the old one behind
+	if err := Update(file.Name(), "1.1.1.1", "cache"); err != nil {
+		t.Fatal(err)
+	}
+	content, err = ioutil.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "\n1.1.1.1\tcache\n"; !bytes.HasSuffix(content, []byte(expected)) {
+		t.Fatalf("Expected to end with '%s' got '%s'", expected, content)
+	}
+}
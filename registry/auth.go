@@ -16,6 +16,9 @@ import (
 // Where we store the config file
 const CONFIGFILE = ".dockercfg"
 
+// The key of the config file naming the credentials helper
+const credsStoreKey = "credsStore"
+
 // Only used for user auth + account creation
 const INDEXSERVER = "https://index.docker.io/v1/"
 
@@ -34,8 +37,11 @@ type AuthConfig struct {
 }
 
 type ConfigFile struct {
-	Configs  map[string]AuthConfig `json:"configs,omitempty"`
-	rootPath string
+	Configs map[string]AuthConfig `json:"configs,omitempty"`
+	// CredsStore names the credentials helper keeping the passwords, it is
+	// read from the "credsStore" key of the config file and stays client side
+	CredsStore string `json:"-"`
+	rootPath   string
 }
 
 func IndexServerAddress() string {
@@ -84,7 +90,8 @@ func LoadConfig(rootPath string) (*ConfigFile, error) {
 		return &configFile, err
 	}
 
-	if err := json.Unmarshal(b, &configFile.Configs); err != nil {
+	entries := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(b, &entries); err != nil {
 		arr := strings.Split(string(b), "\n")
 		if len(arr) < 2 {
 			return &configFile, fmt.Errorf("The Auth config file is empty")
@@ -106,14 +113,27 @@ func LoadConfig(rootPath string) (*ConfigFile, error) {
 		authConfig.ServerAddress = IndexServerAddress()
 		configFile.Configs[IndexServerAddress()] = authConfig
 	} else {
-		for k, authConfig := range configFile.Configs {
-			authConfig.Username, authConfig.Password, err = decodeAuth(authConfig.Auth)
-			if err != nil {
+		if credsStore, exists := entries[credsStoreKey]; exists {
+			if err := json.Unmarshal(credsStore, &configFile.CredsStore); err != nil {
+				return &configFile, fmt.Errorf("Invalid %s in the Auth config file: %s", credsStoreKey, err)
+			}
+			delete(entries, credsStoreKey)
+		}
+		for k, entry := range entries {
+			authConfig := AuthConfig{}
+			if err := json.Unmarshal(entry, &authConfig); err != nil {
 				return &configFile, err
 			}
+			// The credentials of the registry are in the helper
+			if authConfig.Auth != "" {
+				authConfig.Username, authConfig.Password, err = decodeAuth(authConfig.Auth)
+				if err != nil {
+					return &configFile, err
+				}
+			}
 			authConfig.Auth = ""
-			configFile.Configs[k] = authConfig
 			authConfig.ServerAddress = k
+			configFile.Configs[k] = authConfig
 		}
 	}
 	return &configFile, nil
@@ -122,21 +142,30 @@ func LoadConfig(rootPath string) (*ConfigFile, error) {
 // save the auth config
 func SaveConfig(configFile *ConfigFile) error {
 	confFile := path.Join(configFile.rootPath, CONFIGFILE)
-	if len(configFile.Configs) == 0 {
+	if len(configFile.Configs) == 0 && configFile.CredsStore == "" {
 		os.Remove(confFile)
 		return nil
 	}
 
-	configs := make(map[string]AuthConfig, len(configFile.Configs))
+	configs := make(map[string]interface{}, len(configFile.Configs)+1)
 	for k, authConfig := range configFile.Configs {
 		authCopy := authConfig
 
-		authCopy.Auth = encodeAuth(&authCopy)
+		if configFile.CredsStore == "" {
+			authCopy.Auth = encodeAuth(&authCopy)
+		} else if authCopy.Username != "" {
+			if err := credentialsHelper(configFile.CredsStore).store(k, authCopy); err != nil {
+				return err
+			}
+		}
 		authCopy.Username = ""
 		authCopy.Password = ""
 		authCopy.ServerAddress = ""
 		configs[k] = authCopy
 	}
+	if configFile.CredsStore != "" {
+		configs[credsStoreKey] = configFile.CredsStore
+	}
 
 	b, err := json.Marshal(configs)
 	if err != nil {
@@ -155,7 +184,7 @@ func Login(authConfig *AuthConfig, factory *utils.HTTPRequestFactory) (string, e
 		status        string
 		reqBody       []byte
 		err           error
-		client        = &http.Client{}
+		client        = &http.Client{Transport: newHostTransport(false)}
 		reqStatusCode = 0
 		serverAddress = authConfig.ServerAddress
 	)
@@ -166,6 +195,17 @@ func Login(authConfig *AuthConfig, factory *utils.HTTPRequestFactory) (string, e
 
 	loginAgainstOfficialIndex := serverAddress == IndexServerAddress()
 
+	// A registry behind a token service has no accounts of its own,
+	// the credentials are good if the token service accepts them
+	if !loginAgainstOfficialIndex {
+		if challenge, err := pingBearerChallenge(client, v2Endpoint(serverAddress)); err == nil && challenge != nil {
+			if _, err := fetchToken(client, challenge, "", authConfig); err != nil {
+				return "", err
+			}
+			return "Login Succeeded", nil
+		}
+	}
+
 	// to avoid sending the server address to the server it should be removed before being marshalled
 	authCopy := *authConfig
 	authCopy.ServerAddress = ""
@@ -177,7 +217,7 @@ func Login(authConfig *AuthConfig, factory *utils.HTTPRequestFactory) (string, e
 
 	// using `bytes.NewReader(jsonBody)` here causes the server to respond with a 411 status.
 	b := strings.NewReader(string(jsonBody))
-	req1, err := http.Post(serverAddress+"users/", "application/json; charset=utf-8", b)
+	req1, err := client.Post(serverAddress+"users/", "application/json; charset=utf-8", b)
 	if err != nil {
 		return "", fmt.Errorf("Server Error: %s", err)
 	}
@@ -251,16 +291,33 @@ func Login(authConfig *AuthConfig, factory *utils.HTTPRequestFactory) (string, e
 	return status, nil
 }
 
-// this method matches a auth configuration to a server address or a url
+// this method matches a auth configuration to a server address or a url,
+// the credentials are fetched from the credentials helper when there is one
 func (config *ConfigFile) ResolveAuthConfig(hostname string) AuthConfig {
+	serverAddress, authConfig := config.resolveAuthConfig(hostname)
+	if config.CredsStore == "" || authConfig.Username != "" {
+		return authConfig
+	}
+	creds, err := credentialsHelper(config.CredsStore).get(serverAddress)
+	if err != nil {
+		utils.Errorf("Cannot get the credentials of %s: %s", serverAddress, err)
+		return authConfig
+	}
+	authConfig.Username, authConfig.Password = creds.Username, creds.Password
+	return authConfig
+}
+
+// resolveAuthConfig returns the auth configuration matching hostname and
+// the server address it is stored under
+func (config *ConfigFile) resolveAuthConfig(hostname string) (string, AuthConfig) {
 	if hostname == IndexServerAddress() || len(hostname) == 0 {
 		// default to the index server
-		return config.Configs[IndexServerAddress()]
+		return IndexServerAddress(), config.Configs[IndexServerAddress()]
 	}
 
 	// First try the happy case
 	if c, found := config.Configs[hostname]; found {
-		return c
+		return hostname, c
 	}
 
 	convertToHostname := func(url string) string {
@@ -281,10 +338,40 @@ func (config *ConfigFile) ResolveAuthConfig(hostname string) AuthConfig {
 	normalizedHostename := convertToHostname(hostname)
 	for registry, config := range config.Configs {
 		if registryHostname := convertToHostname(registry); registryHostname == normalizedHostename {
-			return config
+			return registry, config
 		}
 	}
 
 	// When all else fails, return an empty auth config
-	return AuthConfig{}
+	return hostname, AuthConfig{}
+}
+
+// LoadCredentials fetches the credentials of every registry of the config
+// from the credentials helper, for the config to be sent to the daemon
+func (config *ConfigFile) LoadCredentials() error {
+	if config.CredsStore == "" {
+		return nil
+	}
+	for serverAddress, authConfig := range config.Configs {
+		if authConfig.Username != "" {
+			continue
+		}
+		creds, err := credentialsHelper(config.CredsStore).get(serverAddress)
+		if err != nil {
+			return err
+		}
+		authConfig.Username, authConfig.Password = creds.Username, creds.Password
+		config.Configs[serverAddress] = authConfig
+	}
+	return nil
+}
+
+// Erase forgets the auth configuration of serverAddress,
+// removing its credentials from the credentials helper
+func (config *ConfigFile) Erase(serverAddress string) error {
+	delete(config.Configs, serverAddress)
+	if config.CredsStore == "" {
+		return nil
+	}
+	return credentialsHelper(config.CredsStore).erase(serverAddress)
 }
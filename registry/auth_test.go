@@ -3,6 +3,8 @@ package registry
 import (
 	"io/ioutil"
 	"os"
+	"path"
+	"strings"
 	"testing"
 )
 
@@ -147,3 +149,69 @@ func TestResolveAuthConfigFullURL(t *testing.T) {
 		}
 	}
 }
+
+// The test helper keeps the credentials of a single server in $dir/creds
+const testCredentialsHelper = `#!/bin/sh
+dir=$(dirname "$0")
+case "$1" in
+get)
+	if [ -f "$dir/creds" ]; then cat "$dir/creds"; else echo "credentials not found in native keychain"; exit 1; fi;;
+store)
+	cat > "$dir/creds";;
+erase)
+	rm -f "$dir/creds";;
+esac
+`
+
+func TestCredentialsHelper(t *testing.T) {
+	configFile, err := setupTempConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(configFile.rootPath)
+	delete(configFile.Configs, "testIndex")
+
+	helperPath := path.Join(configFile.rootPath, "docker-credential-test")
+	if err := ioutil.WriteFile(helperPath, []byte(testCredentialsHelper), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", configFile.rootPath+":"+os.Getenv("PATH"))
+
+	configFile.CredsStore = "test"
+	if err := SaveConfig(configFile); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(path.Join(configFile.rootPath, CONFIGFILE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), encodeAuth(&AuthConfig{Username: "docker-user", Password: "docker-pass"})) {
+		t.Fatalf("Expected the credentials to be kept out of the config file, got %s", b)
+	}
+
+	loaded, err := LoadConfig(configFile.rootPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.CredsStore != "test" {
+		t.Fatalf("Expected the credentials helper test, got %q", loaded.CredsStore)
+	}
+	resolved := loaded.ResolveAuthConfig(IndexServerAddress())
+	if resolved.Username != "docker-user" || resolved.Password != "docker-pass" || resolved.Email != "docker@docker.io" {
+		t.Fatalf("Unexpected auth config %#v", resolved)
+	}
+	if err := loaded.LoadCredentials(); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Configs[IndexServerAddress()].Password != "docker-pass" {
+		t.Fatalf("Expected the credentials to be loaded, got %#v", loaded.Configs[IndexServerAddress()])
+	}
+
+	if err := loaded.Erase(IndexServerAddress()); err != nil {
+		t.Fatal(err)
+	}
+	if resolved := loaded.ResolveAuthConfig(IndexServerAddress()); resolved.Username != "" {
+		t.Fatalf("Expected the credentials to be erased, got %#v", resolved)
+	}
+}
@@ -0,0 +1,82 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// A credentials helper keeps the passwords of the registries out of the
+// config file. The helper named NAME is the executable docker-credential-NAME,
+// called with one of the actions get, store or erase:
+//
+//   get    reads a server address on stdin and writes its credentials as json
+//   store  reads credentials as json on stdin
+//   erase  reads a server address on stdin
+//
+// Credentials are {"ServerURL": "...", "Username": "...", "Secret": "..."}
+// and a failing helper writes its error on stdout.
+
+// The helper's error when it has no credentials for a server
+const errCredentialsNotFound = "credentials not found in native keychain"
+
+type helperCredentials struct {
+	ServerURL string
+	Username  string
+	Secret    string
+}
+
+type credentialsHelper string
+
+func (h credentialsHelper) exec(action string, input []byte) ([]byte, error) {
+	cmd := exec.Command("docker-credential-"+string(h), action)
+	cmd.Stdin = bytes.NewReader(input)
+	output, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(string(output)); msg != "" {
+			return nil, fmt.Errorf("Credentials helper %s: %s", h, msg)
+		}
+		return nil, fmt.Errorf("Credentials helper %s: %s", h, err)
+	}
+	return output, nil
+}
+
+// get returns the credentials of serverAddress, an empty
+// AuthConfig if the helper has none
+func (h credentialsHelper) get(serverAddress string) (AuthConfig, error) {
+	output, err := h.exec("get", []byte(serverAddress))
+	if err != nil {
+		if strings.Contains(err.Error(), errCredentialsNotFound) {
+			return AuthConfig{}, nil
+		}
+		return AuthConfig{}, err
+	}
+	creds := helperCredentials{}
+	if err := json.Unmarshal(output, &creds); err != nil {
+		return AuthConfig{}, fmt.Errorf("Credentials helper %s: %s", h, err)
+	}
+	return AuthConfig{Username: creds.Username, Password: creds.Secret}, nil
+}
+
+func (h credentialsHelper) store(serverAddress string, authConfig AuthConfig) error {
+	input, err := json.Marshal(&helperCredentials{
+		ServerURL: serverAddress,
+		Username:  authConfig.Username,
+		Secret:    authConfig.Password,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = h.exec("store", input)
+	return err
+}
+
+func (h credentialsHelper) erase(serverAddress string) error {
+	_, err := h.exec("erase", []byte(serverAddress))
+	if err != nil && strings.Contains(err.Error(), errCredentialsNotFound) {
+		return nil
+	}
+	return err
+}
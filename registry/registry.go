@@ -13,6 +13,7 @@ import (
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
+	"path"
 	"regexp"
 	"runtime"
 	"strconv"
@@ -45,10 +46,18 @@ func pingRegistryEndpoint(endpoint string) (RegistryInfo, error) {
 		conn.SetDeadline(time.Now().Add(time.Duration(10) * time.Second))
 		return conn, nil
 	}
-	httpTransport := &http.Transport{
-		Dial:  httpDial,
-		Proxy: http.ProxyFromEnvironment,
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return RegistryInfo{Standalone: false}, err
+	}
+	if u.Scheme == "http" && !isInsecure(u.Host) {
+		return RegistryInfo{Standalone: false}, fmt.Errorf("plain HTTP is only allowed for insecure registries")
+	}
+	httpTransport, err := newTransport(u.Host)
+	if err != nil {
+		return RegistryInfo{Standalone: false}, err
 	}
+	httpTransport.Dial = httpDial
 	client := &http.Client{Transport: httpTransport}
 	resp, err := client.Get(endpoint + "_ping")
 	if err != nil {
@@ -155,6 +164,9 @@ func ExpandAndVerifyRegistryUrl(hostname string) (string, error) {
 	}
 	endpoint := fmt.Sprintf("https://%s/v1/", hostname)
 	if _, err := pingRegistryEndpoint(endpoint); err != nil {
+		if !isInsecure(hostname) {
+			return "", fmt.Errorf("Invalid Registry endpoint %s: %s. If this private registry supports only HTTP or HTTPS with an unknown CA certificate, add `--insecure-registry %s` to the daemon's arguments. In the case of HTTPS, if you have access to the registry's CA certificate, place it at %s/ca.crt", endpoint, err, hostname, path.Join(CertsDir, hostname))
+		}
 		utils.Debugf("Registry %s does not work (%s), falling back to http", endpoint, err)
 		endpoint = fmt.Sprintf("http://%s/v1/", hostname)
 		if _, err = pingRegistryEndpoint(endpoint); err != nil {
@@ -165,8 +177,27 @@ func ExpandAndVerifyRegistryUrl(hostname string) (string, error) {
 	return endpoint, nil
 }
 
+// ValidateMirror checks that val is the http or https url of a registry
+// mirror and returns the endpoint of its v1 API
+func ValidateMirror(val string) (string, error) {
+	uri, err := url.Parse(val)
+	if err != nil {
+		return "", fmt.Errorf("%s is not a valid URI", val)
+	}
+	if uri.Scheme != "http" && uri.Scheme != "https" {
+		return "", fmt.Errorf("Unsupported scheme %s for mirror %s", uri.Scheme, val)
+	}
+	if uri.Host == "" {
+		return "", fmt.Errorf("%s is not a valid URI, the host is missing", val)
+	}
+	if uri.Path != "" && uri.Path != "/" && uri.Path != "/v1/" {
+		return "", fmt.Errorf("Unsupported path %s for mirror %s", uri.Path, val)
+	}
+	return fmt.Sprintf("%s://%s/v1/", uri.Scheme, uri.Host), nil
+}
+
 func setTokenAuth(req *http.Request, token []string) {
-	if req.Header.Get("Authorization") == "" { // Don't override
+	if len(token) > 0 && req.Header.Get("Authorization") == "" { // Don't override
 		req.Header.Set("Authorization", "Token "+strings.Join(token, ","))
 	}
 }
@@ -256,22 +287,31 @@ func (r *Registry) GetRemoteImageJSON(imgID, registry string, token []string) ([
 	return jsonString, imageSize, nil
 }
 
-func (r *Registry) GetRemoteImageLayer(imgID, registry string, token []string) (io.ReadCloser, error) {
+// GetRemoteImageLayer fetches the layer of imgID starting at offset. Registries
+// which do not support ranged requests send the whole layer instead, the
+// offset at which the returned content starts is returned along with it.
+func (r *Registry) GetRemoteImageLayer(imgID, registry string, token []string, offset int64) (io.ReadCloser, int64, error) {
 	req, err := r.reqFactory.NewRequest("GET", registry+"images/"+imgID+"/layer", nil)
 	if err != nil {
-		return nil, fmt.Errorf("Error while getting from the server: %s\n", err)
+		return nil, 0, fmt.Errorf("Error while getting from the server: %s\n", err)
 	}
 	setTokenAuth(req, token)
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
 	res, err := r.client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
-	if res.StatusCode != 200 {
-		res.Body.Close()
-		return nil, fmt.Errorf("Server error: Status %d while fetching image layer (%s)",
-			res.StatusCode, imgID)
+	switch res.StatusCode {
+	case 200:
+		return res.Body, 0, nil
+	case 206:
+		return res.Body, offset, nil
 	}
-	return res.Body, nil
+	res.Body.Close()
+	return nil, 0, fmt.Errorf("Server error: Status %d while fetching image layer (%s)",
+		res.StatusCode, imgID)
 }
 
 func (r *Registry) GetRemoteTags(registries []string, repository string, token []string) (map[string]string, error) {
@@ -315,6 +355,42 @@ func (r *Registry) GetRemoteTags(registries []string, repository string, token [
 	return nil, fmt.Errorf("Could not reach any registry endpoint")
 }
 
+// ListTags returns the tags of the repository remote and the ids of their
+// images, the registries speaking the v2 protocol do not tell the ids
+func (r *Registry) ListTags(remote string) (map[string]string, error) {
+	if r.SupportsV2(r.indexEndpoint) {
+		names, err := r.GetV2Tags(r.indexEndpoint, remote)
+		if err != nil {
+			return nil, err
+		}
+		tags := make(map[string]string, len(names))
+		for _, name := range names {
+			tags[name] = ""
+		}
+		return tags, nil
+	}
+	repoData, err := r.GetRepositoryData(remote)
+	if err != nil {
+		return nil, err
+	}
+	return r.GetRemoteTags(repoData.Endpoints, remote, repoData.Tokens)
+}
+
+// lookupRepository finds the repository named remote, for the
+// registries without a search
+func (r *Registry) lookupRepository(remote string) (*SearchResults, error) {
+	results := &SearchResults{Query: remote}
+	if _, err := r.GetRepositoryData(remote); err != nil {
+		if jerr, ok := err.(*utils.JSONError); ok && jerr.Code == 404 {
+			return results, nil
+		}
+		return nil, fmt.Errorf("Error looking up the repository %s: %s", remote, err)
+	}
+	results.NumResults = 1
+	results.Results = []SearchResult{{Name: remote}}
+	return results, nil
+}
+
 func buildEndpointsList(headers []string, indexEp string) ([]string, error) {
 	var endpoints []string
 	parsedUrl, err := url.Parse(indexEp)
@@ -344,7 +420,8 @@ func (r *Registry) GetRepositoryData(remote string) (*RepositoryData, error) {
 	if err != nil {
 		return nil, err
 	}
-	if r.authConfig != nil && len(r.authConfig.Username) > 0 {
+	// Unless the registry authenticates with tokens
+	if r.authConfig != nil && len(r.authConfig.Username) > 0 && req.Header.Get("Authorization") == "" {
 		req.SetBasicAuth(r.authConfig.Username, r.authConfig.Password)
 	}
 	req.Header.Set("X-Docker-Token", "true")
@@ -646,14 +723,25 @@ func (r *Registry) PushImageJSONIndex(remote string, imgList []*ImgData, validat
 	}, nil
 }
 
-func (r *Registry) SearchRepositories(term string) (*SearchResults, error) {
+// SearchRepositories searches the repositories of the index matching term,
+// page and limit select a page of the results when they are not 0
+func (r *Registry) SearchRepositories(term string, page, limit int) (*SearchResults, error) {
 	utils.Debugf("Index server: %s", r.indexEndpoint)
-	u := r.indexEndpoint + "search?q=" + url.QueryEscape(term)
+	query := url.Values{}
+	query.Set("q", term)
+	if page > 0 {
+		query.Set("page", strconv.Itoa(page))
+	}
+	if limit > 0 {
+		query.Set("n", strconv.Itoa(limit))
+	}
+	u := r.indexEndpoint + "search?" + query.Encode()
 	req, err := r.reqFactory.NewRequest("GET", u, nil)
 	if err != nil {
 		return nil, err
 	}
-	if r.authConfig != nil && len(r.authConfig.Username) > 0 {
+	// Unless the registry authenticates with tokens
+	if r.authConfig != nil && len(r.authConfig.Username) > 0 && req.Header.Get("Authorization") == "" {
 		req.SetBasicAuth(r.authConfig.Username, r.authConfig.Password)
 	}
 	req.Header.Set("X-Docker-Token", "true")
@@ -674,6 +762,25 @@ func (r *Registry) SearchRepositories(term string) (*SearchResults, error) {
 	return result, err
 }
 
+// The number of results by page when all the results of a search are fetched
+const searchPageSize = 100
+
+// searchAllRepositories fetches all the pages of the results of term
+func (r *Registry) searchAllRepositories(term string) (*SearchResults, error) {
+	all := &SearchResults{Query: term}
+	for page := 1; ; page++ {
+		results, err := r.SearchRepositories(term, page, searchPageSize)
+		if err != nil {
+			return nil, err
+		}
+		all.NumResults = results.NumResults
+		all.Results = append(all.Results, results.Results...)
+		if len(results.Results) == 0 || len(all.Results) >= results.NumResults {
+			return all, nil
+		}
+	}
+}
+
 func (r *Registry) GetAuthConfig(withPasswd bool) *AuthConfig {
 	password := ""
 	if withPasswd {
@@ -726,15 +833,10 @@ type Registry struct {
 }
 
 func NewRegistry(authConfig *AuthConfig, factory *utils.HTTPRequestFactory, indexEndpoint string) (r *Registry, err error) {
-	httpTransport := &http.Transport{
-		DisableKeepAlives: true,
-		Proxy:             http.ProxyFromEnvironment,
-	}
-
 	r = &Registry{
 		authConfig: authConfig,
 		client: &http.Client{
-			Transport: httpTransport,
+			Transport: newHostTransport(true),
 		},
 		indexEndpoint: indexEndpoint,
 	}
@@ -756,6 +858,7 @@ func NewRegistry(authConfig *AuthConfig, factory *utils.HTTPRequestFactory, inde
 			factory.AddDecorator(dec)
 		}
 	}
+	factory.AddDecorator(NewTokenAuthDecorator(r.client, authConfig))
 
 	r.reqFactory = factory
 	return r, nil
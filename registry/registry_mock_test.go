@@ -77,6 +77,14 @@ var (
 			"latest": "42d718c941f5c532ac049bf0b0ab53f0062f09a03afd4aa4a02c098e46032b9d",
 		},
 	}
+	// v2 blobs by digest and manifests by repository and reference
+	testBlobs     = map[string]string{}
+	testManifests = map[string]map[string]string{}
+	testUploads   = 0
+
+	// copies of the initial v1 content, restored by resetTestRegistry
+	initialLayers       = copyTestStore(testLayers)
+	initialRepositories = copyTestStore(testRepositories)
 )
 
 func init() {
@@ -91,9 +99,36 @@ func init() {
 	r.HandleFunc("/v1/repositories/{repository:.+}{action:/images|/}", handlerImages).Methods("GET", "PUT", "DELETE")
 	r.HandleFunc("/v1/repositories/{repository:.+}/auth", handlerAuth).Methods("PUT")
 	r.HandleFunc("/v1/search", handlerSearch).Methods("GET")
+	r.HandleFunc("/v2/", handlerGetV2Ping).Methods("GET")
+	r.HandleFunc("/v2/{repository:.+}/tags/list", handlerGetV2Tags).Methods("GET")
+	r.HandleFunc("/v2/{repository:.+}/manifests/{reference:[^/]+}", handlerV2Manifest).Methods("GET", "PUT")
+	r.HandleFunc("/v2/{repository:.+}/blobs/uploads/", handlerPostV2Upload).Methods("POST")
+	r.HandleFunc("/v2/{repository:.+}/blobs/uploads/{uuid:[^/]+}", handlerPutV2Upload).Methods("PUT")
+	r.HandleFunc("/v2/{repository:.+}/blobs/{digest:[^/]+}", handlerGetV2Blob).Methods("GET", "HEAD")
 	testHttpServer = httptest.NewServer(handlerAccessLog(r))
 }
 
+func copyTestStore(store map[string]map[string]string) map[string]map[string]string {
+	c := make(map[string]map[string]string, len(store))
+	for key, values := range store {
+		c[key] = make(map[string]string, len(values))
+		for k, v := range values {
+			c[key][k] = v
+		}
+	}
+	return c
+}
+
+// resetTestRegistry undoes the pushes of the previous tests: the v1 images
+// and tags are restored and the v2 blobs and manifests are emptied.
+func resetTestRegistry() {
+	testLayers = copyTestStore(initialLayers)
+	testRepositories = copyTestStore(initialRepositories)
+	testBlobs = map[string]string{}
+	testManifests = map[string]map[string]string{}
+	testUploads = 0
+}
+
 func handlerAccessLog(handler http.Handler) http.Handler {
 	logHandler := func(w http.ResponseWriter, r *http.Request) {
 		utils.Debugf("%s \"%s %s\"", r.RemoteAddr, r.Method, r.URL)
@@ -192,6 +227,11 @@ func handlerGetImage(w http.ResponseWriter, r *http.Request) {
 	writeHeaders(w)
 	layer_size := len(layer["layer"])
 	w.Header().Add("X-Docker-Size", strconv.Itoa(layer_size))
+	if vars["action"] == "layer" {
+		// honors the Range header of resumed downloads
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(layer["layer"]))
+		return
+	}
 	io.WriteString(w, layer[vars["action"]])
 }
 
@@ -329,6 +369,93 @@ func handlerSearch(w http.ResponseWriter, r *http.Request) {
 	writeResponse(w, result, 200)
 }
 
+func handlerGetV2Ping(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Docker-Distribution-API-Version", "registry/2.0")
+	writeResponse(w, map[string]string{}, 200)
+}
+
+func handlerGetV2Tags(w http.ResponseWriter, r *http.Request) {
+	repositoryName := mux.Vars(r)["repository"]
+	manifests, exists := testManifests[repositoryName]
+	if !exists {
+		apiError(w, "Repository not found", 404)
+		return
+	}
+	list := &tagsList{Name: repositoryName}
+	for reference := range manifests {
+		if !IsDigest(reference) {
+			list.Tags = append(list.Tags, reference)
+		}
+	}
+	writeResponse(w, list, 200)
+}
+
+func handlerV2Manifest(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	repositoryName := vars["repository"]
+	reference := vars["reference"]
+	if r.Method == "PUT" {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			apiError(w, fmt.Sprintf("Error: %s", err), 500)
+			return
+		}
+		manifests, exists := testManifests[repositoryName]
+		if !exists {
+			manifests = make(map[string]string)
+			testManifests[repositoryName] = manifests
+		}
+		digest := Digest(body)
+		manifests[reference] = string(body)
+		manifests[digest] = string(body)
+		w.Header().Add("Docker-Content-Digest", digest)
+		writeResponse(w, "", 202)
+		return
+	}
+	manifest, exists := testManifests[repositoryName][reference]
+	if !exists {
+		apiError(w, "Manifest not found", 404)
+		return
+	}
+	writeHeaders(w)
+	io.WriteString(w, manifest)
+}
+
+func handlerPostV2Upload(w http.ResponseWriter, r *http.Request) {
+	testUploads++
+	w.Header().Add("Location", fmt.Sprintf("%s/uploads/%d", strings.TrimSuffix(r.URL.Path, "/uploads/"), testUploads))
+	writeResponse(w, "", 202)
+}
+
+func handlerPutV2Upload(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		apiError(w, fmt.Sprintf("Error: %s", err), 500)
+		return
+	}
+	digest := r.URL.Query().Get("digest")
+	if Digest(body) != digest {
+		apiError(w, "Wrong digest", 400)
+		return
+	}
+	testBlobs[digest] = string(body)
+	writeResponse(w, "", 201)
+}
+
+func handlerGetV2Blob(w http.ResponseWriter, r *http.Request) {
+	blob, exists := testBlobs[mux.Vars(r)["digest"]]
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+	writeHeaders(w)
+	w.Header().Set("Content-Length", strconv.Itoa(len(blob)))
+	if r.Method == "HEAD" {
+		return
+	}
+	io.WriteString(w, blob)
+}
+
 func TestPing(t *testing.T) {
 	res, err := http.Get(makeURL("/v1/_ping"))
 	if err != nil {
@@ -1,9 +1,14 @@
 package registry
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/dotcloud/docker/utils"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"strconv"
 	"strings"
 	"testing"
 )
@@ -15,6 +20,7 @@ var (
 )
 
 func spawnTestRegistry(t *testing.T) *Registry {
+	resetTestRegistry()
 	authConfig := &AuthConfig{}
 	r, err := NewRegistry(authConfig, utils.NewHTTPRequestFactory(), makeURL("/v1/"))
 	if err != nil {
@@ -70,15 +76,29 @@ func TestGetRemoteImageJSON(t *testing.T) {
 
 func TestGetRemoteImageLayer(t *testing.T) {
 	r := spawnTestRegistry(t)
-	data, err := r.GetRemoteImageLayer(IMAGE_ID, makeURL("/v1/"), TOKEN)
+	data, offset, err := r.GetRemoteImageLayer(IMAGE_ID, makeURL("/v1/"), TOKEN, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
 	if data == nil {
 		t.Fatal("Expected non-nil data result")
 	}
+	data.Close()
+	assertEqual(t, offset, int64(0), "Expected the layer to start at 0")
 
-	_, err = r.GetRemoteImageLayer("abcdef", makeURL("/v1/"), TOKEN)
+	data, offset, err = r.GetRemoteImageLayer(IMAGE_ID, makeURL("/v1/"), TOKEN, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer data.Close()
+	assertEqual(t, offset, int64(10), "Expected the layer to be resumed at 10")
+	rest, err := ioutil.ReadAll(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, string(rest), testLayers[IMAGE_ID]["layer"][10:], "Unexpected content of the resumed layer")
+
+	_, _, err = r.GetRemoteImageLayer("abcdef", makeURL("/v1/"), TOKEN, 0)
 	if err == nil {
 		t.Fatal("Expected image not found error")
 	}
@@ -142,6 +162,21 @@ func TestPushImageLayerRegistry(t *testing.T) {
 	}
 }
 
+func TestSetTokenAuth(t *testing.T) {
+	req, err := http.NewRequest("GET", "http://mirror.local/v1/images/foo/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setTokenAuth(req, nil)
+	if auth := req.Header.Get("Authorization"); auth != "" {
+		t.Fatalf("Expected no authorization without a token, got %q", auth)
+	}
+	setTokenAuth(req, []string{"signature=123abc", "repository=\"foo/bar\""})
+	if auth := req.Header.Get("Authorization"); auth != `Token signature=123abc,repository="foo/bar"` {
+		t.Fatalf("Expected the token authorization, got %q", auth)
+	}
+}
+
 func TestResolveRepositoryName(t *testing.T) {
 	_, _, err := ResolveRepositoryName("https://github.com/dotcloud/docker")
 	assertEqual(t, err, ErrInvalidRepositoryName, "Expected error invalid repo name")
@@ -168,6 +203,26 @@ func TestResolveRepositoryName(t *testing.T) {
 	assertEqual(t, repo, "ubuntu-12.04-base", "Expected endpoint to be ubuntu-12.04-base")
 }
 
+func TestValidateMirror(t *testing.T) {
+	for val, expected := range map[string]string{
+		"http://mirror.local":          "http://mirror.local/v1/",
+		"https://mirror.local:5000/":   "https://mirror.local:5000/v1/",
+		"http://mirror.local:5000/v1/": "http://mirror.local:5000/v1/",
+	} {
+		ep, err := ValidateMirror(val)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, ep, expected, "Expected mirror endpoint to be "+expected)
+	}
+
+	for _, val := range []string{"mirror.local", "ftp://mirror.local", "http://", "http://mirror.local/v2/"} {
+		if _, err := ValidateMirror(val); err == nil {
+			t.Fatalf("Expected %s to be an invalid mirror", val)
+		}
+	}
+}
+
 func TestPushRegistryTag(t *testing.T) {
 	r := spawnTestRegistry(t)
 	err := r.PushRegistryTag("foo42/bar", IMAGE_ID, "stable", makeURL("/v1/"), TOKEN)
@@ -204,9 +259,77 @@ func TestPushImageJSONIndex(t *testing.T) {
 	}
 }
 
+func TestV2PushPull(t *testing.T) {
+	r := spawnTestRegistry(t)
+	if !r.SupportsV2(makeURL("/v1/")) {
+		t.Fatal("Expected the registry to support the v2 protocol")
+	}
+
+	blob := "layer content"
+	digest := Digest([]byte(blob))
+	exists, err := r.HeadV2Blob(makeURL("/v1/"), "foo42/v2", digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Fatalf("Blob %s should not exist yet", digest)
+	}
+	if err := r.PutV2Blob(makeURL("/v1/"), "foo42/v2", digest, strings.NewReader(blob)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.PutV2Blob(makeURL("/v1/"), "foo42/v2", Digest([]byte("other")), strings.NewReader(blob)); err == nil {
+		t.Fatal("Expected an error uploading a blob with a wrong digest")
+	}
+	if exists, err = r.HeadV2Blob(makeURL("/v1/"), "foo42/v2", digest); err != nil || !exists {
+		t.Fatalf("Blob %s should exist: %v", digest, err)
+	}
+
+	manifest := []byte(fmt.Sprintf(`{"schemaVersion":1,"name":"foo42/v2","tag":"latest","fsLayers":[{"blobSum":"%s"}],"history":[{"v1Compatibility":"{\"id\":\"%s\"}"}]}`, digest, IMAGE_ID))
+	manifestDigest, err := r.PutV2Manifest(makeURL("/v1/"), "foo42/v2", "latest", manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, manifestDigest, Digest(manifest), "Unexpected manifest digest")
+
+	tags, err := r.GetV2Tags(makeURL("/v1/"), "foo42/v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 1 || tags[0] != "latest" {
+		t.Fatalf("Expected tags [latest], got %v", tags)
+	}
+
+	for _, reference := range []string{"latest", manifestDigest} {
+		m, d, err := r.GetV2Manifest(makeURL("/v1/"), "foo42/v2", reference)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, d, manifestDigest, "Unexpected manifest digest")
+		assertEqual(t, m.FSLayers[0].BlobSum, digest, "Unexpected layer digest")
+	}
+
+	rc, size, err := r.GetV2Blob(makeURL("/v1/"), "foo42/v2", digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	assertEqual(t, size, len(blob), "Unexpected blob size")
+}
+
+func TestIsDigest(t *testing.T) {
+	if !IsDigest(Digest([]byte("foo"))) {
+		t.Fatal("Expected a sha256 digest to be valid")
+	}
+	for _, ref := range []string{"latest", "sha256:abc", "md5:" + strings.Repeat("a", 64)} {
+		if IsDigest(ref) {
+			t.Fatalf("Expected %s not to be a digest", ref)
+		}
+	}
+}
+
 func TestSearchRepositories(t *testing.T) {
 	r := spawnTestRegistry(t)
-	results, err := r.SearchRepositories("fakequery")
+	results, err := r.SearchRepositories("fakequery", 0, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -218,6 +341,114 @@ func TestSearchRepositories(t *testing.T) {
 	assertEqual(t, results.Results[0].StarCount, 42, "Expected 'fakeimage' a ot hae 42 stars")
 }
 
+func TestFilterSearchResults(t *testing.T) {
+	results := []SearchResult{
+		{Name: "official", IsOfficial: true, StarCount: 10},
+		{Name: "automated", IsTrusted: true, StarCount: 3},
+		{Name: "other", StarCount: 0},
+	}
+	for _, c := range []struct {
+		official, automated bool
+		stars               int
+		expected            []string
+	}{
+		{false, false, 0, []string{"official", "automated", "other"}},
+		{true, false, 0, []string{"official"}},
+		{false, true, 0, []string{"automated"}},
+		{false, false, 3, []string{"official", "automated"}},
+		{true, true, 0, nil},
+	} {
+		var names []string
+		for _, result := range filterSearchResults(results, c.official, c.automated, c.stars) {
+			names = append(names, result.Name)
+		}
+		if strings.Join(names, ",") != strings.Join(c.expected, ",") {
+			t.Errorf("Expected %v with official=%v automated=%v stars=%d, got %v", c.expected, c.official, c.automated, c.stars, names)
+		}
+	}
+}
+
+func TestSearchRepositoriesFiltersBeforePaginating(t *testing.T) {
+	// The registry returns pages of 2 results whatever the asked limit,
+	// the even ones are official
+	var all []SearchResult
+	for i := 0; i < 6; i++ {
+		all = append(all, SearchResult{Name: "repo" + strconv.Itoa(i), IsOfficial: i%2 == 0})
+	}
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/v1/search" {
+			http.NotFound(w, req)
+			return
+		}
+		requests++
+		page, _ := strconv.Atoi(req.URL.Query().Get("page"))
+		start, end := pageBounds(len(all), page, 2)
+		json.NewEncoder(w).Encode(&SearchResults{Query: "repo", NumResults: len(all), Results: all[start:end]})
+	}))
+	defer ts.Close()
+	r, err := NewRegistry(&AuthConfig{}, utils.NewHTTPRequestFactory(), ts.URL+"/v1/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := func(results []SearchResult) string {
+		var names []string
+		for _, result := range results {
+			names = append(names, result.Name)
+		}
+		return strings.Join(names, ",")
+	}
+	results, err := r.searchRepositories("repo", 2, 2, true, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names(results) != "repo4" {
+		t.Fatalf("Expected the second page of the official results to be repo4, got %s", names(results))
+	}
+	if requests != 3 {
+		t.Fatalf("Expected all the pages of the registry to be fetched, got %d requests", requests)
+	}
+
+	requests = 0
+	if results, err = r.searchRepositories("repo", 2, 2, false, false, 0); err != nil {
+		t.Fatal(err)
+	}
+	if names(results) != "repo2,repo3" || requests != 1 {
+		t.Fatalf("Expected the registry to paginate the unfiltered results, got %s in %d requests", names(results), requests)
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	items := []string{"a", "b", "c", "d", "e"}
+	for _, c := range []struct {
+		page, limit int
+		expected    string
+	}{
+		{0, 0, "abcde"},
+		{3, 0, "abcde"},
+		{0, 2, "ab"},
+		{2, 2, "cd"},
+		{3, 2, "e"},
+		{4, 2, ""},
+	} {
+		if page := strings.Join(paginate(items, c.page, c.limit), ""); page != c.expected {
+			t.Errorf("Expected %q for page %d of %d items, got %q", c.expected, c.page, c.limit, page)
+		}
+	}
+}
+
+func TestLookupRepository(t *testing.T) {
+	r := spawnTestRegistry(t)
+	results, err := r.lookupRepository("foo42/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results.NumResults != 1 || results.Results[0].Name != "foo42/bar" {
+		t.Fatalf("Expected to find foo42/bar, got %v", results.Results)
+	}
+}
+
 func TestValidRepositoryName(t *testing.T) {
 	if err := validateRepositoryName("docker/docker"); err != nil {
 		t.Fatal(err)
@@ -0,0 +1,276 @@
+package registry
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/dotcloud/docker/trust"
+	"github.com/dotcloud/docker/utils"
+)
+
+// The v2 protocol moves images as a manifest listing the layers, each layer
+// being a blob addressed by the sha256 digest of its content
+
+var validDigest = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+type FSLayer struct {
+	BlobSum string `json:"blobSum"`
+}
+
+type ManifestHistory struct {
+	V1Compatibility string `json:"v1Compatibility"`
+}
+
+// ManifestData lists the layers of an image, from the top-most one
+// to the base. History holds the json of the image of each layer.
+type ManifestData struct {
+	SchemaVersion int                `json:"schemaVersion"`
+	Name          string             `json:"name"`
+	Tag           string             `json:"tag"`
+	Architecture  string             `json:"architecture"`
+	FSLayers      []*FSLayer         `json:"fsLayers"`
+	History       []*ManifestHistory `json:"history"`
+	Signatures    []*trust.Signature `json:"signatures,omitempty"`
+}
+
+// Payload returns the signed content of the manifest,
+// the manifest without its signatures
+func (m *ManifestData) Payload() ([]byte, error) {
+	unsigned := *m
+	unsigned.Signatures = nil
+	return json.Marshal(&unsigned)
+}
+
+type tagsList struct {
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+}
+
+// IsDigest returns true if reference is a sha256 digest
+// rather than a tag
+func IsDigest(reference string) bool {
+	return validDigest.MatchString(reference)
+}
+
+// Digest returns the digest of data
+func Digest(data []byte) string {
+	h := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(h[:])
+}
+
+// v2Endpoint returns the endpoint of the v2 API of the registry
+// serving the v1 API at endpoint
+func v2Endpoint(endpoint string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(endpoint, "/"), "/v1") + "/v2/"
+}
+
+// v2RemoteName returns the name of a repository in the v2 API,
+// the official repositories live in the library namespace
+func v2RemoteName(remote string) string {
+	if !strings.Contains(remote, "/") {
+		return "library/" + remote
+	}
+	return remote
+}
+
+func (r *Registry) newV2Request(method, urlStr string, body io.Reader) (*http.Request, error) {
+	req, err := r.reqFactory.NewRequest(method, urlStr, body)
+	if err != nil {
+		return nil, err
+	}
+	if r.authConfig != nil && r.authConfig.Username != "" && req.Header.Get("Authorization") == "" {
+		req.SetBasicAuth(r.authConfig.Username, r.authConfig.Password)
+	}
+	return req, nil
+}
+
+// SupportsV2 returns true if the registry at the v1 endpoint
+// also speaks the v2 protocol
+func (r *Registry) SupportsV2(endpoint string) bool {
+	req, err := r.newV2Request("GET", v2Endpoint(endpoint), nil)
+	if err != nil {
+		return false
+	}
+	res, err := r.client.Do(req)
+	if err != nil {
+		utils.Debugf("Registry %s does not support the v2 protocol: %s", endpoint, err)
+		return false
+	}
+	res.Body.Close()
+	if res.StatusCode != 200 && res.StatusCode != 401 {
+		return false
+	}
+	return res.Header.Get("Docker-Distribution-API-Version") == "registry/2.0"
+}
+
+// GetV2Tags returns the tags of the remote repository
+func (r *Registry) GetV2Tags(endpoint, remote string) ([]string, error) {
+	req, err := r.newV2Request("GET", v2Endpoint(endpoint)+v2RemoteName(remote)+"/tags/list", nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return nil, utils.NewHTTPRequestError(fmt.Sprintf("Server error: Status %d while fetching the tags of %s", res.StatusCode, remote), res)
+	}
+	list := &tagsList{}
+	if err := json.NewDecoder(res.Body).Decode(list); err != nil {
+		return nil, err
+	}
+	return list.Tags, nil
+}
+
+// GetV2Manifest fetches the manifest of the remote repository for reference,
+// a tag or a digest, and returns it along with its digest. When reference is
+// a digest the content of the manifest is verified against it.
+func (r *Registry) GetV2Manifest(endpoint, remote, reference string) (*ManifestData, string, error) {
+	req, err := r.newV2Request("GET", v2Endpoint(endpoint)+v2RemoteName(remote)+"/manifests/"+reference, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	res, err := r.client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return nil, "", utils.NewHTTPRequestError(fmt.Sprintf("Server error: Status %d while fetching the manifest of %s:%s", res.StatusCode, remote, reference), res)
+	}
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	digest := Digest(data)
+	if IsDigest(reference) && digest != reference {
+		return nil, "", fmt.Errorf("Manifest of %s failed verification, expected digest %s got %s", remote, reference, digest)
+	}
+	manifest := &ManifestData{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, "", err
+	}
+	if len(manifest.FSLayers) == 0 || len(manifest.FSLayers) != len(manifest.History) {
+		return nil, "", fmt.Errorf("Invalid manifest for %s:%s, %d layers for %d images", remote, reference, len(manifest.FSLayers), len(manifest.History))
+	}
+	return manifest, digest, nil
+}
+
+// GetV2Blob returns the content of the blob with the given digest and its
+// size, it is up to the caller to verify the content while reading it
+func (r *Registry) GetV2Blob(endpoint, remote, digest string) (io.ReadCloser, int, error) {
+	req, err := r.newV2Request("GET", v2Endpoint(endpoint)+v2RemoteName(remote)+"/blobs/"+digest, nil)
+	if err != nil {
+		return nil, -1, err
+	}
+	res, err := r.client.Do(req)
+	if err != nil {
+		return nil, -1, err
+	}
+	if res.StatusCode != 200 {
+		res.Body.Close()
+		return nil, -1, utils.NewHTTPRequestError(fmt.Sprintf("Server error: Status %d while fetching blob %s", res.StatusCode, digest), res)
+	}
+	size, err := strconv.Atoi(res.Header.Get("Content-Length"))
+	if err != nil {
+		size = -1
+	}
+	return res.Body, size, nil
+}
+
+// HeadV2Blob returns true if the blob with the given digest
+// is already in the remote repository
+func (r *Registry) HeadV2Blob(endpoint, remote, digest string) (bool, error) {
+	req, err := r.newV2Request("HEAD", v2Endpoint(endpoint)+v2RemoteName(remote)+"/blobs/"+digest, nil)
+	if err != nil {
+		return false, err
+	}
+	res, err := r.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	res.Body.Close()
+	switch res.StatusCode {
+	case 200:
+		return true, nil
+	case 404:
+		return false, nil
+	}
+	return false, utils.NewHTTPRequestError(fmt.Sprintf("Server error: Status %d while checking blob %s", res.StatusCode, digest), res)
+}
+
+// PutV2Blob uploads blob, the registry verifies its content against digest
+func (r *Registry) PutV2Blob(endpoint, remote, digest string, blob io.Reader) error {
+	req, err := r.newV2Request("POST", v2Endpoint(endpoint)+v2RemoteName(remote)+"/blobs/uploads/", nil)
+	if err != nil {
+		return err
+	}
+	res, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode != 202 {
+		return utils.NewHTTPRequestError(fmt.Sprintf("Server error: Status %d while starting the upload of blob %s", res.StatusCode, digest), res)
+	}
+
+	location, err := res.Location()
+	if err != nil {
+		return fmt.Errorf("Missing upload location for blob %s: %s", digest, err)
+	}
+	query := location.Query()
+	query.Set("digest", digest)
+	location.RawQuery = query.Encode()
+
+	req, err = r.newV2Request("PUT", location.String(), blob)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	res, err = r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 201 {
+		errBody, _ := ioutil.ReadAll(res.Body)
+		return utils.NewHTTPRequestError(fmt.Sprintf("Server error: Status %d while uploading blob %s: %s", res.StatusCode, digest, errBody), res)
+	}
+	return nil
+}
+
+// PutV2Manifest uploads the manifest of the remote repository for tag
+// and returns its digest
+func (r *Registry) PutV2Manifest(endpoint, remote, tag string, manifest []byte) (string, error) {
+	req, err := r.newV2Request("PUT", v2Endpoint(endpoint)+v2RemoteName(remote)+"/manifests/"+url.QueryEscape(tag), bytes.NewReader(manifest))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := r.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 201 && res.StatusCode != 202 {
+		errBody, _ := ioutil.ReadAll(res.Body)
+		return "", utils.NewHTTPRequestError(fmt.Sprintf("Server error: Status %d while uploading the manifest of %s:%s: %s", res.StatusCode, remote, tag, errBody), res)
+	}
+	digest := Digest(manifest)
+	if remoteDigest := res.Header.Get("Docker-Content-Digest"); remoteDigest != "" && remoteDigest != digest {
+		return "", fmt.Errorf("Manifest of %s:%s stored with digest %s, expected %s", remote, tag, remoteDigest, digest)
+	}
+	return digest, nil
+}
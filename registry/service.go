@@ -1,7 +1,10 @@
 package registry
 
 import (
+	"sort"
+
 	"github.com/dotcloud/docker/engine"
+	"github.com/dotcloud/docker/utils"
 )
 
 // Service exposes registry capabilities in the standard Engine
@@ -9,7 +12,8 @@ import (
 // following calls:
 //
 //  'auth': Authenticate against the public registry
-//  'search': Search for images on the public registry
+//  'search': Search for images on the public registry or a private one
+//  'tags': List the tags of a repository in its registry
 //  'pull': Download images from any registry (TODO)
 //  'push': Upload images to any registry (TODO)
 type Service struct {
@@ -25,6 +29,7 @@ func NewService() *Service {
 func (s *Service) Install(eng *engine.Engine) error {
 	eng.Register("auth", s.Auth)
 	eng.Register("search", s.Search)
+	eng.Register("tags", s.Tags)
 	return nil
 }
 
@@ -66,6 +71,15 @@ func (s *Service) Auth(job *engine.Job) engine.Status {
 //	'metaHeaders': extra HTTP headers to include in the request to the registry.
 //		The headers should be passed as a json-encoded dictionary.
 //
+//	'registry': the host of a private registry to search instead of the public one.
+//		A registry without a search only finds the repository named TERM.
+//
+//	'page', 'limit': the page of the results to return and the number of
+//		results by page.
+//
+//	'official', 'automated', 'stars': only return the official images,
+//		the automated builds, or the images with at least that many stars.
+//
 // Output:
 //	Results are sent as a collection of structured messages (using engine.Table).
 //	Each result is sent as a separate message.
@@ -82,16 +96,23 @@ func (s *Service) Search(job *engine.Job) engine.Status {
 	job.GetenvJson("authConfig", authConfig)
 	job.GetenvJson("metaHeaders", metaHeaders)
 
-	r, err := NewRegistry(authConfig, HTTPRequestFactory(metaHeaders), IndexServerAddress())
+	endpoint := IndexServerAddress()
+	if hostname := job.Getenv("registry"); hostname != "" && hostname != IndexServerAddress() {
+		var err error
+		if endpoint, err = ExpandAndVerifyRegistryUrl(hostname); err != nil {
+			return job.Error(err)
+		}
+	}
+	r, err := NewRegistry(authConfig, HTTPRequestFactory(metaHeaders), endpoint)
 	if err != nil {
 		return job.Error(err)
 	}
-	results, err := r.SearchRepositories(term)
+	results, err := r.searchRepositories(term, job.GetenvInt("page"), job.GetenvInt("limit"), job.GetenvBool("official"), job.GetenvBool("automated"), job.GetenvInt("stars"))
 	if err != nil {
 		return job.Error(err)
 	}
 	outs := engine.NewTable("star_count", 0)
-	for _, result := range results.Results {
+	for _, result := range results {
 		out := &engine.Env{}
 		out.Import(result)
 		outs.Add(out)
@@ -102,3 +123,137 @@ func (s *Service) Search(job *engine.Job) engine.Status {
 	}
 	return engine.StatusOK
 }
+
+// Tags lists the tags of a repository in its registry.
+//
+// Argument syntax: tags NAME
+//
+// Option environment:
+//	'authConfig': json-encoded credentials to authenticate against the registry.
+//
+//	'metaHeaders': extra HTTP headers to include in the request to the registry.
+//		The headers should be passed as a json-encoded dictionary.
+//
+//	'page', 'limit': the page of the tags to return and the number of
+//		tags by page.
+//
+// Output:
+//	The tags are sent as a collection of structured messages (using engine.Table),
+//	ordered by name. Each message holds the 'Tag' and the 'Id' of its image, the
+//	registries speaking the v2 protocol do not tell the ids.
+func (s *Service) Tags(job *engine.Job) engine.Status {
+	if n := len(job.Args); n != 1 {
+		return job.Errorf("Usage: %s NAME", job.Name)
+	}
+	var (
+		metaHeaders = map[string][]string{}
+		authConfig  = &AuthConfig{}
+	)
+	job.GetenvJson("authConfig", authConfig)
+	job.GetenvJson("metaHeaders", metaHeaders)
+
+	hostname, remoteName, err := ResolveRepositoryName(job.Args[0])
+	if err != nil {
+		return job.Error(err)
+	}
+	endpoint := IndexServerAddress()
+	if hostname != IndexServerAddress() {
+		if endpoint, err = ExpandAndVerifyRegistryUrl(hostname); err != nil {
+			return job.Error(err)
+		}
+	}
+	r, err := NewRegistry(authConfig, HTTPRequestFactory(metaHeaders), endpoint)
+	if err != nil {
+		return job.Error(err)
+	}
+	tags, err := r.ListTags(remoteName)
+	if err != nil {
+		return job.Error(err)
+	}
+
+	names := make([]string, 0, len(tags))
+	for tag := range tags {
+		names = append(names, tag)
+	}
+	sort.Strings(names)
+	outs := engine.NewTable("", 0)
+	for _, tag := range paginate(names, job.GetenvInt("page"), job.GetenvInt("limit")) {
+		out := &engine.Env{}
+		out.Set("Tag", tag)
+		out.Set("Id", tags[tag])
+		outs.Add(out)
+	}
+	if _, err := outs.WriteListTo(job.Stdout); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+// searchRepositories returns the page-th page of the results of term.
+// The registries cannot filter their results: when a filter is set, all
+// the results are fetched and filtered before being paginated.
+func (r *Registry) searchRepositories(term string, page, limit int, official, automated bool, stars int) ([]SearchResult, error) {
+	filtered := official || automated || stars > 0
+	var (
+		results *SearchResults
+		err     error
+	)
+	if filtered {
+		results, err = r.searchAllRepositories(term)
+	} else {
+		results, err = r.SearchRepositories(term, page, limit)
+	}
+	if jerr, ok := err.(*utils.JSONError); ok && jerr.Code == 404 && r.indexEndpoint != IndexServerAddress() {
+		utils.Debugf("Registry %s has no search, looking up the repository %s", r.indexEndpoint, term)
+		results, err = r.lookupRepository(term)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !filtered {
+		return results.Results, nil
+	}
+	items := filterSearchResults(results.Results, official, automated, stars)
+	start, end := pageBounds(len(items), page, limit)
+	return items[start:end], nil
+}
+
+// filterSearchResults returns the results that are official and automated
+// builds when asked, and have at least stars stars
+func filterSearchResults(results []SearchResult, official, automated bool, stars int) []SearchResult {
+	var filtered []SearchResult
+	for _, result := range results {
+		if (official && !result.IsOfficial) || (automated && !result.IsTrusted) || result.StarCount < stars {
+			continue
+		}
+		filtered = append(filtered, result)
+	}
+	return filtered
+}
+
+// paginate returns the page-th page of items, pages being limit items
+// long and numbered from 1. All the items are returned if limit is 0.
+func paginate(items []string, page, limit int) []string {
+	start, end := pageBounds(len(items), page, limit)
+	return items[start:end]
+}
+
+// pageBounds returns the bounds of the page-th page of n items, as
+// paginate numbers them
+func pageBounds(n, page, limit int) (start, end int) {
+	if limit <= 0 {
+		return 0, n
+	}
+	if page <= 0 {
+		page = 1
+	}
+	start = (page - 1) * limit
+	if start >= n {
+		return n, n
+	}
+	end = start + limit
+	if end > n {
+		end = n
+	}
+	return start, end
+}
@@ -0,0 +1,329 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dotcloud/docker/utils"
+)
+
+// A registry behind a token service answers the requests without a token
+// with a challenge,
+//
+//   WWW-Authenticate: Bearer realm="https://auth.example.com/token",service="registry.example.com"
+//
+// the client then gets a token for the scope of its request from the realm,
+// authenticating with its credentials, and sends it as a Bearer token.
+
+const (
+	// The lifetime of a token when the token service does not give one
+	defaultTokenExpiration = 60 * time.Second
+	// The delay before pinging again a registry which could not be pinged
+	failedPingExpiration = 30 * time.Second
+)
+
+type bearerChallenge struct {
+	realm   string
+	service string
+	// the registry is insecure, its realm may be served over plain HTTP
+	insecure bool
+}
+
+type bearerToken struct {
+	token   string
+	expires time.Time
+}
+
+type tokenResponse struct {
+	Token       string `json:"token"`
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// flight is a ping or a token fetch shared by the concurrent requests
+// waiting for its result
+type flight struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+// TokenAuthDecorator authenticates the requests to the registries
+// challenging for bearer tokens. The challenge of a registry is learnt
+// from its /v2/ endpoint, the tokens are cached until they expire.
+// The lock only guards the caches, the registries and the token services
+// are reached without holding it.
+type TokenAuthDecorator struct {
+	client     *http.Client
+	authConfig *AuthConfig
+
+	sync.Mutex
+	// the challenge of each scheme://host, nil for the hosts without one
+	challenges map[string]*bearerChallenge
+	// when the hosts which could not be pinged may be pinged again
+	failedPings map[string]time.Time
+	// the tokens by realm, service and scope
+	tokens map[string]*bearerToken
+	// the pings and the token fetches running, by key
+	flights map[string]*flight
+}
+
+func NewTokenAuthDecorator(client *http.Client, authConfig *AuthConfig) utils.HTTPRequestDecorator {
+	return &TokenAuthDecorator{
+		client:      client,
+		authConfig:  authConfig,
+		challenges:  make(map[string]*bearerChallenge),
+		failedPings: make(map[string]time.Time),
+		tokens:      make(map[string]*bearerToken),
+		flights:     make(map[string]*flight),
+	}
+}
+
+// ChangeRequest sets the token for the requests to the v2 API and to the
+// search of a registry challenging for one, replacing any basic auth the
+// registry would refuse. Other requests are left as is.
+func (d *TokenAuthDecorator) ChangeRequest(req *http.Request) (*http.Request, error) {
+	if req == nil {
+		return req, nil
+	}
+	if !strings.HasPrefix(req.URL.Path, "/v2/") && !strings.HasSuffix(req.URL.Path, "/search") {
+		return req, nil
+	}
+
+	challenge, err := d.challenge(req.URL.Scheme + "://" + req.URL.Host)
+	if err != nil {
+		return nil, err
+	}
+	if challenge == nil {
+		return req, nil
+	}
+	token, err := d.token(challenge, tokenScope(req))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req, nil
+}
+
+// do runs fetch once for all the concurrent callers with the same key,
+// the lock is not held while it runs
+func (d *TokenAuthDecorator) do(key string, fetch func() (interface{}, error)) (interface{}, error) {
+	d.Lock()
+	f, running := d.flights[key]
+	if !running {
+		f = &flight{done: make(chan struct{})}
+		d.flights[key] = f
+	}
+	d.Unlock()
+	if running {
+		<-f.done
+		return f.value, f.err
+	}
+
+	f.value, f.err = fetch()
+	d.Lock()
+	delete(d.flights, key)
+	d.Unlock()
+	close(f.done)
+	return f.value, f.err
+}
+
+// challenge returns the bearer challenge of the registry at endpoint,
+// nil if it does not authenticate with tokens or could not be pinged
+func (d *TokenAuthDecorator) challenge(endpoint string) (*bearerChallenge, error) {
+	d.Lock()
+	challenge, exists := d.challenges[endpoint]
+	retry, failed := d.failedPings[endpoint]
+	d.Unlock()
+	if exists {
+		return challenge, nil
+	}
+	if failed && time.Now().Before(retry) {
+		return nil, nil
+	}
+
+	value, err := d.do("ping "+endpoint, func() (interface{}, error) {
+		challenge, err := pingBearerChallenge(d.client, endpoint+"/v2/")
+		d.Lock()
+		defer d.Unlock()
+		if err != nil {
+			// The request itself will tell what is wrong with the registry
+			utils.Debugf("Cannot get the challenge of %s: %s", endpoint, err)
+			d.failedPings[endpoint] = time.Now().Add(failedPingExpiration)
+			return (*bearerChallenge)(nil), nil
+		}
+		delete(d.failedPings, endpoint)
+		d.challenges[endpoint] = challenge
+		return challenge, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*bearerChallenge), nil
+}
+
+// pingBearerChallenge returns the bearer challenge of the v2 endpoint,
+// nil if the registry does not authenticate with tokens
+func pingBearerChallenge(client *http.Client, endpoint string) (*bearerChallenge, error) {
+	res, err := client.Get(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+	if res.StatusCode != 401 {
+		return nil, nil
+	}
+	challenge := parseBearerChallenge(res.Header.Get("WWW-Authenticate"))
+	if challenge != nil {
+		if u, err := url.Parse(endpoint); err == nil {
+			challenge.insecure = isInsecure(u.Host)
+		}
+		utils.Debugf("Registry %s authenticates with tokens from %s", endpoint, challenge.realm)
+	}
+	return challenge, nil
+}
+
+// token returns a token for scope, fetching a new one
+// when there is none in the cache or it has expired
+func (d *TokenAuthDecorator) token(challenge *bearerChallenge, scope string) (string, error) {
+	key := challenge.realm + " " + challenge.service + " " + scope
+	d.Lock()
+	cached, exists := d.tokens[key]
+	d.Unlock()
+	if exists && time.Now().Before(cached.expires) {
+		return cached.token, nil
+	}
+
+	value, err := d.do("token "+key, func() (interface{}, error) {
+		token, err := fetchToken(d.client, challenge, scope, d.authConfig)
+		if err != nil {
+			return nil, err
+		}
+		d.Lock()
+		d.tokens[key] = token
+		d.Unlock()
+		return token, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return value.(*bearerToken).token, nil
+}
+
+func fetchToken(client *http.Client, challenge *bearerChallenge, scope string, authConfig *AuthConfig) (*bearerToken, error) {
+	u, err := url.Parse(challenge.realm)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid token realm %s: %s", challenge.realm, err)
+	}
+	// The credentials are only sent in clear to the realm of an insecure registry
+	if u.Scheme != "https" && !challenge.insecure {
+		return nil, fmt.Errorf("Refusing the token realm %s over plain HTTP, its registry is not insecure", challenge.realm)
+	}
+	query := u.Query()
+	if challenge.service != "" {
+		query.Set("service", challenge.service)
+	}
+	if scope != "" {
+		query.Set("scope", scope)
+	}
+	if authConfig != nil && authConfig.Username != "" {
+		query.Set("account", authConfig.Username)
+	}
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if authConfig != nil && authConfig.Username != "" {
+		req.SetBasicAuth(authConfig.Username, authConfig.Password)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == 401 {
+		return nil, fmt.Errorf("Wrong login/password for the token service %s", challenge.realm)
+	}
+	if res.StatusCode != 200 {
+		return nil, utils.NewHTTPRequestError(fmt.Sprintf("Server error: Status %d while fetching a token from %s", res.StatusCode, challenge.realm), res)
+	}
+
+	tr := &tokenResponse{}
+	if err := json.NewDecoder(res.Body).Decode(tr); err != nil {
+		return nil, fmt.Errorf("Invalid token from %s: %s", challenge.realm, err)
+	}
+	token := &bearerToken{token: tr.Token, expires: time.Now().Add(defaultTokenExpiration)}
+	if token.token == "" {
+		token.token = tr.AccessToken
+	}
+	if token.token == "" {
+		return nil, fmt.Errorf("Empty token from %s", challenge.realm)
+	}
+	if tr.ExpiresIn > 0 {
+		token.expires = time.Now().Add(time.Duration(tr.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
+
+// tokenScope returns the scope a request needs, the access to the repository
+// of a v2 request, pull to read it and push to change it
+func tokenScope(req *http.Request) string {
+	if !strings.HasPrefix(req.URL.Path, "/v2/") {
+		return ""
+	}
+	name := strings.TrimPrefix(req.URL.Path, "/v2/")
+	for _, route := range []string{"/manifests/", "/blobs/", "/tags/"} {
+		if i := strings.LastIndex(name, route); i > 0 {
+			name = name[:i]
+			actions := "pull"
+			if req.Method != "GET" && req.Method != "HEAD" {
+				actions = "pull,push"
+			}
+			return "repository:" + name + ":" + actions
+		}
+	}
+	return ""
+}
+
+// parseBearerChallenge parses the value of a WWW-Authenticate header,
+// returning nil unless it is a bearer challenge with a realm
+func parseBearerChallenge(header string) *bearerChallenge {
+	parts := strings.SplitN(strings.TrimSpace(header), " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return nil
+	}
+	params := make(map[string]string)
+	for s := parts[1]; s != ""; {
+		s = strings.TrimLeft(s, " ,")
+		eq := strings.Index(s, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = s[eq+1:]
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			end := strings.Index(s[1:], `"`)
+			if end < 0 {
+				return nil
+			}
+			value, s = s[1:end+1], s[end+2:]
+		} else if comma := strings.Index(s, ","); comma >= 0 {
+			value, s = s[:comma], s[comma:]
+		} else {
+			value, s = s, ""
+		}
+		params[key] = strings.TrimSpace(value)
+	}
+	if params["realm"] == "" {
+		return nil
+	}
+	return &bearerChallenge{realm: params["realm"], service: params["service"]}
+}
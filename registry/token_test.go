@@ -0,0 +1,283 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dotcloud/docker/utils"
+)
+
+func TestParseBearerChallenge(t *testing.T) {
+	for header, expected := range map[string]*bearerChallenge{
+		`Bearer realm="https://auth.example.com/token",service="registry.example.com"`:                          {realm: "https://auth.example.com/token", service: "registry.example.com"},
+		`Bearer realm="https://auth.example.com/token", scope="repository:foo/bar:pull,push", service=registry`: {realm: "https://auth.example.com/token", service: "registry"},
+		`bearer realm=https://auth.example.com/token`:                                                           {realm: "https://auth.example.com/token"},
+		`Basic realm="registry"`:                nil,
+		`Bearer service="registry.example.com"`: nil,
+		``:                                      nil,
+	} {
+		challenge := parseBearerChallenge(header)
+		if (challenge == nil) != (expected == nil) || (challenge != nil && *challenge != *expected) {
+			t.Errorf("Expected %v for %q, got %v", expected, header, challenge)
+		}
+	}
+}
+
+// newTestTokenServers starts a token service and a registry requiring its
+// tokens, the token service counts the tokens it hands out by scope
+func newTestTokenServers() (*httptest.Server, *httptest.Server, map[string]int) {
+	issued := make(map[string]int)
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := parseBasicAuth(r); !ok || user != "docker-user" || pass != "docker-pass" {
+			w.WriteHeader(401)
+			return
+		}
+		if r.URL.Query().Get("service") != "test-registry" {
+			w.WriteHeader(400)
+			return
+		}
+		scope := r.URL.Query().Get("scope")
+		issued[scope]++
+		json.NewEncoder(w).Encode(&tokenResponse{Token: "token " + scope, ExpiresIn: 300})
+	}))
+	reg := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token "+tokenScope(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+auth.URL+`/token",service="test-registry"`)
+			w.WriteHeader(401)
+			return
+		}
+		w.WriteHeader(200)
+	}))
+	return auth, reg, issued
+}
+
+func parseBasicAuth(r *http.Request) (string, string, bool) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Basic ") {
+		return "", "", false
+	}
+	username, password, err := decodeAuth(strings.TrimPrefix(header, "Basic "))
+	return username, password, err == nil
+}
+
+func TestTokenAuthDecorator(t *testing.T) {
+	auth, reg, issued := newTestTokenServers()
+	defer auth.Close()
+	defer reg.Close()
+
+	decorator := NewTokenAuthDecorator(&http.Client{}, &AuthConfig{Username: "docker-user", Password: "docker-pass"})
+	factory := utils.NewHTTPRequestFactory(decorator)
+	do := func(method, path string) {
+		req, err := factory.NewRequest(method, reg.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != 200 {
+			t.Fatalf("Expected %s %s to be authorized, got status %d", method, path, res.StatusCode)
+		}
+	}
+
+	do("GET", "/v2/foo/bar/manifests/latest")
+	do("HEAD", "/v2/foo/bar/blobs/sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae")
+	if n := issued["repository:foo/bar:pull"]; n != 1 {
+		t.Fatalf("Expected the pull token to be fetched once, got %d", n)
+	}
+	do("PUT", "/v2/foo/bar/manifests/latest")
+	if n := issued["repository:foo/bar:pull,push"]; n != 1 {
+		t.Fatalf("Expected a push token, got %d", n)
+	}
+
+	// An expired token is refreshed
+	for _, token := range decorator.(*TokenAuthDecorator).tokens {
+		token.expires = time.Now().Add(-time.Second)
+	}
+	do("GET", "/v2/foo/bar/tags/list")
+	if n := issued["repository:foo/bar:pull"]; n != 2 {
+		t.Fatalf("Expected the expired token to be refreshed, got %d fetches", n)
+	}
+
+	// Wrong credentials are refused by the token service
+	decorator = NewTokenAuthDecorator(&http.Client{}, &AuthConfig{Username: "docker-user", Password: "wrong"})
+	req, err := http.NewRequest("GET", reg.URL+"/v2/foo/bar/manifests/latest", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decorator.ChangeRequest(req); err == nil {
+		t.Fatal("Expected an error with wrong credentials")
+	}
+}
+
+func TestTokenAuthDecoratorConcurrentRequests(t *testing.T) {
+	var (
+		lock    sync.Mutex
+		pings   int
+		fetches = make(map[string]int)
+		release = make(chan struct{})
+	)
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scope := r.URL.Query().Get("scope")
+		lock.Lock()
+		fetches[scope]++
+		lock.Unlock()
+		if strings.HasPrefix(scope, "repository:slow/") {
+			<-release
+		}
+		json.NewEncoder(w).Encode(&tokenResponse{Token: "token " + scope})
+	}))
+	defer auth.Close()
+	reg := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		pings++
+		lock.Unlock()
+		w.Header().Set("WWW-Authenticate", `Bearer realm="`+auth.URL+`/token",service="test-registry"`)
+		w.WriteHeader(401)
+	}))
+	defer reg.Close()
+	fetched := func(scope string) int {
+		lock.Lock()
+		defer lock.Unlock()
+		return fetches[scope]
+	}
+
+	decorator := NewTokenAuthDecorator(&http.Client{}, nil)
+	change := func(repository string) error {
+		req, err := http.NewRequest("GET", reg.URL+"/v2/"+repository+"/manifests/latest", nil)
+		if err != nil {
+			return err
+		}
+		_, err = decorator.ChangeRequest(req)
+		return err
+	}
+	if err := change("fast/app"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The concurrent requests for the same scope share a single fetch
+	errs := make(chan error, 3)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			errs <- change("slow/app")
+		}()
+	}
+	for fetched("repository:slow/app:pull") == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// which does not block the requests with a cached token
+	done := make(chan error)
+	go func() {
+		done <- change("fast/app")
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a cached token to be used while another one is fetched")
+	}
+
+	close(release)
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := fetched("repository:slow/app:pull"); n != 1 {
+		t.Fatalf("Expected the token to be fetched once, got %d", n)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if pings != 1 {
+		t.Fatalf("Expected the registry to be pinged once, got %d", pings)
+	}
+}
+
+type failingTransport struct {
+	sync.Mutex
+	requests int
+}
+
+func (t *failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.Lock()
+	defer t.Unlock()
+	t.requests++
+	return nil, errors.New("connection refused")
+}
+
+func TestTokenAuthDecoratorCachesFailedPings(t *testing.T) {
+	transport := &failingTransport{}
+	decorator := NewTokenAuthDecorator(&http.Client{Transport: transport}, nil)
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest("GET", "https://registry.example.com/v2/foo/bar/manifests/latest", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := decorator.ChangeRequest(req); err != nil {
+			t.Fatal(err)
+		}
+		if req.Header.Get("Authorization") != "" {
+			t.Fatal("Expected no token for a registry which could not be pinged")
+		}
+	}
+	if transport.requests != 1 {
+		t.Fatalf("Expected a failed ping to be cached, got %d pings", transport.requests)
+	}
+
+	// The registry is pinged again once the failure expired
+	decorator.(*TokenAuthDecorator).failedPings["https://registry.example.com"] = time.Now().Add(-time.Second)
+	req, err := http.NewRequest("GET", "https://registry.example.com/v2/foo/bar/manifests/latest", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decorator.ChangeRequest(req); err != nil {
+		t.Fatal(err)
+	}
+	if transport.requests != 2 {
+		t.Fatalf("Expected the registry to be pinged again, got %d pings", transport.requests)
+	}
+}
+
+func TestFetchTokenRefusesPlainHTTPRealm(t *testing.T) {
+	transport := &failingTransport{}
+	challenge := &bearerChallenge{realm: "http://auth.example.com/token", service: "registry.example.com"}
+	if _, err := fetchToken(&http.Client{Transport: transport}, challenge, "", &AuthConfig{Username: "docker-user", Password: "docker-pass"}); err == nil {
+		t.Fatal("Expected a plain HTTP realm of a secure registry to be refused")
+	}
+	if transport.requests != 0 {
+		t.Fatal("Expected the credentials not to be sent to a plain HTTP realm")
+	}
+
+	challenge.insecure = true
+	if _, err := fetchToken(&http.Client{Transport: transport}, challenge, "", nil); err == nil || transport.requests != 1 {
+		t.Fatalf("Expected the plain HTTP realm of an insecure registry to be requested, got %v", err)
+	}
+}
+
+func TestTokenLogin(t *testing.T) {
+	auth, reg, _ := newTestTokenServers()
+	defer auth.Close()
+	defer reg.Close()
+
+	status, err := Login(&AuthConfig{Username: "docker-user", Password: "docker-pass", ServerAddress: reg.URL + "/v1/"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != "Login Succeeded" {
+		t.Fatalf("Unexpected login status %s", status)
+	}
+	if _, err := Login(&AuthConfig{Username: "docker-user", Password: "wrong", ServerAddress: reg.URL + "/v1/"}, nil); err == nil {
+		t.Fatal("Expected the login to fail with wrong credentials")
+	}
+}
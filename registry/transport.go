@@ -0,0 +1,228 @@
+package registry
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/dotcloud/docker/utils"
+)
+
+var (
+	// CertsDir holds a directory per registry, named after its host and
+	// port, with the CA bundles to trust (*.crt) and the client certificate
+	// pairs to present (*.cert and *.key) when talking to that registry
+	CertsDir = "/etc/docker/certs.d"
+
+	// registries reachable over plain HTTP or HTTPS with an unverified
+	// certificate, the loopback is always part of them
+	insecureHosts = map[string]bool{}
+	insecureNets  = []*net.IPNet{loopbackNet}
+	loopbackNet   = &net.IPNet{IP: net.IPv4(127, 0, 0, 0), Mask: net.CIDRMask(8, 32)}
+	insecureLock  sync.RWMutex
+
+	lookupIP = net.LookupIP
+)
+
+// ValidateInsecureRegistry checks that val is either a CIDR or
+// a host, with an optional port
+func ValidateInsecureRegistry(val string) (string, error) {
+	if strings.Contains(val, "://") {
+		return "", fmt.Errorf("Insecure registry %s should not contain a scheme", val)
+	}
+	if _, _, err := net.ParseCIDR(val); err == nil {
+		return val, nil
+	}
+	if strings.ContainsAny(val, "/ ") || val == "" {
+		return "", fmt.Errorf("%s is neither a CIDR nor a registry host", val)
+	}
+	return val, nil
+}
+
+// SetInsecureRegistries sets the CIDRs and the hosts of the registries
+// which are allowed over plain HTTP or with an unverified certificate
+func SetInsecureRegistries(registries []string) error {
+	var (
+		hosts = map[string]bool{}
+		nets  = []*net.IPNet{loopbackNet}
+	)
+	for _, r := range registries {
+		if _, ipNet, err := net.ParseCIDR(r); err == nil {
+			nets = append(nets, ipNet)
+			continue
+		}
+		if _, err := ValidateInsecureRegistry(r); err != nil {
+			return err
+		}
+		hosts[r] = true
+	}
+
+	insecureLock.Lock()
+	insecureHosts, insecureNets = hosts, nets
+	insecureLock.Unlock()
+	return nil
+}
+
+// isInsecure returns true when the registry at hostname, with an optional
+// port, was allowed over plain HTTP
+func isInsecure(hostname string) bool {
+	insecureLock.RLock()
+	defer insecureLock.RUnlock()
+
+	if insecureHosts[hostname] {
+		return true
+	}
+	host, _, err := net.SplitHostPort(hostname)
+	if err != nil {
+		host = hostname
+	}
+	if insecureHosts[host] {
+		return true
+	}
+
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		if ips, err = lookupIP(host); err != nil {
+			return false
+		}
+	}
+	for _, ip := range ips {
+		for _, ipNet := range insecureNets {
+			if ipNet.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// newTLSConfig loads the certificates of the registry at hostname
+// from CertsDir
+func newTLSConfig(hostname string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		// Avoid fallback to SSL protocols < TLS1.0
+		MinVersion:         tls.VersionTLS10,
+		InsecureSkipVerify: isInsecure(hostname),
+	}
+
+	dir := path.Join(CertsDir, hostname)
+	fs, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return tlsConfig, nil
+		}
+		return nil, err
+	}
+
+	for _, f := range fs {
+		switch {
+		case strings.HasSuffix(f.Name(), ".crt"):
+			if tlsConfig.RootCAs == nil {
+				tlsConfig.RootCAs = x509.NewCertPool()
+			}
+			utils.Debugf("crt: %s", path.Join(dir, f.Name()))
+			data, err := ioutil.ReadFile(path.Join(dir, f.Name()))
+			if err != nil {
+				return nil, err
+			}
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(data) {
+				return nil, fmt.Errorf("No certificate found in %s", path.Join(dir, f.Name()))
+			}
+		case strings.HasSuffix(f.Name(), ".cert"):
+			certName := f.Name()
+			keyName := certName[:len(certName)-5] + ".key"
+			utils.Debugf("cert: %s", path.Join(dir, certName))
+			if _, err := os.Stat(path.Join(dir, keyName)); err != nil {
+				return nil, fmt.Errorf("Missing key %s for certificate %s", keyName, certName)
+			}
+			cert, err := tls.LoadX509KeyPair(path.Join(dir, certName), path.Join(dir, keyName))
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.Certificates = append(tlsConfig.Certificates, cert)
+		case strings.HasSuffix(f.Name(), ".key"):
+			keyName := f.Name()
+			certName := keyName[:len(keyName)-4] + ".cert"
+			if _, err := os.Stat(path.Join(dir, certName)); err != nil {
+				return nil, fmt.Errorf("Missing certificate %s for key %s", certName, keyName)
+			}
+		}
+	}
+	return tlsConfig, nil
+}
+
+func newTransport(hostname string) (*http.Transport, error) {
+	tlsConfig, err := newTLSConfig(hostname)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: tlsConfig,
+	}, nil
+}
+
+// hostTransport is an http.RoundTripper using the TLS configuration of
+// the host of each request, plain HTTP is refused unless the host is
+// an insecure registry. Whether a host is insecure is resolved once.
+type hostTransport struct {
+	disableKeepAlives bool
+
+	sync.Mutex
+	transports map[string]*http.Transport
+	insecure   map[string]bool
+}
+
+func newHostTransport(disableKeepAlives bool) *hostTransport {
+	return &hostTransport{
+		disableKeepAlives: disableKeepAlives,
+		transports:        make(map[string]*http.Transport),
+		insecure:          make(map[string]bool),
+	}
+}
+
+func (t *hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "http" && !t.isInsecure(req.URL.Host) {
+		return nil, fmt.Errorf("Refusing plain HTTP to %s, add `--insecure-registry %s` to the daemon's arguments to allow it", req.URL.Host, req.URL.Host)
+	}
+	tr, err := t.transport(req.URL.Host)
+	if err != nil {
+		return nil, err
+	}
+	return tr.RoundTrip(req)
+}
+
+func (t *hostTransport) transport(hostname string) (*http.Transport, error) {
+	t.Lock()
+	defer t.Unlock()
+
+	if tr, exists := t.transports[hostname]; exists {
+		return tr, nil
+	}
+	tr, err := newTransport(hostname)
+	if err != nil {
+		return nil, err
+	}
+	tr.DisableKeepAlives = t.disableKeepAlives
+	t.transports[hostname] = tr
+	return tr, nil
+}
+
+func (t *hostTransport) isInsecure(hostname string) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	insecure, exists := t.insecure[hostname]
+	if !exists {
+		insecure = isInsecure(hostname)
+		t.insecure[hostname] = insecure
+	}
+	return insecure
+}
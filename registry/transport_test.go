@@ -0,0 +1,90 @@
+package registry
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestIsInsecure(t *testing.T) {
+	if err := SetInsecureRegistries([]string{"10.1.0.0/16", "myregistry:5000", "otherregistry"}); err != nil {
+		t.Fatal(err)
+	}
+	defer SetInsecureRegistries(nil)
+
+	for hostname, expected := range map[string]bool{
+		"10.1.2.3:5000":      true,
+		"10.2.0.1":           false,
+		"127.0.0.1:5000":     true,
+		"myregistry:5000":    true,
+		"myregistry:5001":    false,
+		"otherregistry:5000": true,
+	} {
+		if insecure := isInsecure(hostname); insecure != expected {
+			t.Fatalf("Expected insecure to be %t for %s, got %t", expected, hostname, insecure)
+		}
+	}
+
+	if err := SetInsecureRegistries([]string{"http://myregistry"}); err == nil {
+		t.Fatal("Expected an error for an insecure registry with a scheme")
+	}
+}
+
+func TestHostTransportRefusesPlainHTTP(t *testing.T) {
+	client := &http.Client{Transport: newHostTransport(true)}
+	if _, err := client.Get("http://10.2.0.1:5000/v1/_ping"); err == nil {
+		t.Fatal("Expected plain HTTP to a secure registry to be refused")
+	}
+}
+
+func TestHostTransportResolvesOnce(t *testing.T) {
+	defer func(lookup func(string) ([]net.IP, error)) { lookupIP = lookup }(lookupIP)
+	lookups := 0
+	lookupIP = func(host string) ([]net.IP, error) {
+		lookups++
+		return []net.IP{net.ParseIP("10.2.0.1")}, nil
+	}
+
+	client := &http.Client{Transport: newHostTransport(true)}
+	for i := 0; i < 3; i++ {
+		if _, err := client.Get("http://myregistry:5000/v1/_ping"); err == nil {
+			t.Fatal("Expected plain HTTP to a secure registry to be refused")
+		}
+	}
+	if lookups != 1 {
+		t.Fatalf("Expected the registry to be resolved once, got %d lookups", lookups)
+	}
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	certsDir, err := ioutil.TempDir("", "docker-test-certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(certsDir)
+
+	defer func(dir string) { CertsDir = dir }(CertsDir)
+	CertsDir = certsDir
+
+	tlsConfig, err := newTLSConfig("myregistry:5000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.RootCAs != nil || len(tlsConfig.Certificates) != 0 || tlsConfig.InsecureSkipVerify {
+		t.Fatal("Expected the default TLS configuration without a certs directory")
+	}
+
+	dir := path.Join(certsDir, "myregistry:5000")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(dir, "client.key"), []byte("key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newTLSConfig("myregistry:5000"); err == nil {
+		t.Fatal("Expected an error for a key without certificate")
+	}
+}
@@ -148,6 +148,49 @@ func TestParseRunVolumes(t *testing.T) {
 	}
 }
 
+func TestParseRunPortRanges(t *testing.T) {
+	config, hostConfig := mustParse(t, "-p 10000-10001:10000-10001/udp --expose 7000-7002")
+	for _, p := range []nat.Port{"10000/udp", "10001/udp", "7000/tcp", "7001/tcp", "7002/tcp"} {
+		if _, exists := config.ExposedPorts[p]; !exists {
+			t.Fatalf("Error parsing port ranges, %s is missing from exposed ports. Received %v", p, config.ExposedPorts)
+		}
+	}
+	if b := hostConfig.PortBindings["10001/udp"]; len(b) != 1 || b[0].HostPort != "10001" {
+		t.Fatalf("Error parsing port ranges, expected 10001/udp to be bound to 10001. Received %v", b)
+	}
+
+	if _, _, err := parse(t, "--expose 7002-7000"); err == nil {
+		t.Fatalf("Error parsing port ranges, `--expose 7002-7000` should be an error but is not")
+	}
+}
+
+func TestParseRunMacVlan(t *testing.T) {
+	_, hostConfig := mustParse(t, "--net macvlan:eth0:private --ip-address 10.0.0.5/24 --gateway 10.0.0.1 -h web")
+	if !hostConfig.NetworkMode.IsMacVlan() {
+		t.Fatalf("Error parsing macvlan mode, expected a macvlan network mode. Received %s", hostConfig.NetworkMode)
+	}
+	if parent, mode := hostConfig.NetworkMode.ParentInterface(); parent != "eth0" || mode != "private" {
+		t.Fatalf("Error parsing macvlan mode, expected eth0 and private. Received %s and %s", parent, mode)
+	}
+	if hostConfig.IPAddress != "10.0.0.5/24" || hostConfig.Gateway != "10.0.0.1" {
+		t.Fatalf("Error parsing static address, received %s via %s", hostConfig.IPAddress, hostConfig.Gateway)
+	}
+
+	for _, args := range []string{
+		"--net macvlan:eth0",
+		"--net macvlan: --ip-address 10.0.0.5/24",
+		"--net macvlan:eth0:l3 --ip-address 10.0.0.5/24",
+		"--net ipvlan:eth0:bridge --ip-address 10.0.0.5/24",
+		"--net ipvlan:eth0 --ip-address 10.0.0.5",
+		"--net ipvlan:eth0 --ip-address 10.0.0.5/24 -p 80:80",
+		"--ip-address 10.0.0.5/24",
+	} {
+		if _, _, err := parse(t, args); err == nil {
+			t.Fatalf("Error parsing macvlan mode, `%s` should be an error but is not", args)
+		}
+	}
+}
+
 func TestCompare(t *testing.T) {
 	volumes1 := make(map[string]struct{})
 	volumes1["/test1"] = struct{}{}
@@ -19,6 +19,27 @@ func (n NetworkMode) IsContainer() bool {
 	return len(parts) > 1 && parts[0] == "container"
 }
 
+func (n NetworkMode) IsMacVlan() bool {
+	return strings.HasPrefix(string(n), "macvlan:")
+}
+
+func (n NetworkMode) IsIpVlan() bool {
+	return strings.HasPrefix(string(n), "ipvlan:")
+}
+
+// ParentInterface returns the parent interface and the mode of
+// the macvlan:<parent>[:<mode>] and ipvlan:<parent>[:<mode>] network modes
+func (n NetworkMode) ParentInterface() (string, string) {
+	parts := strings.SplitN(string(n), ":", 3)
+	if len(parts) < 2 {
+		return "", ""
+	}
+	if len(parts) < 3 {
+		return parts[1], ""
+	}
+	return parts[1], parts[2]
+}
+
 type HostConfig struct {
 	Binds           []string
 	ContainerIDFile string
@@ -31,6 +52,8 @@ type HostConfig struct {
 	DnsSearch       []string
 	VolumesFrom     []string
 	NetworkMode     NetworkMode
+	IPAddress       string // static address in CIDR notation, for the macvlan and ipvlan network modes
+	Gateway         string
 }
 
 func ContainerHostConfigFromJob(job *engine.Job) *HostConfig {
@@ -39,6 +62,8 @@ func ContainerHostConfigFromJob(job *engine.Job) *HostConfig {
 		Privileged:      job.GetenvBool("Privileged"),
 		PublishAllPorts: job.GetenvBool("PublishAllPorts"),
 		NetworkMode:     NetworkMode(job.Getenv("NetworkMode")),
+		IPAddress:       job.Getenv("IPAddress"),
+		Gateway:         job.Getenv("Gateway"),
 	}
 	job.GetenvJson("LxcConf", &hostConfig.LxcConf)
 	job.GetenvJson("PortBindings", &hostConfig.PortBindings)
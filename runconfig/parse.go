@@ -3,7 +3,9 @@ package runconfig
 import (
 	"fmt"
 	"io/ioutil"
+	"net"
 	"path"
+	"strconv"
 	"strings"
 
 	"github.com/dotcloud/docker/nat"
@@ -19,6 +21,7 @@ var (
 	ErrConflictAttachDetach     = fmt.Errorf("Conflicting options: -a and -d")
 	ErrConflictDetachAutoRemove = fmt.Errorf("Conflicting options: --rm and -d")
 	ErrConflictNetworkHostname  = fmt.Errorf("Conflicting options: -h and --net")
+	ErrConflictNetworkPublish   = fmt.Errorf("Conflicting options: -p, -P and --net=macvlan or --net=ipvlan")
 )
 
 //FIXME Only used in tests
@@ -65,7 +68,10 @@ func parseRun(cmd *flag.FlagSet, args []string, sysInfo *sysinfo.SysInfo) (*Conf
 		flWorkingDir      = cmd.String([]string{"w", "-workdir"}, "", "Working directory inside the container")
 		flCpuShares       = cmd.Int64([]string{"c", "-cpu-shares"}, 0, "CPU shares (relative weight)")
 		flCpuset          = cmd.String([]string{"-cpuset"}, "", "CPUs in which to allow execution (0-3, 0,1)")
-		flNetMode         = cmd.String([]string{"-net"}, "bridge", "Set the Network mode for the container\n'bridge': creates a new network stack for the container on the docker bridge\n'none': no networking for this container\n'container:<name|id>': reuses another container network stack\n'host': use the host network stack inside the contaner")
+		flStorageSize     = cmd.String([]string{"-storage-size"}, "", "Size limit of the root filesystem (format: <number><optional unit>, where unit = b, k, m or g), if the storage driver supports it")
+		flNetMode         = cmd.String([]string{"-net"}, "bridge", "Set the Network mode for the container\n'bridge': creates a new network stack for the container on the docker bridge\n'none': no networking for this container\n'container:<name|id>': reuses another container network stack\n'host': use the host network stack inside the contaner\n'macvlan:<parent>[:<mode>]': creates a macvlan device on the host interface <parent>, mode is bridge (default), private, vepa or passthru\n'ipvlan:<parent>[:<mode>]': creates an ipvlan device on the host interface <parent>, mode is l2 (default) or l3")
+		flIPAddress       = cmd.String([]string{"-ip-address"}, "", "Static IP address of the container in CIDR notation (e.g. 10.0.0.5/24), required with --net=macvlan and --net=ipvlan")
+		flGateway         = cmd.String([]string{"-gateway"}, "", "Default gateway of the container, with --net=macvlan and --net=ipvlan")
 		// For documentation purpose
 		_ = cmd.Bool([]string{"#sig-proxy", "-sig-proxy"}, true, "Proxify all received signal to the process (even in non-tty mode)")
 		_ = cmd.String([]string{"#name", "-name"}, "", "Assign a name to the container")
@@ -104,7 +110,7 @@ func parseRun(cmd *flag.FlagSet, args []string, sysInfo *sysinfo.SysInfo) (*Conf
 		return nil, nil, cmd, ErrConflictDetachAutoRemove
 	}
 
-	if *flNetMode != "bridge" && *flHostname != "" {
+	if mode := NetworkMode(*flNetMode); mode != "bridge" && !mode.IsMacVlan() && !mode.IsIpVlan() && *flHostname != "" {
 		return nil, nil, cmd, ErrConflictNetworkHostname
 	}
 
@@ -128,6 +134,15 @@ func parseRun(cmd *flag.FlagSet, args []string, sysInfo *sysinfo.SysInfo) (*Conf
 		flMemory = parsedMemory
 	}
 
+	var flStorage int64
+	if *flStorageSize != "" {
+		parsedStorage, err := units.RAMInBytes(*flStorageSize)
+		if err != nil {
+			return nil, nil, cmd, err
+		}
+		flStorage = parsedStorage
+	}
+
 	var binds []string
 	// add any bind targets to the list of container volumes
 	for bind := range flVolumes.GetMap() {
@@ -185,9 +200,19 @@ func parseRun(cmd *flag.FlagSet, args []string, sysInfo *sysinfo.SysInfo) (*Conf
 		if strings.Contains(e, ":") {
 			return nil, nil, cmd, fmt.Errorf("Invalid port format for --expose: %s", e)
 		}
-		p := nat.NewPort(nat.SplitProtoPort(e))
-		if _, exists := ports[p]; !exists {
-			ports[p] = struct{}{}
+		proto, port := nat.SplitProtoPort(e)
+		start, end, err := nat.ParsePortRange(port)
+		if err != nil {
+			return nil, nil, cmd, fmt.Errorf("Invalid range format for --expose: %s, error: %s", e, err)
+		}
+		for i := start; i <= end; i++ {
+			p := nat.NewPort(proto, strconv.Itoa(i))
+			if start == end {
+				p = nat.NewPort(proto, port)
+			}
+			if _, exists := ports[p]; !exists {
+				ports[p] = struct{}{}
+			}
 		}
 	}
 
@@ -210,6 +235,13 @@ func parseRun(cmd *flag.FlagSet, args []string, sysInfo *sysinfo.SysInfo) (*Conf
 		return nil, nil, cmd, fmt.Errorf("--net: invalid net mode: %v", err)
 	}
 
+	if err := validateStaticAddress(netMode, *flIPAddress, *flGateway); err != nil {
+		return nil, nil, cmd, err
+	}
+	if (netMode.IsMacVlan() || netMode.IsIpVlan()) && (len(portBindings) > 0 || *flPublishAll) {
+		return nil, nil, cmd, ErrConflictNetworkPublish
+	}
+
 	config := &Config{
 		Hostname:        hostname,
 		Domainname:      domainname,
@@ -222,6 +254,7 @@ func parseRun(cmd *flag.FlagSet, args []string, sysInfo *sysinfo.SysInfo) (*Conf
 		Memory:          flMemory,
 		CpuShares:       *flCpuShares,
 		Cpuset:          *flCpuset,
+		StorageSize:     flStorage,
 		AttachStdin:     flAttach.Get("stdin"),
 		AttachStdout:    flAttach.Get("stdout"),
 		AttachStderr:    flAttach.Get("stderr"),
@@ -245,6 +278,8 @@ func parseRun(cmd *flag.FlagSet, args []string, sysInfo *sysinfo.SysInfo) (*Conf
 		DnsSearch:       flDnsSearch.GetAll(),
 		VolumesFrom:     flVolumesFrom.GetAll(),
 		NetworkMode:     netMode,
+		IPAddress:       *flIPAddress,
+		Gateway:         *flGateway,
 	}
 
 	if sysInfo != nil && flMemory > 0 && !sysInfo.SwapLimit {
@@ -298,8 +333,48 @@ func parseNetMode(netMode string) (NetworkMode, error) {
 		if len(parts) < 2 || parts[1] == "" {
 			return "", fmt.Errorf("invalid container format container:<name|id>")
 		}
+	case "macvlan":
+		if len(parts) < 2 || parts[1] == "" || len(parts) > 3 {
+			return "", fmt.Errorf("invalid macvlan format macvlan:<parent>[:<mode>]")
+		}
+		if len(parts) == 3 {
+			switch parts[2] {
+			case "bridge", "private", "vepa", "passthru":
+			default:
+				return "", fmt.Errorf("invalid macvlan mode: %s", parts[2])
+			}
+		}
+	case "ipvlan":
+		if len(parts) < 2 || parts[1] == "" || len(parts) > 3 {
+			return "", fmt.Errorf("invalid ipvlan format ipvlan:<parent>[:<mode>]")
+		}
+		if len(parts) == 3 && parts[2] != "l2" && parts[2] != "l3" {
+			return "", fmt.Errorf("invalid ipvlan mode: %s", parts[2])
+		}
 	default:
 		return "", fmt.Errorf("invalid --net: %s", netMode)
 	}
 	return NetworkMode(netMode), nil
 }
+
+// validateStaticAddress checks the --ip-address and --gateway options, which
+// are only meaningful, and the address mandatory, for the macvlan and ipvlan
+// network modes since no address is allocated from the docker bridge
+func validateStaticAddress(netMode NetworkMode, ipAddress, gateway string) error {
+	if !netMode.IsMacVlan() && !netMode.IsIpVlan() {
+		if ipAddress != "" || gateway != "" {
+			return fmt.Errorf("--ip-address and --gateway require --net=macvlan or --net=ipvlan")
+		}
+		return nil
+	}
+	if ipAddress == "" {
+		return fmt.Errorf("--ip-address is required with --net=%s", netMode)
+	}
+	if _, _, err := net.ParseCIDR(ipAddress); err != nil {
+		return fmt.Errorf("Invalid --ip-address: %s", ipAddress)
+	}
+	if gateway != "" && net.ParseIP(gateway) == nil {
+		return fmt.Errorf("Invalid --gateway: %s", gateway)
+	}
+	return nil
+}
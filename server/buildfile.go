@@ -184,7 +184,7 @@ func (b *buildFile) CmdRun(args string) error {
 	if b.image == "" {
 		return fmt.Errorf("Please provide a source image with `from` prior to run")
 	}
-	config, _, _, err := runconfig.Parse(append([]string{b.image}, b.buildCmdFromJson(args)...), nil)
+	config, _, _, err := runconfig.Parse(append([]string{b.image}, buildCmdFromJson(args)...), nil)
 	if err != nil {
 		return err
 	}
@@ -282,7 +282,7 @@ func (b *buildFile) CmdEnv(args string) error {
 	return b.commit("", b.config.Cmd, fmt.Sprintf("ENV %s", replacedVar))
 }
 
-func (b *buildFile) buildCmdFromJson(args string) []string {
+func buildCmdFromJson(args string) []string {
 	var cmd []string
 	if err := json.Unmarshal([]byte(args), &cmd); err != nil {
 		utils.Debugf("Error unmarshalling: %s, setting to /bin/sh -c", err)
@@ -292,7 +292,7 @@ func (b *buildFile) buildCmdFromJson(args string) []string {
 }
 
 func (b *buildFile) CmdCmd(args string) error {
-	cmd := b.buildCmdFromJson(args)
+	cmd := buildCmdFromJson(args)
 	b.config.Cmd = cmd
 	if err := b.commit("", b.config.Cmd, fmt.Sprintf("CMD %v", cmd)); err != nil {
 		return err
@@ -301,7 +301,7 @@ func (b *buildFile) CmdCmd(args string) error {
 }
 
 func (b *buildFile) CmdEntrypoint(args string) error {
-	entrypoint := b.buildCmdFromJson(args)
+	entrypoint := buildCmdFromJson(args)
 	b.config.Entrypoint = entrypoint
 	if err := b.commit("", b.config.Cmd, fmt.Sprintf("ENTRYPOINT %v", entrypoint)); err != nil {
 		return err
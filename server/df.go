@@ -0,0 +1,254 @@
+package server
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/dotcloud/docker/daemon/graphdriver"
+	"github.com/dotcloud/docker/engine"
+	"github.com/dotcloud/docker/image"
+)
+
+// DiskUsage reports the space taken by the images, the containers and the
+// volumes, and how much of it docker prune would reclaim:
+//
+//   - for the tagged images and the ones without children, the size of
+//     their layers, the one shared with other such images and the one
+//     unique to them,
+//   - for the containers, the size of their rw layer,
+//   - for the volumes, the size of their directory.
+//
+// The reclaimable space follows the rules of a prune with its default
+// until: the containers stopped for longer, the images none of the other
+// containers uses and the volumes no container uses. The volumes of the
+// containers pruned are only reclaimable by the next prune.
+//
+// The sizes are the ones of the DiffSize of the storage driver, computed
+// concurrently.
+func (srv *Server) DiskUsage(job *engine.Job) engine.Status {
+	if n := len(job.Args); n != 0 {
+		return job.Errorf("Usage: %s", job.Name)
+	}
+	var (
+		driver     = graphdriver.GetDiffer(srv.daemon.GraphDriver())
+		containers = srv.daemon.List()
+		running    int
+	)
+	for _, container := range containers {
+		if container.State.IsRunning() {
+			running++
+		}
+	}
+	pruned, kept := splitContainers(containers, defaultPruneUntil)
+
+	images, err := srv.daemon.Graph().Map()
+	if err != nil {
+		return job.Error(err)
+	}
+	volumes, err := srv.daemon.Volumes().Map()
+	if err != nil {
+		return job.Error(err)
+	}
+
+	var (
+		imageIDs     = make([]string, 0, len(images))
+		containerIDs = make([]string, 0, len(containers))
+		volumeIDs    = make([]string, 0, len(volumes))
+	)
+	for id := range images {
+		imageIDs = append(imageIDs, id)
+	}
+	for _, container := range containers {
+		containerIDs = append(containerIDs, container.ID)
+	}
+	for id := range volumes {
+		volumeIDs = append(volumeIDs, id)
+	}
+	layerSizes := parallelSizes(imageIDs, func(id string) int64 {
+		size, err := driver.DiffSize(id, images[id].Parent)
+		if err != nil {
+			return images[id].Size
+		}
+		return size
+	})
+	rwSizes := parallelSizes(containerIDs, func(id string) int64 {
+		size, _ := driver.DiffSize(id, id+"-init")
+		return size
+	})
+	volumeSizes := parallelSizes(volumeIDs, srv.volumeSize)
+
+	// The images of interest are the ones docker images shows,
+	// their layers are shared when several of them have them
+	var (
+		byID     = srv.daemon.Repositories().ByID()
+		byParent = make(map[string]bool)
+		tops     []*image.Image
+		shares   = make(map[string]int)
+	)
+	for _, img := range images {
+		byParent[img.Parent] = true
+	}
+	for id, img := range images {
+		if _, tagged := byID[id]; tagged || !byParent[id] {
+			tops = append(tops, img)
+		}
+	}
+	history := func(img *image.Image) []string {
+		var ids []string
+		for ; img != nil; img = images[img.Parent] {
+			ids = append(ids, img.ID)
+		}
+		return ids
+	}
+	for _, img := range tops {
+		for _, id := range history(img) {
+			shares[id]++
+		}
+	}
+	usedBy := make(map[string]int)
+	for _, container := range containers {
+		usedBy[container.Image]++
+	}
+
+	var (
+		outImages                = engine.NewTable("Created", 0)
+		imagesSize, imagesUnused int64
+		imagesActive             int
+		usedImages               = srv.usedImages(images, kept)
+	)
+	for _, img := range tops {
+		var virtualSize, sharedSize int64
+		for _, id := range history(img) {
+			virtualSize += layerSizes[id]
+			if shares[id] > 1 {
+				sharedSize += layerSizes[id]
+			}
+		}
+		if usedBy[img.ID] > 0 {
+			imagesActive++
+		}
+		repoTags := byID[img.ID]
+		if repoTags == nil {
+			repoTags = []string{"<none>:<none>"}
+		}
+		item := &engine.Env{}
+		item.Set("Id", img.ID)
+		item.SetList("RepoTags", repoTags)
+		item.SetInt64("Created", img.Created.Unix())
+		item.SetInt64("Size", layerSizes[img.ID])
+		item.SetInt64("VirtualSize", virtualSize)
+		item.SetInt64("SharedSize", sharedSize)
+		item.SetInt64("UniqueSize", virtualSize-sharedSize)
+		item.SetInt("Containers", usedBy[img.ID])
+		outImages.Add(item)
+	}
+	for id, size := range layerSizes {
+		imagesSize += size
+		if !usedImages[id] {
+			imagesUnused += size
+		}
+	}
+	outImages.ReverseSort()
+
+	var (
+		outContainers                    = engine.NewTable("Created", 0)
+		containersSize, containersUnused int64
+	)
+	for _, container := range pruned {
+		containersUnused += rwSizes[container.ID]
+	}
+	for _, container := range containers {
+		size := rwSizes[container.ID]
+		containersSize += size
+		item := &engine.Env{}
+		item.Set("Id", container.ID)
+		item.Set("Image", srv.daemon.Repositories().ImageName(container.Image))
+		item.Set("Name", container.Name)
+		item.SetInt64("Created", container.Created.Unix())
+		item.Set("Status", container.State.String())
+		item.SetInt64("SizeRw", size)
+		outContainers.Add(item)
+	}
+	outContainers.ReverseSort()
+
+	usedVolumes, err := srv.usedVolumes(containers)
+	if err != nil {
+		return job.Error(err)
+	}
+	var (
+		outVolumes                 = engine.NewTable("", 0)
+		volumesSize, volumesUnused int64
+		volumesActive              int
+	)
+	for id, size := range volumeSizes {
+		volumesSize += size
+		if usedVolumes[id] > 0 {
+			volumesActive++
+		} else {
+			volumesUnused += size
+		}
+		item := &engine.Env{}
+		item.Set("Id", id)
+		item.SetInt64("Size", size)
+		item.SetInt("Containers", usedVolumes[id])
+		outVolumes.Add(item)
+	}
+
+	out := &engine.Env{}
+	for _, list := range []struct {
+		key   string
+		table *engine.Table
+	}{
+		{"Images", outImages},
+		{"Containers", outContainers},
+		{"Volumes", outVolumes},
+	} {
+		s, err := list.table.ToListString()
+		if err != nil {
+			return job.Error(err)
+		}
+		out.Set(list.key, s)
+	}
+	out.SetInt64("LayersSize", imagesSize)
+	out.SetInt("ImagesActive", imagesActive)
+	out.SetInt64("ImagesReclaimable", imagesUnused)
+	out.SetInt64("ContainersSize", containersSize)
+	out.SetInt("ContainersActive", running)
+	out.SetInt64("ContainersReclaimable", containersUnused)
+	out.SetInt64("VolumesSize", volumesSize)
+	out.SetInt("VolumesActive", volumesActive)
+	out.SetInt64("VolumesReclaimable", volumesUnused)
+	if _, err := out.WriteTo(job.Stdout); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+// parallelSizes returns the size of each id, computed by as many
+// goroutines as there are CPUs
+func parallelSizes(ids []string, size func(id string) int64) map[string]int64 {
+	var (
+		sizes   = make(map[string]int64, len(ids))
+		queue   = make(chan string)
+		lock    sync.Mutex
+		workers sync.WaitGroup
+	)
+	for i := 0; i < runtime.NumCPU(); i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for id := range queue {
+				s := size(id)
+				lock.Lock()
+				sizes[id] = s
+				lock.Unlock()
+			}
+		}()
+	}
+	for _, id := range ids {
+		queue <- id
+	}
+	close(queue)
+	workers.Wait()
+	return sizes
+}
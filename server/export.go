@@ -0,0 +1,210 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dotcloud/docker/daemon"
+	"github.com/dotcloud/docker/nat"
+	"github.com/dotcloud/docker/runconfig"
+	"github.com/dotcloud/docker/vendor/src/code.google.com/p/go/src/pkg/archive/tar"
+)
+
+// The first entry of the archive of docker export holds the configuration
+// of the container, docker import restores it. An image has no host
+// configuration, the import reports the options of docker run giving it.
+const exportConfigName = ".docker-export.json"
+
+type exportConfig struct {
+	Config     *runconfig.Config
+	HostConfig *runconfig.HostConfig
+}
+
+// writeExportConfig writes the configuration of the container as the
+// first entry of a tar archive, the archive of its filesystem follows
+func writeExportConfig(w io.Writer, container *daemon.Container) error {
+	data, err := json.Marshal(&exportConfig{container.Config, container.HostConfig()})
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+	hdr := &tar.Header{
+		Name:     exportConfigName,
+		Mode:     0600,
+		Size:     int64(len(data)),
+		ModTime:  time.Now(),
+		Typeflag: tar.TypeReg,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return err
+	}
+	// Without closing tw, which would end the archive
+	return tw.Flush()
+}
+
+// readExportConfig returns the configuration of the export of a container
+// and the archive of its filesystem, a nil configuration and the whole
+// archive for any other archive. The archive is read decompressed.
+func readExportConfig(archive io.Reader) (*exportConfig, io.Reader, error) {
+	var header bytes.Buffer
+	tr := tar.NewReader(io.TeeReader(archive, &header))
+	if hdr, err := tr.Next(); err != nil || hdr.Name != exportConfigName {
+		return nil, io.MultiReader(&header, archive), nil
+	}
+	data, err := ioutil.ReadAll(tr)
+	if err != nil {
+		return nil, nil, err
+	}
+	config := &exportConfig{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, nil, fmt.Errorf("Invalid %s: %s", exportConfigName, err)
+	}
+	// The padding of the entry to the next block of the archive
+	if padding := (512 - len(data)%512) % 512; padding > 0 {
+		if _, err := io.CopyN(ioutil.Discard, archive, int64(padding)); err != nil {
+			return nil, nil, err
+		}
+	}
+	return config, archive, nil
+}
+
+// runOptions returns the options of docker run giving a container the host
+// configuration hostConfig, but for the ones naming files of the host the
+// container ran on, e.g. --cidfile
+func runOptions(hostConfig *runconfig.HostConfig) []string {
+	var options []string
+	add := func(flag string, values ...string) {
+		for _, value := range values {
+			if strings.ContainsAny(value, " \t'\"") {
+				value = strconv.Quote(value)
+			}
+			options = append(options, flag, value)
+		}
+	}
+	if hostConfig.Privileged {
+		options = append(options, "--privileged")
+	}
+	if hostConfig.PublishAllPorts {
+		options = append(options, "-P")
+	}
+	ports := make([]string, 0, len(hostConfig.PortBindings))
+	for port := range hostConfig.PortBindings {
+		ports = append(ports, string(port))
+	}
+	sort.Strings(ports)
+	for _, p := range ports {
+		port := nat.Port(p)
+		containerPort := port.Port()
+		if port.Proto() != "tcp" {
+			containerPort += "/" + port.Proto()
+		}
+		for _, binding := range hostConfig.PortBindings[port] {
+			switch {
+			case binding.HostIp != "":
+				add("-p", binding.HostIp+":"+binding.HostPort+":"+containerPort)
+			case binding.HostPort != "":
+				add("-p", binding.HostPort+":"+containerPort)
+			default:
+				add("-p", containerPort)
+			}
+		}
+	}
+	add("-v", hostConfig.Binds...)
+	add("--volumes-from", hostConfig.VolumesFrom...)
+	add("--link", hostConfig.Links...)
+	add("--dns", hostConfig.Dns...)
+	add("--dns-search", hostConfig.DnsSearch...)
+	if mode := string(hostConfig.NetworkMode); mode != "" && mode != "bridge" {
+		add("--net", mode)
+	}
+	if hostConfig.IPAddress != "" {
+		add("--ip-address", hostConfig.IPAddress)
+	}
+	if hostConfig.Gateway != "" {
+		add("--gateway", hostConfig.Gateway)
+	}
+	for _, kv := range hostConfig.LxcConf {
+		add("--lxc-conf", kv.Key+"="+kv.Value)
+	}
+	return options
+}
+
+// applyChanges applies to config the changes given in the syntax of the
+// Dockerfile instructions CMD, ENTRYPOINT, ENV, EXPOSE, USER, VOLUME and
+// WORKDIR, e.g. `ENV DEBUG 1`
+func applyChanges(config *runconfig.Config, changes []string) error {
+	for _, change := range changes {
+		parts := strings.SplitN(strings.TrimSpace(change), " ", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[1]) == "" {
+			return fmt.Errorf("Bad parameter change, expected INSTRUCTION ARGUMENTS: %s", change)
+		}
+		args := strings.TrimSpace(parts[1])
+		switch strings.ToUpper(parts[0]) {
+		case "CMD":
+			config.Cmd = buildCmdFromJson(args)
+		case "ENTRYPOINT":
+			config.Entrypoint = buildCmdFromJson(args)
+		case "ENV":
+			env := strings.SplitN(args, " ", 2)
+			if len(env) != 2 {
+				return fmt.Errorf("Bad parameter change, invalid ENV format: %s", change)
+			}
+			setEnv(config, strings.Trim(env[0], " \t"), strings.Trim(env[1], " \t"))
+		case "EXPOSE":
+			ports, _, err := nat.ParsePortSpecs(strings.Fields(args))
+			if err != nil {
+				return fmt.Errorf("Bad parameter change: %s", err)
+			}
+			if config.ExposedPorts == nil {
+				config.ExposedPorts = make(nat.PortSet)
+			}
+			for port := range ports {
+				config.ExposedPorts[port] = struct{}{}
+			}
+		case "USER":
+			config.User = args
+		case "VOLUME":
+			var volumes []string
+			if err := json.Unmarshal([]byte(args), &volumes); err != nil {
+				volumes = []string{args}
+			}
+			if config.Volumes == nil {
+				config.Volumes = make(map[string]struct{})
+			}
+			for _, volume := range volumes {
+				config.Volumes[volume] = struct{}{}
+			}
+		case "WORKDIR":
+			if filepath.IsAbs(args) || config.WorkingDir == "" {
+				config.WorkingDir = filepath.Join("/", args)
+			} else {
+				config.WorkingDir = filepath.Join(config.WorkingDir, args)
+			}
+		default:
+			return fmt.Errorf("Bad parameter change, %s is not one of CMD, ENTRYPOINT, ENV, EXPOSE, USER, VOLUME and WORKDIR", parts[0])
+		}
+	}
+	return nil
+}
+
+// setEnv sets the variable key of the environment of config to value
+func setEnv(config *runconfig.Config, key, value string) {
+	for i, env := range config.Env {
+		if strings.SplitN(env, "=", 2)[0] == key {
+			config.Env[i] = key + "=" + value
+			return
+		}
+	}
+	config.Env = append(config.Env, key+"="+value)
+}
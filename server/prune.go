@@ -0,0 +1,407 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dotcloud/docker/daemon"
+	"github.com/dotcloud/docker/daemon/graphdriver"
+	"github.com/dotcloud/docker/engine"
+	"github.com/dotcloud/docker/image"
+	"github.com/dotcloud/docker/utils"
+)
+
+// The entries of the temporary directory of the graph younger than this
+// may still be in use, e.g. by a push buffering a layer
+const staleTmpAge = time.Hour
+
+// The containers stopped for less than this are kept by default, e.g. the
+// data-only containers which are created and never started
+const defaultPruneUntil = 24 * time.Hour
+
+// prunable is something Prune removes, or would remove in a dry run
+type prunable struct {
+	kind   string
+	id     string
+	size   int64
+	remove func() error
+}
+
+// Prune removes what the daemon no longer uses: the stopped containers,
+// the untagged images no container uses, the volumes of no container, the
+// layers of the driver which belong to no image nor container and the stale
+// temporary files of the graph. It lists them with the space they take.
+//
+// Only the containers stopped for longer than Until, 24h by default, are
+// removed. The volumes of the containers removed are kept until the next
+// prune. With DryRun, nothing is removed.
+func (srv *Server) Prune(job *engine.Job) engine.Status {
+	if n := len(job.Args); n != 0 {
+		return job.Errorf("Usage: %s", job.Name)
+	}
+	var (
+		dryRun = job.GetenvBool("DryRun")
+		until  = defaultPruneUntil
+		err    error
+	)
+	if job.Getenv("Until") != "" {
+		if until, err = time.ParseDuration(job.Getenv("Until")); err != nil {
+			return job.Errorf("Bad parameter until: %s", err)
+		}
+	}
+
+	// The images being pulled are untagged until the pull is done
+	srv.RLock()
+	pulling := len(srv.pullingPool)
+	srv.RUnlock()
+	if pulling > 0 {
+		return job.Errorf("Conflict, cannot prune while images are being pulled")
+	}
+	// Nor while images are built, imported or loaded
+	srv.imageJobs.Lock()
+	defer srv.imageJobs.Unlock()
+
+	pruned := engine.NewTable("", 0)
+	prune := func(items []*prunable) {
+		for _, item := range items {
+			if !dryRun {
+				if err := item.remove(); err != nil {
+					utils.Errorf("Cannot remove the %s %s: %s", item.kind, item.id, err)
+					continue
+				}
+			}
+			out := &engine.Env{}
+			out.Set("Type", item.kind)
+			out.Set("ID", item.id)
+			out.SetInt64("Size", item.size)
+			pruned.Add(out)
+		}
+	}
+
+	// The volumes of the containers pruned now may hold the data of the
+	// user, e.g. the ones of a data-only container
+	all := srv.daemon.List()
+	containers, kept := srv.prunableContainers(all, until)
+	prune(containers)
+
+	images, err := srv.prunableImages(kept)
+	if err != nil {
+		return job.Error(err)
+	}
+	prune(images)
+
+	volumes, err := srv.prunableVolumes(all)
+	if err != nil {
+		return job.Error(err)
+	}
+	prune(volumes)
+
+	// No layer is left out of the graph while the orphans are looked for
+	unlock := srv.daemon.Graph().LockRegistrations()
+	layers, err := srv.prunableLayers()
+	if err != nil {
+		unlock()
+		return job.Error(err)
+	}
+	prune(layers)
+	prune(srv.prunableTmp())
+	unlock()
+
+	if _, err := pruned.WriteListTo(job.Stdout); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+// splitContainers splits the containers into the ones stopped for longer
+// than until, which are pruned, and the ones to keep
+func splitContainers(containers []*daemon.Container, until time.Duration) (pruned, kept []*daemon.Container) {
+	now := time.Now().UTC()
+	for _, container := range containers {
+		stopped := container.State.FinishedAt
+		if stopped.IsZero() {
+			// Never started
+			stopped = container.Created
+		}
+		if container.State.IsRunning() || now.Sub(stopped) < until {
+			kept = append(kept, container)
+		} else {
+			pruned = append(pruned, container)
+		}
+	}
+	return pruned, kept
+}
+
+// prunableContainers returns the containers stopped for longer than until
+// and the containers to keep
+func (srv *Server) prunableContainers(containers []*daemon.Container, until time.Duration) ([]*prunable, []*daemon.Container) {
+	pruned, kept := splitContainers(containers, until)
+	items := make([]*prunable, 0, len(pruned))
+	for _, container := range pruned {
+
+		rw, _ := container.GetSize()
+		container := container
+		items = append(items, &prunable{
+			kind: "container",
+			id:   container.ID,
+			size: rw,
+			remove: func() error {
+				if err := srv.daemon.Destroy(container); err != nil {
+					return err
+				}
+				srv.LogEvent("destroy", container.ID, srv.daemon.Repositories().ImageName(container.Image))
+				return nil
+			},
+		})
+	}
+	return items, kept
+}
+
+// prunableImages returns the images which are neither tagged nor used by
+// the containers, nor the parent of such an image, children first
+func (srv *Server) prunableImages(containers []*daemon.Container) ([]*prunable, error) {
+	images, err := srv.daemon.Graph().Map()
+	if err != nil {
+		return nil, err
+	}
+
+	used := srv.usedImages(images, containers)
+	var unused []*image.Image
+	for id, img := range images {
+		if !used[id] {
+			unused = append(unused, img)
+		}
+	}
+	// The children before their parents
+	depths := make(map[string]int)
+	for _, img := range unused {
+		depths[img.ID], _ = img.Depth()
+	}
+	sort.Sort(sort.Reverse(imagesByDepth{unused, depths}))
+
+	items := make([]*prunable, 0, len(unused))
+	for _, img := range unused {
+		id := img.ID
+		items = append(items, &prunable{
+			kind: "image",
+			id:   id,
+			size: img.Size,
+			remove: func() error {
+				if err := srv.daemon.Repositories().DeleteAll(id); err != nil {
+					return err
+				}
+				if err := srv.daemon.Graph().Delete(id); err != nil {
+					return err
+				}
+				srv.LogEvent("delete", id, "")
+				return nil
+			},
+		})
+	}
+	return items, nil
+}
+
+// usedImages returns the ids of the tagged images, of the images of the
+// containers and of their parents
+func (srv *Server) usedImages(images map[string]*image.Image, containers []*daemon.Container) map[string]bool {
+	used := make(map[string]bool)
+	var use func(id string)
+	use = func(id string) {
+		for id != "" && !used[id] {
+			used[id] = true
+			img, exists := images[id]
+			if !exists {
+				return
+			}
+			id = img.Parent
+		}
+	}
+	for id := range srv.daemon.Repositories().ByID() {
+		use(id)
+	}
+	for _, repository := range srv.daemon.Repositories().Digests {
+		for _, id := range repository {
+			use(id)
+		}
+	}
+	for _, container := range containers {
+		use(container.Image)
+	}
+	return used
+}
+
+type imagesByDepth struct {
+	images []*image.Image
+	depths map[string]int
+}
+
+func (s imagesByDepth) Len() int      { return len(s.images) }
+func (s imagesByDepth) Swap(i, j int) { s.images[i], s.images[j] = s.images[j], s.images[i] }
+func (s imagesByDepth) Less(i, j int) bool {
+	return s.depths[s.images[i].ID] < s.depths[s.images[j].ID]
+}
+
+// prunableVolumes returns the volumes none of the containers uses
+func (srv *Server) prunableVolumes(containers []*daemon.Container) ([]*prunable, error) {
+	volumes, err := srv.daemon.Volumes().Map()
+	if err != nil {
+		return nil, err
+	}
+	used, err := srv.usedVolumes(containers)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []*prunable
+	for id := range volumes {
+		if used[id] > 0 {
+			continue
+		}
+		id := id
+		items = append(items, &prunable{
+			kind: "volume",
+			id:   id,
+			size: srv.volumeSize(id),
+			remove: func() error {
+				return srv.daemon.Volumes().Delete(id)
+			},
+		})
+	}
+	return items, nil
+}
+
+// usedVolumes returns the number of containers using each volume, among
+// the containers and the ones of the other storage drivers
+func (srv *Server) usedVolumes(containers []*daemon.Container) (map[string]int, error) {
+	used := make(map[string]int)
+	for _, container := range containers {
+		for _, volume := range container.Volumes {
+			// the volume id is always the base of the path
+			used[filepath.Base(strings.TrimSuffix(volume, "/layer"))]++
+		}
+	}
+	// The containers of the other storage drivers may use volumes too
+	repository := path.Join(srv.daemon.Config().Root, "containers")
+	files, err := ioutil.ReadDir(repository)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if srv.daemon.Get(file.Name()) != nil {
+			continue
+		}
+		var config struct {
+			Volumes map[string]string
+		}
+		if data, err := ioutil.ReadFile(path.Join(repository, file.Name(), "config.json")); err == nil {
+			json.Unmarshal(data, &config)
+		}
+		for _, volume := range config.Volumes {
+			used[filepath.Base(strings.TrimSuffix(volume, "/layer"))]++
+		}
+	}
+
+	return used, nil
+}
+
+func (srv *Server) volumeSize(id string) int64 {
+	driver := srv.daemon.Volumes().Driver()
+	dir, err := driver.Get(id, "")
+	if err != nil {
+		return 0
+	}
+	defer driver.Put(id)
+	size, _ := utils.TreeSize(dir)
+	return size
+}
+
+// prunableLayers returns the layers of the driver which belong to no image,
+// container nor volume, e.g. the leftovers of a failed registration
+func (srv *Server) prunableLayers() ([]*prunable, error) {
+	driver := srv.daemon.GraphDriver()
+	lister, ok := driver.(graphdriver.Lister)
+	if !ok {
+		utils.Debugf("The %s driver cannot list its layers, skipping them", driver)
+		return nil, nil
+	}
+	layers, err := lister.Layers()
+	if err != nil {
+		return nil, err
+	}
+
+	// Every directory of the graph or of the containers holds the metadata
+	// of a layer, even a partially loaded or created one
+	used := make(map[string]bool)
+	for _, root := range []string{srv.daemon.Graph().Root, path.Join(srv.daemon.Config().Root, "containers")} {
+		files, err := ioutil.ReadDir(root)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			used[file.Name()] = true
+			used[file.Name()+"-init"] = true
+		}
+	}
+	// The volumes share the home of the vfs driver
+	volumes, err := srv.daemon.Volumes().Map()
+	if err != nil {
+		return nil, err
+	}
+	for id := range volumes {
+		used[id] = true
+	}
+
+	var items []*prunable
+	for _, id := range layers {
+		if used[id] {
+			continue
+		}
+		size, err := graphdriver.GetDiffer(driver).DiffSize(id, "")
+		if err != nil {
+			size = 0
+		}
+		id := id
+		items = append(items, &prunable{
+			kind: "layer",
+			id:   id,
+			size: size,
+			remove: func() error {
+				return driver.Remove(id)
+			},
+		})
+	}
+	return items, nil
+}
+
+// prunableTmp returns the stale entries of the temporary directory of
+// the graph, left by the interrupted registrations and pushes
+func (srv *Server) prunableTmp() []*prunable {
+	tmp := path.Join(srv.daemon.Graph().Root, "_tmp")
+	files, err := ioutil.ReadDir(tmp)
+	if err != nil {
+		return nil
+	}
+	var items []*prunable
+	for _, file := range files {
+		if time.Since(file.ModTime()) < staleTmpAge {
+			continue
+		}
+		p := path.Join(tmp, file.Name())
+		size, _ := utils.TreeSize(p)
+		items = append(items, &prunable{
+			kind: "tmp",
+			id:   file.Name(),
+			size: size,
+			remove: func() error {
+				return os.RemoveAll(p)
+			},
+		})
+	}
+	return items
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dotcloud/docker/registry"
+	"github.com/dotcloud/docker/utils"
+	"github.com/dotcloud/docker/vendor/src/code.google.com/p/go/src/pkg/archive/tar"
+)
+
+// mockImage is an image of the mock registry
+type mockImage struct {
+	id     string
+	parent string
+	json   []byte
+	layer  []byte
+}
+
+// newMockImages returns a chain of n images, the base image first
+func newMockImages(t *testing.T, n int) []*mockImage {
+	var images []*mockImage
+	parent := ""
+	for i := 0; i < n; i++ {
+		img := &mockImage{id: utils.GenerateRandomID(), parent: parent}
+		data := map[string]string{"id": img.id}
+		if parent != "" {
+			data["parent"] = parent
+		}
+		var err error
+		if img.json, err = json.Marshal(data); err != nil {
+			t.Fatal(err)
+		}
+		buf := new(bytes.Buffer)
+		tw := tar.NewWriter(buf)
+		content := []byte("layer " + img.id + "\n")
+		if err := tw.WriteHeader(&tar.Header{Name: img.id, Size: int64(len(content)), Mode: 0644}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(content)
+		tw.Close()
+		img.layer = buf.Bytes()
+
+		images = append(images, img)
+		parent = img.id
+	}
+	return images
+}
+
+// mockRegistry is a v1 registry serving the pulls of its images and
+// accepting any push. It records the transfers of the layers, which take
+// delay each.
+type mockRegistry struct {
+	sync.Mutex
+	images map[string]*mockImage
+	delay  time.Duration
+	// the layer whose download fails and the image whose json push fails
+	failLayer string
+	failJSON  string
+
+	// the number of layers transferred at once and its maximum
+	transfers    int
+	maxTransfers int
+	// the ids of the images whose layer was downloaded, or json pushed
+	downloaded []string
+	pushed     []string
+
+	server *httptest.Server
+}
+
+func newMockRegistry(images []*mockImage) *mockRegistry {
+	m := &mockRegistry{images: make(map[string]*mockImage)}
+	for _, img := range images {
+		m.images[img.id] = img
+	}
+	m.server = httptest.NewServer(m)
+	return m
+}
+
+// Endpoint returns the endpoint of the registry
+func (m *mockRegistry) Endpoint() string {
+	return m.server.URL + "/v1/"
+}
+
+func (m *mockRegistry) Close() {
+	m.server.Close()
+}
+
+func (m *mockRegistry) Registry(t *testing.T) *registry.Registry {
+	r, err := registry.NewRegistry(&registry.AuthConfig{}, registry.HTTPRequestFactory(nil), m.Endpoint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// transfer records a layer transfer for the duration of the delay
+func (m *mockRegistry) transfer() {
+	m.Lock()
+	m.transfers++
+	if m.transfers > m.maxTransfers {
+		m.maxTransfers = m.transfers
+	}
+	m.Unlock()
+
+	time.Sleep(m.delay)
+
+	m.Lock()
+	m.transfers--
+	m.Unlock()
+}
+
+func (m *mockRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/"), "/")
+	if len(parts) == 3 && parts[0] == "images" {
+		m.serveImage(w, r, parts[1], parts[2])
+		return
+	}
+	if len(parts) > 1 && parts[0] == "repositories" && r.Method == "PUT" {
+		// tags
+		io.Copy(ioutil.Discard, r.Body)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+func (m *mockRegistry) serveImage(w http.ResponseWriter, r *http.Request, id, action string) {
+	m.Lock()
+	img := m.images[id]
+	m.Unlock()
+
+	if r.Method == "PUT" {
+		switch action {
+		case "json":
+			if id == m.failJSON {
+				http.Error(w, "push failure", http.StatusInternalServerError)
+				return
+			}
+			m.Lock()
+			m.pushed = append(m.pushed, id)
+			m.Unlock()
+		case "layer":
+			m.transfer()
+		}
+		io.Copy(ioutil.Discard, r.Body)
+		return
+	}
+
+	if img == nil {
+		http.NotFound(w, r)
+		return
+	}
+	switch action {
+	case "ancestry":
+		var ancestry []string
+		for img != nil {
+			ancestry = append(ancestry, img.id)
+			img = m.images[img.parent]
+		}
+		json.NewEncoder(w).Encode(ancestry)
+	case "json":
+		w.Write(img.json)
+	case "layer":
+		m.Lock()
+		m.downloaded = append(m.downloaded, id)
+		m.Unlock()
+		m.transfer()
+		if id == m.failLayer {
+			http.Error(w, "download failure", http.StatusInternalServerError)
+			return
+		}
+		w.Write(img.layer)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// lockedBuffer is the output of the concurrent pulls and pushes
+type lockedBuffer struct {
+	sync.Mutex
+	bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.Buffer.Write(p)
+}
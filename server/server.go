@@ -134,7 +134,10 @@ func InitServer(job *engine.Job) engine.Status {
 		"image_delete":     srv.ImageDelete,
 		"events":           srv.Events,
 		"push":             srv.ImagePush,
+		"share":            srv.ImageShare,
 		"containers":       srv.Containers,
+		"prune":            srv.Prune,
+		"df":               srv.DiskUsage,
 	} {
 		if err := job.Eng.Register(name, handler); err != nil {
 			return job.Error(err)
@@ -283,6 +286,8 @@ func (srv *Server) Events(job *engine.Job) engine.Status {
 	return engine.StatusOK
 }
 
+// ContainerExport streams the filesystem of a container as a tar archive,
+// preceded by its configuration for ImageImport
 func (srv *Server) ContainerExport(job *engine.Job) engine.Status {
 	if len(job.Args) != 1 {
 		return job.Errorf("Usage: %s container_id", job.Name)
@@ -295,6 +300,9 @@ func (srv *Server) ContainerExport(job *engine.Job) engine.Status {
 		}
 		defer data.Close()
 
+		if err := writeExportConfig(job.Stdout, container); err != nil {
+			return job.Errorf("%s: %s", name, err)
+		}
 		// Stream the entire contents of the container (basically a volatile snapshot)
 		if _, err := io.Copy(job.Stdout, data); err != nil {
 			return job.Errorf("%s: %s", name, err)
@@ -418,6 +426,10 @@ func (srv *Server) Build(job *engine.Job) engine.Status {
 	if len(job.Args) != 0 {
 		return job.Errorf("Usage: %s\n", job.Name)
 	}
+	// A prune waits for the images of the build to be tagged
+	srv.imageJobs.RLock()
+	defer srv.imageJobs.RUnlock()
+
 	var (
 		remoteURL      = job.Getenv("remote")
 		repoName       = job.Getenv("t")
@@ -496,6 +508,10 @@ func (srv *Server) Build(job *engine.Job) engine.Status {
 // Loads a set of images into the repository. This is the complementary of ImageExport.
 // The input stream is an uncompressed tar ball containing images and metadata.
 func (srv *Server) ImageLoad(job *engine.Job) engine.Status {
+	// A prune waits for the images being loaded to be tagged
+	srv.imageJobs.RLock()
+	defer srv.imageJobs.RUnlock()
+
 	tmpImageDir, err := ioutil.TempDir("", "docker-import-")
 	if err != nil {
 		return job.Error(err)
@@ -1072,15 +1088,91 @@ func (srv *Server) ImageTag(job *engine.Job) engine.Status {
 	return engine.StatusOK
 }
 
-func (srv *Server) pullImage(r *registry.Registry, out io.Writer, imgID, endpoint string, token []string, sf *utils.StreamFormatter) error {
+// ImageShare adds the layers of an image and of its parents to a layer
+// store, the pulls of the daemons using the store copy them from there
+func (srv *Server) ImageShare(job *engine.Job) engine.Status {
+	if len(job.Args) != 2 {
+		return job.Errorf("Usage: %s STORE IMAGE", job.Name)
+	}
+	if !path.IsAbs(job.Args[0]) {
+		return job.Errorf("The layer store %s is not an absolute path", job.Args[0])
+	}
+	layerStore, err := graph.NewLayerStore(job.Args[0])
+	if err != nil {
+		return job.Error(err)
+	}
+	img, err := srv.daemon.Repositories().LookupImage(job.Args[1])
+	if err != nil {
+		return job.Error(err)
+	}
+
+	shared := engine.NewTable("", 0)
+	if err := img.WalkHistory(func(img *image.Image) error {
+		checksum, err := srv.daemon.Graph().AddToStore(layerStore, img.ID)
+		if err != nil {
+			return fmt.Errorf("Cannot add the layer of %s to the layer store: %s", img.ID, err)
+		}
+		out := &engine.Env{}
+		out.Set("ID", img.ID)
+		out.Set("Checksum", checksum)
+		shared.Add(out)
+		return nil
+	}); err != nil {
+		return job.Error(err)
+	}
+	if _, err := shared.WriteListTo(job.Stdout); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+// layerDownload is the download of the layer of an image by pullImage
+type layerDownload struct {
+	id string
+	// the tarsum of the layer in the layer store, which has the layer
+	stored  string
+	imgJSON []byte
+	img     *image.Image
+	layer   *os.File
+	err     error
+	done    chan struct{}
+}
+
+// pullImage pulls the image imgID and its parents from endpoint into the
+// graph g. The layers of the layer store of the graph with the checksum the
+// index has for them are copied from there rather than downloaded.
+func (srv *Server) pullImage(r *registry.Registry, out io.Writer, g *graph.Graph, imgID, endpoint string, token []string, checksums map[string]string, sf *utils.StreamFormatter) error {
 	history, err := r.GetRemoteHistory(imgID, endpoint, token)
 	if err != nil {
 		return err
 	}
+	// The layers are downloaded concurrently, the base ones first, and
+	// registered in parent order as their downloads complete. No download
+	// is started once one failed.
+	out = utils.NewLockedWriter(out)
 	out.Write(sf.FormatProgress(utils.TruncateID(imgID), "Pulling dependent layers", nil))
-	// FIXME: Try to stream the images?
-	// FIXME: Launch the getRemoteImage() in goroutines
 
+	var (
+		downloads []*layerDownload
+		pulling   []string
+		abort     = make(chan struct{})
+		once      sync.Once
+	)
+	defer func() {
+		// Wait for the downloads still running when a layer failed,
+		// their partial files are kept to be resumed by the next pull
+		for _, d := range downloads {
+			<-d.done
+			if d.layer != nil {
+				d.layer.Close()
+			}
+		}
+		for _, id := range pulling {
+			srv.poolRemove("pull", "layer:"+id)
+		}
+	}()
+
+download:
 	for i := len(history) - 1; i >= 0; i-- {
 		id := history[i]
 
@@ -1089,64 +1181,129 @@ func (srv *Server) pullImage(r *registry.Registry, out io.Writer, imgID, endpoin
 			utils.Errorf("Image (id: %s) pull is already running, skipping: %v", id, err)
 			<-c
 		}
-		defer srv.poolRemove("pull", "layer:"+id)
+		pulling = append(pulling, id)
 
-		if !srv.daemon.Graph().Exists(id) {
-			out.Write(sf.FormatProgress(utils.TruncateID(id), "Pulling metadata", nil))
-			var (
-				imgJSON []byte
-				imgSize int
-				err     error
-				img     *image.Image
-			)
-			retries := 5
-			for j := 1; j <= retries; j++ {
-				imgJSON, imgSize, err = r.GetRemoteImageJSON(id, endpoint, token)
-				if err != nil && j == retries {
-					out.Write(sf.FormatProgress(utils.TruncateID(id), "Error pulling dependent layers", nil))
-					return err
-				} else if err != nil {
-					time.Sleep(time.Duration(j) * 500 * time.Millisecond)
-					continue
-				}
-				img, err = image.NewImgJSON(imgJSON)
-				if err != nil && j == retries {
-					out.Write(sf.FormatProgress(utils.TruncateID(id), "Error pulling dependent layers", nil))
-					return fmt.Errorf("Failed to parse json: %s", err)
-				} else if err != nil {
-					time.Sleep(time.Duration(j) * 500 * time.Millisecond)
+		if g.Exists(id) {
+			continue
+		}
+		d := &layerDownload{id: id, done: make(chan struct{})}
+		downloads = append(downloads, d)
+		if checksum := checksums[id]; g.HasStoredLayer(id, checksum) {
+			d.stored = checksum
+			close(d.done)
+			continue
+		}
+		out.Write(sf.FormatProgress(utils.TruncateID(id), "Waiting", nil))
+		select {
+		case srv.downloadSlots <- struct{}{}:
+		case <-abort:
+			d.err = fmt.Errorf("Pull of %s aborted", utils.TruncateID(id))
+			close(d.done)
+			break download
+		}
+		go func() {
+			defer func() {
+				<-srv.downloadSlots
+				close(d.done)
+			}()
+			select {
+			case <-abort:
+				d.err = fmt.Errorf("Pull of %s aborted", utils.TruncateID(d.id))
+				return
+			default:
+			}
+			if d.err = srv.downloadImage(r, out, g, d, endpoint, token, sf); d.err != nil {
+				once.Do(func() { close(abort) })
+			}
+		}()
+	}
+
+	for i := len(history) - 1; i >= 0; i-- {
+		id := history[i]
+		if len(downloads) > 0 && downloads[0].id == id {
+			d := downloads[0]
+			downloads = downloads[1:]
+			<-d.done
+			if d.stored != "" {
+				out.Write(sf.FormatProgress(utils.TruncateID(id), "Copying from the layer store", nil))
+				err := g.RegisterFromStore(id, d.stored)
+				if err == nil {
+					out.Write(sf.FormatProgress(utils.TruncateID(id), "Download complete", nil))
 					continue
-				} else {
-					break
 				}
+				// e.g. the entry was removed from the store meanwhile
+				utils.Errorf("Cannot copy the layer of %s from the layer store, downloading it: %s", id, err)
+				d.err = srv.downloadImage(r, out, g, d, endpoint, token, sf)
 			}
-
-			// Get the layer
-			out.Write(sf.FormatProgress(utils.TruncateID(id), "Pulling fs layer", nil))
-			layer, err := r.GetRemoteImageLayer(img.ID, endpoint, token)
-			if err != nil {
-				out.Write(sf.FormatProgress(utils.TruncateID(id), "Error pulling dependent layers", nil))
-				return err
+			if d.err != nil {
+				return d.err
 			}
-			defer layer.Close()
-			if err := srv.daemon.Graph().Register(imgJSON, utils.ProgressReader(layer, imgSize, out, sf, false, utils.TruncateID(id), "Downloading"), img); err != nil {
+			err := g.Register(d.imgJSON, d.layer, d.img)
+			d.layer.Close()
+			// A layer which fails to register is not resumed either
+			os.Remove(d.layer.Name())
+			if err != nil {
 				out.Write(sf.FormatProgress(utils.TruncateID(id), "Error downloading dependent layers", nil))
 				return err
 			}
 		}
 		out.Write(sf.FormatProgress(utils.TruncateID(id), "Download complete", nil))
+	}
+	return nil
+}
 
+// downloadImage fetches the metadata of the image of d and downloads its
+// layer into the graph g
+func (srv *Server) downloadImage(r *registry.Registry, out io.Writer, g *graph.Graph, d *layerDownload, endpoint string, token []string, sf *utils.StreamFormatter) error {
+	out.Write(sf.FormatProgress(utils.TruncateID(d.id), "Pulling metadata", nil))
+	var (
+		imgSize int
+		err     error
+	)
+	retries := 5
+	for j := 1; j <= retries; j++ {
+		d.imgJSON, imgSize, err = r.GetRemoteImageJSON(d.id, endpoint, token)
+		if err != nil && j == retries {
+			out.Write(sf.FormatProgress(utils.TruncateID(d.id), "Error pulling dependent layers", nil))
+			return err
+		} else if err != nil {
+			time.Sleep(time.Duration(j) * 500 * time.Millisecond)
+			continue
+		}
+		d.img, err = image.NewImgJSON(d.imgJSON)
+		if err != nil && j == retries {
+			out.Write(sf.FormatProgress(utils.TruncateID(d.id), "Error pulling dependent layers", nil))
+			return fmt.Errorf("Failed to parse json: %s", err)
+		} else if err != nil {
+			time.Sleep(time.Duration(j) * 500 * time.Millisecond)
+			continue
+		} else {
+			break
+		}
+	}
+
+	// Get the layer
+	out.Write(sf.FormatProgress(utils.TruncateID(d.id), "Pulling fs layer", nil))
+	d.layer, err = g.DownloadLayer(r, out, d.img.ID, endpoint, token, imgSize, srv.downloadRetries, sf)
+	if err != nil {
+		out.Write(sf.FormatProgress(utils.TruncateID(d.id), "Error pulling dependent layers", nil))
+		return err
 	}
 	return nil
 }
 
-func (srv *Server) pullRepository(r *registry.Registry, out io.Writer, localName, remoteName, askedTag string, sf *utils.StreamFormatter, parallel bool) error {
+func (srv *Server) pullRepository(r *registry.Registry, out io.Writer, localName, remoteName, askedTag string, sf *utils.StreamFormatter, parallel bool, mirrors []string) error {
 	out.Write(sf.FormatStatus("", "Pulling repository %s", localName))
 
 	repoData, err := r.GetRepositoryData(remoteName)
 	if err != nil {
 		return err
 	}
+	// The checksums of the layers key the layer store
+	checksums := make(map[string]string)
+	for id, img := range repoData.ImgList {
+		checksums[id] = img.Checksum
+	}
 
 	utils.Debugf("Retrieving the tag list")
 	tagsList, err := r.GetRemoteTags(repoData.Endpoints, remoteName, repoData.Tokens)
@@ -1216,9 +1373,22 @@ func (srv *Server) pullRepository(r *registry.Registry, out io.Writer, localName
 			out.Write(sf.FormatProgress(utils.TruncateID(img.ID), fmt.Sprintf("Pulling image (%s) from %s", img.Tag, localName), nil))
 			success := false
 			var lastErr error
-			for _, ep := range repoData.Endpoints {
+			// The mirrors are tried first, in order, the endpoints returned
+			// by the index are the fallback. The tokens of the index are
+			// for its endpoints only, the mirrors get none.
+			for _, ep := range mirrors {
+				out.Write(sf.FormatProgress(utils.TruncateID(img.ID), fmt.Sprintf("Pulling image (%s) from %s, mirror: %s", img.Tag, localName, ep), nil))
+				if err := srv.pullImage(r, out, srv.daemon.Graph(), img.ID, ep, nil, checksums, sf); err != nil {
+					out.Write(sf.FormatProgress(utils.TruncateID(img.ID), fmt.Sprintf("Error pulling image (%s) from %s, mirror: %s, %s", img.Tag, localName, ep, err), nil))
+					continue
+				}
+				success = true
+				break
+			}
+			for i := 0; i < len(repoData.Endpoints) && !success; i++ {
+				ep := repoData.Endpoints[i]
 				out.Write(sf.FormatProgress(utils.TruncateID(img.ID), fmt.Sprintf("Pulling image (%s) from %s, endpoint: %s", img.Tag, localName, ep), nil))
-				if err := srv.pullImage(r, out, img.ID, ep, repoData.Tokens, sf); err != nil {
+				if err := srv.pullImage(r, out, srv.daemon.Graph(), img.ID, ep, repoData.Tokens, checksums, sf); err != nil {
 					// It's not ideal that only the last error is returned, it would be better to concatenate the errors.
 					// As the error is also given to the output stream the user will see the error.
 					lastErr = err
@@ -1226,7 +1396,6 @@ func (srv *Server) pullRepository(r *registry.Registry, out io.Writer, localName
 					continue
 				}
 				success = true
-				break
 			}
 			if !success {
 				out.Write(sf.FormatProgress(utils.TruncateID(img.ID), fmt.Sprintf("Error pulling image (%s) from %s, %s", img.Tag, localName, lastErr), nil))
@@ -1364,12 +1533,35 @@ func (srv *Server) ImagePull(job *engine.Job) engine.Status {
 		return job.Error(err)
 	}
 
+	var mirrors []string
 	if endpoint == registry.IndexServerAddress() {
 		// If pull "index.docker.io/foo/bar", it's stored locally under "foo/bar"
 		localName = remoteName
+
+		// Mirrors only serve images of the official index
+		mirrors = srv.daemon.Config().Mirrors
 	}
 
-	if err = srv.pullRepository(r, job.Stdout, localName, remoteName, tag, sf, job.GetenvBool("parallel")); err != nil {
+	if (len(mirrors) == 0 || registry.IsDigest(tag)) && r.SupportsV2(endpoint) {
+		err := srv.daemon.Repositories().PullV2Repository(r, job.Stdout, localName, remoteName, tag, endpoint, sf)
+		if err == nil {
+			return engine.StatusOK
+		}
+		// Only the images of a tag can be pulled from a v1 registry, and
+		// only those which do not require a trusted signature
+		if registry.IsDigest(tag) || srv.daemon.Repositories().RequiresTrust(localName) {
+			return job.Error(err)
+		}
+		utils.Errorf("Error pulling %s from the v2 registry, falling back to v1: %s", localName, err)
+	}
+	if registry.IsDigest(tag) {
+		return job.Errorf("Pulling %s@%s requires a registry supporting the v2 protocol", localName, tag)
+	}
+	if srv.daemon.Repositories().RequiresTrust(localName) {
+		return job.Errorf("The images of %s must be signed by a trusted key, which requires a registry supporting the v2 protocol", localName)
+	}
+
+	if err = srv.pullRepository(r, job.Stdout, localName, remoteName, tag, sf, job.GetenvBool("parallel"), mirrors); err != nil {
 		return job.Error(err)
 	}
 
@@ -1475,26 +1667,14 @@ func (srv *Server) pushRepository(r *registry.Registry, out io.Writer, localName
 	if tag == "" {
 		nTag = len(localRepo)
 	}
+	// The archive of a layer is shared by all the endpoints
+	archives := newLayerArchives(srv.daemon.Graph())
+	defer archives.Cleanup()
 	for _, ep := range repoData.Endpoints {
 		out.Write(sf.FormatStatus("", "Pushing repository %s (%d tags)", localName, nTag))
 
-		for _, imgId := range imgList {
-			if r.LookupRemoteImage(imgId, ep, repoData.Tokens) {
-				out.Write(sf.FormatStatus("", "Image %s already pushed, skipping", utils.TruncateID(imgId)))
-			} else {
-				if _, err := srv.pushImage(r, out, remoteName, imgId, ep, repoData.Tokens, sf); err != nil {
-					// FIXME: Continue on error?
-					return err
-				}
-			}
-
-			for _, tag := range tagsByImage[imgId] {
-				out.Write(sf.FormatStatus("", "Pushing tag for rev [%s] on {%s}", utils.TruncateID(imgId), ep+"repositories/"+remoteName+"/tags/"+tag))
-
-				if err := r.PushRegistryTag(remoteName, imgId, tag, ep, repoData.Tokens); err != nil {
-					return err
-				}
-			}
+		if err := srv.pushImages(r, out, remoteName, imgList, tagsByImage, ep, repoData.Tokens, archives, sf); err != nil {
+			return err
 		}
 	}
 
@@ -1505,9 +1685,103 @@ func (srv *Server) pushRepository(r *registry.Registry, out io.Writer, localName
 	return nil
 }
 
+// layerPush is the push of an image by pushImages
+type layerPush struct {
+	// jsonPushed is closed once the json of the image is in the registry,
+	// the images based on it can be pushed from then on
+	jsonPushed chan struct{}
+}
+
+// pushImages pushes the images of imgList, in which the parents come first,
+// and their tags to the endpoint ep. An image is pushed as soon as the json of
+// its parent is in the registry, by at most MaxConcurrentUploads workers for
+// the whole daemon. The push stops at the first error, once the running
+// workers are done.
+func (srv *Server) pushImages(r *registry.Registry, out io.Writer, remoteName string, imgList []string, tagsByImage map[string][]string, ep string, token []string, archives *layerArchives, sf *utils.StreamFormatter) error {
+	var (
+		pushes   = make(map[string]*layerPush)
+		abort    = make(chan struct{})
+		abortErr error
+		once     sync.Once
+		wg       sync.WaitGroup
+	)
+	fail := func(err error) {
+		once.Do(func() {
+			abortErr = err
+			close(abort)
+		})
+	}
+	for _, imgId := range imgList {
+		pushes[imgId] = &layerPush{jsonPushed: make(chan struct{})}
+	}
+	// the workers, and the archives they render, report on the same stream
+	out = utils.NewLockedWriter(out)
+
+push:
+	for _, imgId := range imgList {
+		img, err := archives.graph.Get(imgId)
+		if err != nil {
+			fail(err)
+			break
+		}
+		select {
+		case srv.uploadSlots <- struct{}{}:
+		case <-abort:
+			break push
+		}
+
+		wg.Add(1)
+		go func(imgId string, push, parent *layerPush) {
+			defer func() {
+				<-srv.uploadSlots
+				wg.Done()
+			}()
+			select {
+			case <-abort:
+				return
+			default:
+			}
+			if parent != nil {
+				select {
+				case <-parent.jsonPushed:
+				case <-abort:
+					return
+				}
+			}
+			if _, err := srv.pushImageTo(r, out, remoteName, imgId, ep, token, push.jsonPushed, archives, sf); err != nil {
+				fail(err)
+				return
+			}
+			for _, tag := range tagsByImage[imgId] {
+				out.Write(sf.FormatStatus("", "Pushing tag for rev [%s] on {%s}", utils.TruncateID(imgId), ep+"repositories/"+remoteName+"/tags/"+tag))
+
+				if err := r.PushRegistryTag(remoteName, imgId, tag, ep, token); err != nil {
+					fail(err)
+					return
+				}
+			}
+		}(imgId, pushes[imgId], pushes[img.Parent])
+	}
+	wg.Wait()
+	return abortErr
+}
+
 func (srv *Server) pushImage(r *registry.Registry, out io.Writer, remote, imgID, ep string, token []string, sf *utils.StreamFormatter) (checksum string, err error) {
+	archives := newLayerArchives(srv.daemon.Graph())
+	defer archives.Cleanup()
+	return srv.pushImageTo(r, out, remote, imgID, ep, token, make(chan struct{}), archives, sf)
+}
+
+// pushImageTo pushes the json, the layer and the checksum of imgID, closing
+// jsonPushed as soon as the json is in the registry or found there
+func (srv *Server) pushImageTo(r *registry.Registry, out io.Writer, remote, imgID, ep string, token []string, jsonPushed chan struct{}, archives *layerArchives, sf *utils.StreamFormatter) (checksum string, err error) {
 	out = utils.NewWriteFlusher(out)
-	jsonRaw, err := ioutil.ReadFile(path.Join(srv.daemon.Graph().Root, imgID, "json"))
+	if r.LookupRemoteImage(imgID, ep, token) {
+		close(jsonPushed)
+		out.Write(sf.FormatProgress(utils.TruncateID(imgID), "Image already pushed, skipping", nil))
+		return "", nil
+	}
+	jsonRaw, err := ioutil.ReadFile(path.Join(archives.graph.Root, imgID, "json"))
 	if err != nil {
 		return "", fmt.Errorf("Cannot retrieve the path for {%s}: %s", imgID, err)
 	}
@@ -1520,22 +1794,24 @@ func (srv *Server) pushImage(r *registry.Registry, out io.Writer, remote, imgID,
 	// Send the json
 	if err := r.PushImageJSONRegistry(imgData, jsonRaw, ep, token); err != nil {
 		if err == registry.ErrAlreadyExists {
+			close(jsonPushed)
 			out.Write(sf.FormatProgress(utils.TruncateID(imgData.ID), "Image already pushed, skipping", nil))
 			return "", nil
 		}
 		return "", err
 	}
+	close(jsonPushed)
 
-	layerData, err := srv.daemon.Graph().TempLayerArchive(imgID, archive.Uncompressed, sf, out)
+	layerData, size, err := archives.Get(imgID, sf, out)
 	if err != nil {
 		return "", fmt.Errorf("Failed to generate layer archive: %s", err)
 	}
-	defer os.RemoveAll(layerData.Name())
+	defer layerData.Close()
 
 	// Send the layer
-	utils.Debugf("rendered layer for %s of [%d] size", imgData.ID, layerData.Size)
+	utils.Debugf("rendered layer for %s of [%d] size", imgData.ID, size)
 
-	checksum, checksumPayload, err := r.PushImageLayerRegistry(imgData.ID, utils.ProgressReader(layerData, int(layerData.Size), out, sf, false, utils.TruncateID(imgData.ID), "Pushing"), ep, token, jsonRaw)
+	checksum, checksumPayload, err := r.PushImageLayerRegistry(imgData.ID, utils.ProgressReader(layerData, int(size), out, sf, false, utils.TruncateID(imgData.ID), "Pushing"), ep, token, jsonRaw)
 	if err != nil {
 		return "", err
 	}
@@ -1550,6 +1826,64 @@ func (srv *Server) pushImage(r *registry.Registry, out io.Writer, remote, imgID,
 	return imgData.Checksum, nil
 }
 
+// layerArchives renders the archive of a layer once for all the endpoints
+// and the tags of a push, the archives are removed by Cleanup
+type layerArchives struct {
+	sync.Mutex
+	graph    *graph.Graph
+	archives map[string]*layerArchive
+}
+
+type layerArchive struct {
+	sync.Mutex
+	archive *archive.TempArchive
+	err     error
+}
+
+func newLayerArchives(g *graph.Graph) *layerArchives {
+	return &layerArchives{
+		graph:    g,
+		archives: make(map[string]*layerArchive),
+	}
+}
+
+// Get returns the archive of the layer of id opened at its start, and its size
+func (la *layerArchives) Get(id string, sf *utils.StreamFormatter, out io.Writer) (*os.File, int64, error) {
+	la.Lock()
+	a, exists := la.archives[id]
+	if !exists {
+		a = &layerArchive{}
+		la.archives[id] = a
+	}
+	la.Unlock()
+
+	a.Lock()
+	defer a.Unlock()
+	if a.archive == nil && a.err == nil {
+		a.archive, a.err = la.graph.TempLayerArchive(id, archive.Uncompressed, sf, out)
+	}
+	if a.err != nil {
+		return nil, 0, a.err
+	}
+	// Each push reads its own file, reading the archive itself removes it
+	f, err := os.Open(a.archive.Name())
+	if err != nil {
+		return nil, 0, err
+	}
+	return f, a.archive.Size, nil
+}
+
+func (la *layerArchives) Cleanup() {
+	la.Lock()
+	defer la.Unlock()
+	for _, a := range la.archives {
+		if a.archive != nil {
+			a.archive.Close()
+			os.RemoveAll(a.archive.Name())
+		}
+	}
+}
+
 // FIXME: Allow to interrupt current push when new push of same image is done.
 func (srv *Server) ImagePush(job *engine.Job) engine.Status {
 	if n := len(job.Args); n != 1 {
@@ -1595,7 +1929,12 @@ func (srv *Server) ImagePush(job *engine.Job) engine.Status {
 		job.Stdout.Write(sf.FormatStatus("", "The push refers to a repository [%s] (len: %d)", localName, reposLen))
 		// If it fails, try to get the repository
 		if localRepo, exists := srv.daemon.Repositories().Repositories[localName]; exists {
-			if err := srv.pushRepository(r, job.Stdout, localName, remoteName, localRepo, tag, sf); err != nil {
+			if r.SupportsV2(endpoint) {
+				err = srv.daemon.Repositories().PushV2Repository(r, job.Stdout, localName, remoteName, tag, endpoint, sf)
+			} else {
+				err = srv.pushRepository(r, job.Stdout, localName, remoteName, localRepo, tag, sf)
+			}
+			if err != nil {
 				return job.Error(err)
 			}
 			return engine.StatusOK
@@ -1611,24 +1950,32 @@ func (srv *Server) ImagePush(job *engine.Job) engine.Status {
 	return engine.StatusOK
 }
 
+// ImageImport creates an image from the filesystem of a tar archive. The
+// configuration of the container of an archive of ContainerExport is the
+// one of the image, with the changes given in the syntax of the Dockerfile
+// instructions applied.
 func (srv *Server) ImageImport(job *engine.Job) engine.Status {
 	if n := len(job.Args); n != 2 && n != 3 {
 		return job.Errorf("Usage: %s SRC REPO [TAG]", job.Name)
 	}
+	// A prune waits for the images being imported to be tagged
+	srv.imageJobs.RLock()
+	defer srv.imageJobs.RUnlock()
+
 	var (
-		src     = job.Args[0]
-		repo    = job.Args[1]
-		tag     string
-		sf      = utils.NewStreamFormatter(job.GetenvBool("json"))
-		archive archive.ArchiveReader
-		resp    *http.Response
+		src  = job.Args[0]
+		repo = job.Args[1]
+		tag  string
+		sf   = utils.NewStreamFormatter(job.GetenvBool("json"))
+		data archive.ArchiveReader
+		resp *http.Response
 	)
 	if len(job.Args) > 2 {
 		tag = job.Args[2]
 	}
 
 	if src == "-" {
-		archive = job.Stdin
+		data = job.Stdin
 	} else {
 		u, err := url.Parse(src)
 		if err != nil {
@@ -1648,12 +1995,43 @@ func (srv *Server) ImageImport(job *engine.Job) engine.Status {
 		}
 		progressReader := utils.ProgressReader(resp.Body, int(resp.ContentLength), job.Stdout, sf, true, "", "Importing")
 		defer progressReader.Close()
-		archive = progressReader
+		data = progressReader
+	}
+
+	decompressed, err := archive.DecompressStream(data)
+	if err != nil {
+		return job.Error(err)
 	}
-	img, err := srv.daemon.Graph().Create(archive, "", "", "Imported from "+src, "", nil, nil)
+	defer decompressed.Close()
+	exported, layer, err := readExportConfig(decompressed)
 	if err != nil {
 		return job.Error(err)
 	}
+	var config *runconfig.Config
+	if exported != nil && exported.Config != nil {
+		config = exported.Config
+		// The hostname and the image are the ones of the exporting host
+		config.Hostname = ""
+		config.Image = ""
+	}
+	if changes := job.GetenvList("changes"); len(changes) > 0 {
+		if config == nil {
+			config = &runconfig.Config{}
+		}
+		if err := applyChanges(config, changes); err != nil {
+			return job.Error(err)
+		}
+	}
+
+	img, err := srv.daemon.Graph().Create(layer, "", "", "Imported from "+src, "", nil, config)
+	if err != nil {
+		return job.Error(err)
+	}
+	if exported != nil && exported.HostConfig != nil {
+		if options := runOptions(exported.HostConfig); len(options) > 0 {
+			job.Stdout.Write(sf.FormatStatus("", "The exported container ran with the options %s", strings.Join(options, " ")))
+		}
+	}
 	// Optionally register the image at REPO/TAG
 	if repo != "" {
 		if err := srv.daemon.Repositories().Set(repo, tag, img.ID, true); err != nil {
@@ -2359,13 +2737,16 @@ func NewServer(eng *engine.Engine, config *daemonconfig.Config) (*Server, error)
 		return nil, err
 	}
 	srv := &Server{
-		Eng:         eng,
-		daemon:      daemon,
-		pullingPool: make(map[string]chan struct{}),
-		pushingPool: make(map[string]chan struct{}),
-		events:      make([]utils.JSONMessage, 0, 64), //only keeps the 64 last events
-		listeners:   make(map[int64]chan utils.JSONMessage),
-		running:     true,
+		Eng:             eng,
+		daemon:          daemon,
+		pullingPool:     make(map[string]chan struct{}),
+		pushingPool:     make(map[string]chan struct{}),
+		downloadSlots:   make(chan struct{}, config.MaxConcurrentDownloads),
+		uploadSlots:     make(chan struct{}, config.MaxConcurrentUploads),
+		downloadRetries: config.DownloadRetries,
+		events:          make([]utils.JSONMessage, 0, 64), //only keeps the 64 last events
+		listeners:       make(map[int64]chan utils.JSONMessage),
+		running:         true,
 	}
 	daemon.SetServer(srv)
 	return srv, nil
@@ -2425,8 +2806,16 @@ type Server struct {
 	daemon      *daemon.Daemon
 	pullingPool map[string]chan struct{}
 	pushingPool map[string]chan struct{}
-	events      []utils.JSONMessage
-	listeners   map[int64]chan utils.JSONMessage
-	Eng         *engine.Engine
-	running     bool
+	// imageJobs is read locked by the builds, imports and loads, whose
+	// images are untagged until they are done, and locked by the prunes
+	imageJobs sync.RWMutex
+	// downloadSlots and uploadSlots bound the number of layers
+	// downloaded and uploaded at once
+	downloadSlots   chan struct{}
+	uploadSlots     chan struct{}
+	downloadRetries int
+	events          []utils.JSONMessage
+	listeners       map[int64]chan utils.JSONMessage
+	Eng             *engine.Engine
+	running         bool
 }
@@ -1,10 +1,24 @@
 package server
 
 import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
 	"testing"
 	"time"
 
+	"github.com/dotcloud/docker/daemon"
+	"github.com/dotcloud/docker/daemon/graphdriver"
+	"github.com/dotcloud/docker/graph"
+	"github.com/dotcloud/docker/image"
+	"github.com/dotcloud/docker/nat"
+	"github.com/dotcloud/docker/runconfig"
 	"github.com/dotcloud/docker/utils"
+	"github.com/dotcloud/docker/vendor/src/code.google.com/p/go/src/pkg/archive/tar"
 )
 
 func TestPools(t *testing.T) {
@@ -98,3 +112,323 @@ func setTimeout(t *testing.T, msg string, d time.Duration, f func()) {
 		t.Fatal(msg)
 	}
 }
+
+// testArchive returns a tar archive of one file of the given content
+func testArchive(t *testing.T, name, content string) []byte {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Write([]byte(content))
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// firstFile returns the name and the content of the first file of a tar archive
+func firstFile(t *testing.T, data []byte) (string, string) {
+	tr := tar.NewReader(bytes.NewReader(data))
+	hdr, err := tr.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadAll(tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hdr.Name, string(content)
+}
+
+func TestExportConfig(t *testing.T) {
+	container := &daemon.Container{
+		Config: &runconfig.Config{Cmd: []string{"/bin/echo", "hello"}, Env: []string{"A=1"}},
+	}
+	buf := new(bytes.Buffer)
+	if err := writeExportConfig(buf, container); err != nil {
+		t.Fatal(err)
+	}
+	buf.Write(testArchive(t, "hello", "hello world"))
+
+	exported, layer, err := readExportConfig(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exported == nil || !reflect.DeepEqual(exported.Config.Cmd, container.Config.Cmd) {
+		t.Fatalf("Expected the configuration of the container, got %+v", exported)
+	}
+	data, err := ioutil.ReadAll(layer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, content := firstFile(t, data); name != "hello" || content != "hello world" {
+		t.Fatalf("Expected the archive of the filesystem, got %s: %q", name, content)
+	}
+
+	// Any other archive is kept whole
+	archive := testArchive(t, "other", "other content")
+	exported, layer, err = readExportConfig(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exported != nil {
+		t.Fatalf("Expected no configuration, got %+v", exported)
+	}
+	if data, err := ioutil.ReadAll(layer); err != nil || !bytes.Equal(data, archive) {
+		t.Fatalf("Expected the whole archive, got %v", err)
+	}
+}
+
+func TestRunOptions(t *testing.T) {
+	hostConfig := &runconfig.HostConfig{
+		Binds:       []string{"/srv/data:/data:ro"},
+		Links:       []string{"/db:/web/db"},
+		NetworkMode: "bridge",
+		PortBindings: nat.PortMap{
+			"80/tcp":  {{HostPort: "8080"}},
+			"53/udp":  {{HostIp: "127.0.0.1", HostPort: "53"}},
+			"443/tcp": {{}},
+		},
+		LxcConf: []utils.KeyValuePair{{Key: "lxc.utsname", Value: "my host"}},
+	}
+	expected := []string{
+		"-p", "443",
+		"-p", "127.0.0.1:53:53/udp",
+		"-p", "8080:80",
+		"-v", "/srv/data:/data:ro",
+		"--link", "/db:/web/db",
+		"--lxc-conf", `"lxc.utsname=my host"`,
+	}
+	if options := runOptions(hostConfig); !reflect.DeepEqual(options, expected) {
+		t.Fatalf("Expected %v, got %v", expected, options)
+	}
+	if options := runOptions(&runconfig.HostConfig{}); len(options) != 0 {
+		t.Fatalf("Expected no options, got %v", options)
+	}
+}
+
+func TestApplyChanges(t *testing.T) {
+	config := &runconfig.Config{Env: []string{"A=1", "B=2"}, WorkingDir: "/srv"}
+	changes := []string{
+		`CMD ["/bin/app", "--verbose"]`,
+		"ENTRYPOINT /bin/init",
+		"ENV B 3",
+		"env C 4",
+		"EXPOSE 80 53/udp",
+		"USER app",
+		`VOLUME ["/data"]`,
+		"WORKDIR app",
+	}
+	if err := applyChanges(config, changes); err != nil {
+		t.Fatal(err)
+	}
+	expected := &runconfig.Config{
+		Cmd:          []string{"/bin/app", "--verbose"},
+		Entrypoint:   []string{"/bin/sh", "-c", "/bin/init"},
+		Env:          []string{"A=1", "B=3", "C=4"},
+		ExposedPorts: nat.PortSet{"80/tcp": struct{}{}, "53/udp": struct{}{}},
+		User:         "app",
+		Volumes:      map[string]struct{}{"/data": {}},
+		WorkingDir:   "/srv/app",
+	}
+	if !reflect.DeepEqual(config, expected) {
+		t.Fatalf("Expected %+v, got %+v", expected, config)
+	}
+
+	for _, change := range []string{"RUN make", "CMD", "ENV A", "EXPOSE nope"} {
+		if err := applyChanges(&runconfig.Config{}, []string{change}); err == nil {
+			t.Fatalf("Expected the change %q to be refused", change)
+		}
+	}
+}
+
+func newTestGraph(t *testing.T) (*graph.Graph, string) {
+	root, err := ioutil.TempDir("", "docker-server-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	driver, err := graphdriver.GetDriver("vfs", root, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := graph.NewGraph(root, driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g, root
+}
+
+// registerMockImages registers images in g, the base image first
+func registerMockImages(t *testing.T, g *graph.Graph, images []*mockImage) {
+	for _, mock := range images {
+		img, err := image.NewImgJSON(mock.json)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := g.Register(mock.json, bytes.NewReader(mock.layer), img); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// completedLayers returns the ids of the layers reported complete in the
+// json output of a pull, in order
+func completedLayers(t *testing.T, out io.Reader) []string {
+	var ids []string
+	dec := json.NewDecoder(out)
+	for {
+		var jm utils.JSONMessage
+		if err := dec.Decode(&jm); err == io.EOF {
+			return ids
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if jm.Status == "Download complete" {
+			ids = append(ids, jm.ID)
+		}
+	}
+}
+
+func TestPullImageConcurrentDownloads(t *testing.T) {
+	images := newMockImages(t, 4)
+	m := newMockRegistry(images)
+	defer m.Close()
+	m.delay = 50 * time.Millisecond
+	g, root := newTestGraph(t)
+	defer os.RemoveAll(root)
+
+	srv := &Server{
+		pullingPool:   make(map[string]chan struct{}),
+		pushingPool:   make(map[string]chan struct{}),
+		downloadSlots: make(chan struct{}, 2),
+	}
+	out := &lockedBuffer{}
+	top := images[len(images)-1].id
+	if err := srv.pullImage(m.Registry(t), out, g, top, m.Endpoint(), nil, nil, utils.NewStreamFormatter(true)); err != nil {
+		t.Fatal(err)
+	}
+
+	if m.maxTransfers != 2 {
+		t.Fatalf("Expected 2 layers to be downloaded at once, got %d", m.maxTransfers)
+	}
+	var expected []string
+	for _, img := range images {
+		if !g.Exists(img.id) {
+			t.Fatalf("Expected %s to be registered", img.id)
+		}
+		expected = append(expected, utils.TruncateID(img.id))
+	}
+	if completed := completedLayers(t, out); !reflect.DeepEqual(completed, expected) {
+		t.Fatalf("Expected the layers to be registered in parent order %v, got %v", expected, completed)
+	}
+	if len(srv.pullingPool) != 0 {
+		t.Fatalf("Expected the pulling pool to be empty, got %v", srv.pullingPool)
+	}
+}
+
+func TestPullImageAbortsOnDownloadError(t *testing.T) {
+	images := newMockImages(t, 4)
+	m := newMockRegistry(images)
+	defer m.Close()
+	m.failLayer = images[1].id
+	g, root := newTestGraph(t)
+	defer os.RemoveAll(root)
+
+	srv := &Server{
+		pullingPool:   make(map[string]chan struct{}),
+		pushingPool:   make(map[string]chan struct{}),
+		downloadSlots: make(chan struct{}, 1),
+	}
+	top := images[len(images)-1].id
+	if err := srv.pullImage(m.Registry(t), ioutil.Discard, g, top, m.Endpoint(), nil, nil, utils.NewStreamFormatter(false)); err == nil {
+		t.Fatal("Expected the pull to fail")
+	}
+
+	if !g.Exists(images[0].id) {
+		t.Fatal("Expected the base layer to be registered")
+	}
+	for _, img := range images[1:] {
+		if g.Exists(img.id) {
+			t.Fatalf("Expected %s not to be registered", img.id)
+		}
+	}
+	if expected := []string{images[0].id, images[1].id}; !reflect.DeepEqual(m.downloaded, expected) {
+		t.Fatalf("Expected no download to start after the failure, downloaded %v", m.downloaded)
+	}
+	if len(srv.pullingPool) != 0 {
+		t.Fatalf("Expected the pulling pool to be empty, got %v", srv.pullingPool)
+	}
+	if len(srv.downloadSlots) != 0 {
+		t.Fatalf("Expected the download slots to be released, got %d", len(srv.downloadSlots))
+	}
+}
+
+func TestPushImagesConcurrentUploads(t *testing.T) {
+	images := newMockImages(t, 4)
+	m := newMockRegistry(nil)
+	defer m.Close()
+	m.delay = 50 * time.Millisecond
+	g, root := newTestGraph(t)
+	defer os.RemoveAll(root)
+	registerMockImages(t, g, images)
+
+	srv := &Server{uploadSlots: make(chan struct{}, 2)}
+	var imgList []string
+	for _, img := range images {
+		imgList = append(imgList, img.id)
+	}
+	tagsByImage := map[string][]string{imgList[len(imgList)-1]: {"latest"}}
+	archives := newLayerArchives(g)
+	defer archives.Cleanup()
+
+	// The archives are shared by the pushes to each endpoint
+	for i := 0; i < 2; i++ {
+		if err := srv.pushImages(m.Registry(t), ioutil.Discard, "foo/bar", imgList, tagsByImage, m.Endpoint(), nil, archives, utils.NewStreamFormatter(false)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if m.maxTransfers != 2 {
+		t.Fatalf("Expected 2 layers to be uploaded at once, got %d", m.maxTransfers)
+	}
+	if len(m.pushed) != 2*len(images) {
+		t.Fatalf("Expected %d images to be pushed, got %d", 2*len(images), len(m.pushed))
+	}
+	// Each archive is rendered in its own temporary directory
+	tmps, err := ioutil.ReadDir(path.Join(g.Root, "_tmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tmps) != len(images) {
+		t.Fatalf("Expected %d layer archives to be rendered, got %d", len(images), len(tmps))
+	}
+}
+
+func TestPushImagesAbortsOnError(t *testing.T) {
+	images := newMockImages(t, 4)
+	m := newMockRegistry(nil)
+	defer m.Close()
+	m.failJSON = images[1].id
+	g, root := newTestGraph(t)
+	defer os.RemoveAll(root)
+	registerMockImages(t, g, images)
+
+	srv := &Server{uploadSlots: make(chan struct{}, 1)}
+	var imgList []string
+	for _, img := range images {
+		imgList = append(imgList, img.id)
+	}
+	archives := newLayerArchives(g)
+	defer archives.Cleanup()
+
+	if err := srv.pushImages(m.Registry(t), ioutil.Discard, "foo/bar", imgList, nil, m.Endpoint(), nil, archives, utils.NewStreamFormatter(false)); err == nil {
+		t.Fatal("Expected the push to fail")
+	}
+	if expected := []string{images[0].id}; !reflect.DeepEqual(m.pushed, expected) {
+		t.Fatalf("Expected no image to be pushed after the failure, pushed %v", m.pushed)
+	}
+	if len(srv.uploadSlots) != 0 {
+		t.Fatalf("Expected the upload slots to be released, got %d", len(srv.uploadSlots))
+	}
+}
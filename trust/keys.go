@@ -0,0 +1,87 @@
+package trust
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+// KeyID returns the id of a public key, the hex encoded sha256
+// of its DER encoding
+func KeyID(pub *ecdsa.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(der)
+	return hex.EncodeToString(h[:]), nil
+}
+
+// LoadOrCreateKey loads the private key of the daemon from keyFile, a new one
+// is generated if it doesn't exist. The public key is written next to it, as
+// keyFile with the .pub extension, to be distributed to the daemons which
+// should trust the images signed with it.
+func LoadOrCreateKey(keyFile string) (*ecdsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(keyFile)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil || block.Type != "EC PRIVATE KEY" {
+			return nil, fmt.Errorf("No EC private key found in %s", keyFile)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(path.Dir(keyFile), 0700); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return nil, err
+	}
+	pubDer, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(keyFile+".pub", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDer}), 0644); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// LoadPublicKey loads the PEM encoded public key of keyFile
+func LoadPublicKey(keyFile string) (*ecdsa.PublicKey, error) {
+	data, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("No public key found in %s", keyFile)
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	ecPub, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("The key of %s is not an ECDSA public key", keyFile)
+	}
+	return ecPub, nil
+}
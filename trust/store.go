@@ -0,0 +1,147 @@
+package trust
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/dotcloud/docker/utils"
+)
+
+// The only algorithm used so far, ECDSA on the P-256 curve with sha256
+const algES256 = "ES256"
+
+// Signature is the signature of the manifest of an image by a key
+type Signature struct {
+	KeyID     string `json:"keyid"`
+	Algorithm string `json:"alg"`
+	Signature string `json:"signature"`
+}
+
+// Verification is the result of the verification of the signatures
+// of an image, KeyID is the trusted key which signed it or, if none
+// did, the first key which did
+type Verification struct {
+	Signed  bool
+	Trusted bool
+	KeyID   string `json:",omitempty"`
+}
+
+func (v *Verification) String() string {
+	switch {
+	case v.Trusted:
+		return fmt.Sprintf("verified, signed by trusted key %s", utils.TruncateID(v.KeyID))
+	case v.Signed:
+		return fmt.Sprintf("signed by untrusted key %s", utils.TruncateID(v.KeyID))
+	}
+	return "unsigned"
+}
+
+// Store signs the manifests of the images pushed by the daemon and
+// verifies the ones of the images it pulls against the trusted keys
+type Store struct {
+	key        *ecdsa.PrivateKey
+	keyID      string
+	trusted    map[string]*ecdsa.PublicKey
+	namespaces []string
+}
+
+// NewStore loads, or creates, the signing key of the daemon from keyFile
+// and the trusted public keys from trustedKeys. The images of the
+// repositories under the namespaces must be signed by a trusted key.
+func NewStore(keyFile string, trustedKeys, namespaces []string) (*Store, error) {
+	key, err := LoadOrCreateKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	keyID, err := KeyID(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{
+		key:        key,
+		keyID:      keyID,
+		trusted:    make(map[string]*ecdsa.PublicKey),
+		namespaces: namespaces,
+	}
+	for _, f := range trustedKeys {
+		pub, err := LoadPublicKey(f)
+		if err != nil {
+			return nil, err
+		}
+		id, err := KeyID(pub)
+		if err != nil {
+			return nil, err
+		}
+		s.trusted[id] = pub
+	}
+	return s, nil
+}
+
+// KeyID returns the id of the signing key of the daemon
+func (s *Store) KeyID() string {
+	return s.keyID
+}
+
+// Sign signs payload with the key of the daemon
+func (s *Store) Sign(payload []byte) (*Signature, error) {
+	h := sha256.Sum256(payload)
+	r, ss, err := ecdsa.Sign(rand.Reader, s.key, h[:])
+	if err != nil {
+		return nil, err
+	}
+	// r and s are padded to the size of the curve
+	sig := make([]byte, 64)
+	rBytes, sBytes := r.Bytes(), ss.Bytes()
+	copy(sig[32-len(rBytes):32], rBytes)
+	copy(sig[64-len(sBytes):], sBytes)
+	return &Signature{
+		KeyID:     s.keyID,
+		Algorithm: algES256,
+		Signature: base64.URLEncoding.EncodeToString(sig),
+	}, nil
+}
+
+// Verify checks the signatures of payload. A signature by a trusted key
+// which does not match payload is an error, the content was altered.
+func (s *Store) Verify(payload []byte, signatures []*Signature) (*Verification, error) {
+	v := &Verification{}
+	h := sha256.Sum256(payload)
+	for _, sig := range signatures {
+		if !v.Signed {
+			v.Signed = true
+			v.KeyID = sig.KeyID
+		}
+		pub, exists := s.trusted[sig.KeyID]
+		if !exists {
+			continue
+		}
+		data, err := base64.URLEncoding.DecodeString(sig.Signature)
+		if err != nil || sig.Algorithm != algES256 || len(data) != 64 {
+			return nil, fmt.Errorf("Invalid signature by key %s", utils.TruncateID(sig.KeyID))
+		}
+		r, ss := new(big.Int).SetBytes(data[:32]), new(big.Int).SetBytes(data[32:])
+		if !ecdsa.Verify(pub, h[:], r, ss) {
+			return nil, fmt.Errorf("The signature by trusted key %s does not match the content", utils.TruncateID(sig.KeyID))
+		}
+		v.Trusted = true
+		v.KeyID = sig.KeyID
+		return v, nil
+	}
+	return v, nil
+}
+
+// RequiresTrust returns true if the images of the repository must be
+// signed by a trusted key
+func (s *Store) RequiresTrust(repoName string) bool {
+	for _, ns := range s.namespaces {
+		if repoName == ns || strings.HasPrefix(repoName, ns+"/") {
+			return true
+		}
+	}
+	return false
+}
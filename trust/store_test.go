@@ -0,0 +1,89 @@
+package trust
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func newTestStore(t *testing.T, dir string, trustedKeys []string) *Store {
+	s, err := NewStore(path.Join(dir, "key.pem"), trustedKeys, []string{"myorg", "registry.example.com/team"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSignVerify(t *testing.T) {
+	signerDir, err := ioutil.TempDir("", "docker-trust-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(signerDir)
+	verifierDir, err := ioutil.TempDir("", "docker-trust-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(verifierDir)
+
+	signer := newTestStore(t, signerDir, nil)
+	// The key is loaded again rather than generated
+	if s := newTestStore(t, signerDir, nil); s.KeyID() != signer.KeyID() {
+		t.Fatalf("Expected key %s, got %s", signer.KeyID(), s.KeyID())
+	}
+	verifier := newTestStore(t, verifierDir, []string{path.Join(signerDir, "key.pem.pub")})
+	untrusting := newTestStore(t, verifierDir, nil)
+
+	payload := []byte(`{"name":"myorg/app"}`)
+	sig, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := verifier.Verify(payload, []*Signature{sig})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.Signed || !v.Trusted || v.KeyID != signer.KeyID() {
+		t.Fatalf("Expected the payload to be trusted, got %s", v)
+	}
+
+	v, err = untrusting.Verify(payload, []*Signature{sig})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.Signed || v.Trusted {
+		t.Fatalf("Expected the payload to be signed by an untrusted key, got %s", v)
+	}
+
+	if v, err = verifier.Verify(payload, nil); err != nil || v.Signed {
+		t.Fatalf("Expected the payload to be unsigned, got %s, %v", v, err)
+	}
+
+	if _, err := verifier.Verify([]byte(`{"name":"myorg/evil"}`), []*Signature{sig}); err == nil {
+		t.Fatal("Expected an error verifying an altered payload")
+	}
+}
+
+func TestRequiresTrust(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker-trust-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := newTestStore(t, dir, nil)
+
+	for repoName, required := range map[string]bool{
+		"myorg/app":                      true,
+		"myorg":                          true,
+		"myorganization/app":             false,
+		"ubuntu":                         false,
+		"registry.example.com/team/app":  true,
+		"registry.example.com/other/app": false,
+	} {
+		if s.RequiresTrust(repoName) != required {
+			t.Errorf("Expected RequiresTrust(%s) to be %v", repoName, required)
+		}
+	}
+}
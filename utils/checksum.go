@@ -13,7 +13,7 @@ type CheckSum struct {
 
 func (cs *CheckSum) Read(buf []byte) (int, error) {
 	n, err := cs.Reader.Read(buf)
-	if err == nil {
+	if n > 0 {
 		cs.Hash.Write(buf[:n])
 	}
 	return n, err
@@ -4,15 +4,20 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"sync"
 )
 
+// StreamFormatter is safe for concurrent use: the pull and the push of
+// several layers format their progress from parallel goroutines.
 type StreamFormatter struct {
 	json bool
+
+	sync.Mutex
 	used bool
 }
 
 func NewStreamFormatter(json bool) *StreamFormatter {
-	return &StreamFormatter{json, false}
+	return &StreamFormatter{json: json}
 }
 
 const streamNewline = "\r\n"
@@ -20,7 +25,7 @@ const streamNewline = "\r\n"
 var streamNewlineBytes = []byte(streamNewline)
 
 func (sf *StreamFormatter) FormatStream(str string) []byte {
-	sf.used = true
+	sf.setUsed()
 	if sf.json {
 		b, err := json.Marshal(&JSONMessage{Stream: str})
 		if err != nil {
@@ -32,7 +37,7 @@ func (sf *StreamFormatter) FormatStream(str string) []byte {
 }
 
 func (sf *StreamFormatter) FormatStatus(id, format string, a ...interface{}) []byte {
-	sf.used = true
+	sf.setUsed()
 	str := fmt.Sprintf(format, a...)
 	if sf.json {
 		b, err := json.Marshal(&JSONMessage{ID: id, Status: str})
@@ -45,7 +50,7 @@ func (sf *StreamFormatter) FormatStatus(id, format string, a ...interface{}) []b
 }
 
 func (sf *StreamFormatter) FormatError(err error) []byte {
-	sf.used = true
+	sf.setUsed()
 	if sf.json {
 		jsonError, ok := err.(*JSONError)
 		if !ok {
@@ -63,7 +68,7 @@ func (sf *StreamFormatter) FormatProgress(id, action string, progress *JSONProgr
 	if progress == nil {
 		progress = &JSONProgress{}
 	}
-	sf.used = true
+	sf.setUsed()
 	if sf.json {
 
 		b, err := json.Marshal(&JSONMessage{
@@ -84,7 +89,15 @@ func (sf *StreamFormatter) FormatProgress(id, action string, progress *JSONProgr
 	return []byte(action + " " + progress.String() + endl)
 }
 
+func (sf *StreamFormatter) setUsed() {
+	sf.Lock()
+	sf.used = true
+	sf.Unlock()
+}
+
 func (sf *StreamFormatter) Used() bool {
+	sf.Lock()
+	defer sf.Unlock()
 	return sf.used
 }
 
@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"io/ioutil"
+	"sync"
+	"testing"
+)
+
+func TestStreamFormatterConcurrentUse(t *testing.T) {
+	sf := NewStreamFormatter(true)
+	out := NewLockedWriter(ioutil.Discard)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			out.Write(sf.FormatProgress("id", "Downloading", &JSONProgress{Current: 1, Total: 2}))
+			out.Write(sf.FormatStatus("id", "Download complete"))
+		}()
+	}
+	wg.Wait()
+	if !sf.Used() {
+		t.Fatal("Expected the formatter to be used")
+	}
+}
@@ -711,6 +711,22 @@ func NewWriteFlusher(w io.Writer) *WriteFlusher {
 	return &WriteFlusher{w: w, flusher: flusher}
 }
 
+// lockedWriter serializes the writes of the goroutines sharing a writer
+type lockedWriter struct {
+	sync.Mutex
+	w io.Writer
+}
+
+func (lw *lockedWriter) Write(b []byte) (int, error) {
+	lw.Lock()
+	defer lw.Unlock()
+	return lw.w.Write(b)
+}
+
+func NewLockedWriter(w io.Writer) io.Writer {
+	return &lockedWriter{w: w}
+}
+
 func NewHTTPRequestError(msg string, res *http.Response) error {
 	return &JSONError{
 		Message: msg,
@@ -824,7 +840,12 @@ func ParseHost(defaultHost string, defaultUnix, addr string) (string, error) {
 // Get a repos name and returns the right reposName + tag
 // The tag can be confusing because of a port in a repository name.
 //     Ex: localhost.localdomain:5000/samalba/hipache:latest
+//
+// The tag may also be a digest, as in name@sha256:hex
 func ParseRepositoryTag(repos string) (string, string) {
+	if n := strings.Index(repos, "@"); n >= 0 {
+		return repos[:n], repos[n+1:]
+	}
 	n := strings.LastIndex(repos, ":")
 	if n < 0 {
 		return repos, ""